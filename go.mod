@@ -8,9 +8,13 @@ require (
 	github.com/openconfig/gnmi v0.0.0-20210707145734-c69a5df04b53
 	github.com/openconfig/goyang v1.0.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
 	github.com/stoewer/go-strcase v1.2.0
 	github.com/wI2L/jsondiff v0.1.0
 	github.com/yndd/ndd-runtime v0.1.1
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	google.golang.org/grpc v1.39.0
 	google.golang.org/protobuf v1.27.1
 	sigs.k8s.io/controller-runtime v0.9.3
 )
@@ -0,0 +1,574 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package allocator is an in-memory allocator of ip prefixes, ip addresses and
+// integers (asn, vlan, index, ...). Pools are registered per resource path and
+// carry labels; allocations select the pools by label and are idempotent per
+// owner. The allocations can be persisted in a state cache.
+package allocator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pkg/errors"
+	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+)
+
+const (
+	// errors
+	errNoPools        = "no pools found"
+	errNoOwner        = "owner is required"
+	errNoFreeResource = "no free resource found in the pools matching the selector"
+	errPoolNotFound   = "pool not found"
+	errPoolInUse      = "pool has allocations"
+
+	// defaultStateTarget is the target of the state cache the allocations are
+	// persisted in if the prefix has no target
+	defaultStateTarget = "allocator"
+)
+
+type Allocator interface {
+	// AddPrefix adds an ip prefix pool to the resource identified by pe
+	AddPrefix(pe []*gnmi.PathElem, prefix string, labels map[string]string) error
+	// AddRange adds an ip range pool to the resource identified by pe, the range
+	// needs to be part of a prefix pool of the same resource
+	AddRange(pe []*gnmi.PathElem, start, end string, labels map[string]string) error
+	// AddIntPool adds an integer pool to the resource identified by pe
+	AddIntPool(pe []*gnmi.PathElem, name string, start, end uint32, labels map[string]string) error
+	// DeletePool deletes the pool with the given name from the resource identified by pe
+	DeletePool(pe []*gnmi.PathElem, name string) error
+	Allocate(pe []*gnmi.PathElem, d interface{}) (interface{}, error)
+	DeAllocate(pe []*gnmi.PathElem, d interface{}) (interface{}, error)
+	Query(pe []*gnmi.PathElem, d interface{}) (interface{}, error)
+	UpdateStateCache(pe []*gnmi.PathElem) error
+	DeleteStateCache(pe []*gnmi.PathElem) error
+}
+
+// Request is the allocation request, supplied as d to Allocate, DeAllocate and Query
+type Request struct {
+	// Owner identifies the owner of the allocation
+	Owner string `json:"owner,omitempty"`
+	// Selector selects the pools based on the labels of the pools
+	Selector map[string]string `json:"selector,omitempty"`
+	// PrefixLength allocates a prefix iso an address, only valid for prefix pools
+	PrefixLength int `json:"prefix-length,omitempty"`
+}
+
+// Allocation is the result of an allocation
+type Allocation struct {
+	Owner string `json:"owner"`
+	Pool  string `json:"pool"`
+	Value string `json:"value"`
+}
+
+// Option can be used to manipulate Options.
+type Option func(a *allocator)
+
+func WithLogging(log logging.Logger) Option {
+	return func(a *allocator) {
+		a.log = log
+	}
+}
+
+// WithStateCache initializes the state cache the allocations are persisted in.
+// The allocations of a pool are restored from the state cache when the pool is
+// added.
+func WithStateCache(c *cache.Cache) Option {
+	return func(a *allocator) {
+		a.stateCache = c
+	}
+}
+
+// WithPrefix initializes the prefix used for the state cache, without target
+// the allocations are persisted in the target "allocator".
+func WithPrefix(p *gnmi.Path) Option {
+	return func(a *allocator) {
+		a.prefix = p
+	}
+}
+
+type allocator struct {
+	log        logging.Logger
+	stateCache *cache.Cache
+	prefix     *gnmi.Path
+
+	mu    sync.Mutex
+	trees map[string]*tree
+}
+
+// tree holds the pools of a single resource, e.g. a network-instance
+type tree struct {
+	pe    []*gnmi.PathElem
+	pools map[string]*pool
+}
+
+func New(opts ...Option) Allocator {
+	a := &allocator{
+		log:   logging.NewNopLogger(),
+		trees: make(map[string]*tree),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.stateCache != nil {
+		if a.prefix.GetTarget() == "" {
+			p := yparser.DeepCopyGnmiPath(a.prefix)
+			p.Origin = a.prefix.GetOrigin()
+			p.Target = defaultStateTarget
+			a.prefix = p
+		}
+		if a.stateCache.GetCache().GetTarget(a.prefix.GetTarget()) == nil {
+			a.stateCache.GetCache().Add(a.prefix.GetTarget())
+		}
+	}
+	return a
+}
+
+func getTreeKey(pe []*gnmi.PathElem) string {
+	return yparser.GnmiPath2XPath(&gnmi.Path{Elem: pe}, true)
+}
+
+func (a *allocator) getTree(pe []*gnmi.PathElem, create bool) *tree {
+	key := getTreeKey(pe)
+	t, ok := a.trees[key]
+	if !ok && create {
+		t = &tree{
+			pe:    yparser.DeepCopyGnmiPath(&gnmi.Path{Elem: pe}).GetElem(),
+			pools: make(map[string]*pool),
+		}
+		a.trees[key] = t
+	}
+	return t
+}
+
+func (a *allocator) AddPrefix(pe []*gnmi.PathElem, prefix string, labels map[string]string) error {
+	p, err := newPrefixPool(prefix, labels)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addPool(a.getTree(pe, true), p)
+}
+
+func (a *allocator) AddRange(pe []*gnmi.PathElem, start, end string, labels map[string]string) error {
+	p, err := newRangePool(start, end, labels)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.getTree(pe, true)
+	// a range is always part of a prefix
+	for _, pp := range t.pools {
+		if pp.kind == PoolKindPrefix && pp.ipv4 == p.ipv4 && pp.contains(&p.interval) {
+			return a.addPool(t, p)
+		}
+	}
+	return fmt.Errorf("range %s is not part of any prefix", p.name)
+}
+
+func (a *allocator) AddIntPool(pe []*gnmi.PathElem, name string, start, end uint32, labels map[string]string) error {
+	p, err := newIntPool(name, start, end, labels)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addPool(a.getTree(pe, true), p)
+}
+
+// addPool adds the pool p to the tree t, the allocations of a new pool are
+// restored from the state cache
+func (a *allocator) addPool(t *tree, p *pool) error {
+	_, exists := t.pools[p.name]
+	if err := t.addPool(p); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return a.restorePoolState(t.pe, p)
+}
+
+func (a *allocator) DeletePool(pe []*gnmi.PathElem, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.getTree(pe, false)
+	if t == nil {
+		return errors.New(errPoolNotFound)
+	}
+	p, ok := t.pools[name]
+	if !ok {
+		return errors.New(errPoolNotFound)
+	}
+	if len(p.allocations) != 0 {
+		return errors.New(errPoolInUse)
+	}
+	delete(t.pools, name)
+	if len(t.pools) == 0 {
+		delete(a.trees, getTreeKey(pe))
+	}
+	return a.deletePoolState(t.pe, p)
+}
+
+func (a *allocator) Allocate(pe []*gnmi.PathElem, d interface{}) (interface{}, error) {
+	r, err := getRequest(d)
+	if err != nil {
+		return nil, err
+	}
+	if r.Owner == "" {
+		return nil, errors.New(errNoOwner)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.getTree(pe, false)
+	if t == nil {
+		return nil, errors.New(errNoPools)
+	}
+	// an owner only gets a single allocation in the resource, a reallocation
+	// returns the existing one, also if it is part of a pool which does not
+	// match the selector
+	for _, p := range t.getPools(nil) {
+		if al, ok := p.allocations[r.Owner]; ok {
+			return getAllocation(p, al), nil
+		}
+	}
+	for _, p := range t.getPools(r.Selector) {
+		if r.PrefixLength != 0 {
+			bits := net.IPv6len * 8
+			if p.ipv4 {
+				bits = net.IPv4len * 8
+			}
+			if p.kind != PoolKindPrefix || r.PrefixLength < p.length || r.PrefixLength > bits {
+				continue
+			}
+		}
+		if al, ok := p.allocate(r.Owner, r.PrefixLength, t.getChildren(p)); ok {
+			p.allocations[r.Owner] = al
+			a.log.Debug("allocate", "path", getTreeKey(pe), "pool", p.name, "owner", r.Owner, "value", p.value(al))
+			return getAllocation(p, al), nil
+		}
+	}
+	return nil, errors.New(errNoFreeResource)
+}
+
+func (a *allocator) DeAllocate(pe []*gnmi.PathElem, d interface{}) (interface{}, error) {
+	r, err := getRequest(d)
+	if err != nil {
+		return nil, err
+	}
+	if r.Owner == "" {
+		return nil, errors.New(errNoOwner)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.getTree(pe, false)
+	if t == nil {
+		return nil, nil
+	}
+	for _, p := range t.getPools(nil) {
+		if al, ok := p.allocations[r.Owner]; ok {
+			delete(p.allocations, r.Owner)
+			a.log.Debug("deallocate", "path", getTreeKey(pe), "pool", p.name, "owner", r.Owner, "value", p.value(al))
+			return getAllocation(p, al), nil
+		}
+	}
+	return nil, nil
+}
+
+func (a *allocator) Query(pe []*gnmi.PathElem, d interface{}) (interface{}, error) {
+	r, err := getRequest(d)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	allocations := make([]*Allocation, 0)
+	t := a.getTree(pe, false)
+	if t == nil {
+		return allocations, nil
+	}
+	for _, p := range t.getPools(r.Selector) {
+		for _, owner := range p.getOwners() {
+			if r.Owner == "" || r.Owner == owner {
+				allocations = append(allocations, getAllocation(p, p.allocations[owner]))
+			}
+		}
+	}
+	return allocations, nil
+}
+
+// UpdateStateCache replaces the allocations of the resource identified by pe in the state cache
+func (a *allocator) UpdateStateCache(pe []*gnmi.PathElem) error {
+	if a.stateCache == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.getTree(pe, false)
+	if t == nil {
+		return nil
+	}
+	if err := a.deleteTreeState(t); err != nil {
+		return err
+	}
+	updates := make([]*gnmi.Update, 0)
+	for _, p := range t.getPools(nil) {
+		for _, owner := range p.getOwners() {
+			allocPath := &gnmi.Path{Elem: append(getPoolPath(t.pe, p).GetElem(),
+				&gnmi.PathElem{Name: "allocation", Key: map[string]string{"owner": owner}},
+			)}
+			for leaf, value := range map[string]string{
+				"owner": owner,
+				"value": p.value(p.allocations[owner]),
+			} {
+				u, err := getUpdate(allocPath, leaf, value)
+				if err != nil {
+					return err
+				}
+				updates = append(updates, u)
+			}
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return a.stateCache.GnmiUpdate(a.prefix.GetTarget(), &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    a.prefix,
+		Update:    updates,
+	})
+}
+
+// DeleteStateCache deletes the allocations of the resource identified by pe from the state cache
+func (a *allocator) DeleteStateCache(pe []*gnmi.PathElem) error {
+	if a.stateCache == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.getTree(pe, false)
+	if t == nil {
+		return nil
+	}
+	return a.deleteTreeState(t)
+}
+
+func (a *allocator) deleteTreeState(t *tree) error {
+	for _, p := range t.pools {
+		if err := a.deletePoolState(t.pe, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *allocator) deletePoolState(pe []*gnmi.PathElem, p *pool) error {
+	if a.stateCache == nil {
+		return nil
+	}
+	return a.stateCache.GnmiUpdate(a.prefix.GetTarget(), &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    a.prefix,
+		Delete: []*gnmi.Path{
+			{Elem: append(getPoolPath(pe, p).GetElem(), &gnmi.PathElem{Name: "allocation"})},
+		},
+	})
+}
+
+// restorePoolState restores the allocations of the pool p of the resource
+// identified by pe from the state cache, the allocations which are not part of
+// the pool or overlap with an allocation of the pool are skipped
+func (a *allocator) restorePoolState(pe []*gnmi.PathElem, p *pool) error {
+	if a.stateCache == nil {
+		return nil
+	}
+	valuePath := &gnmi.Path{Elem: append(getPoolPath(pe, p).GetElem(),
+		&gnmi.PathElem{Name: "allocation", Key: map[string]string{"owner": "*"}},
+		&gnmi.PathElem{Name: "value"},
+	)}
+	ns, err := a.stateCache.QueryAll(a.prefix.GetTarget(), a.prefix, valuePath)
+	if err != nil {
+		return err
+	}
+	for _, n := range ns {
+		for _, u := range n.GetUpdate() {
+			owner := getOwner(append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), u.GetPath().GetElem()...))
+			v, err := yparser.GetValue(u.GetVal())
+			if err != nil {
+				return err
+			}
+			value, ok := v.(string)
+			if owner == "" || !ok {
+				continue
+			}
+			al, err := p.parseAllocation(owner, value)
+			if err != nil || !p.contains(&al.interval) || p.overlapsAllocation(&al.interval) {
+				a.log.Debug("skip allocation", "path", getTreeKey(pe), "pool", p.name, "owner", owner, "value", value)
+				continue
+			}
+			p.allocations[owner] = al
+			a.log.Debug("restore allocation", "path", getTreeKey(pe), "pool", p.name, "owner", owner, "value", value)
+		}
+	}
+	return nil
+}
+
+// getOwner returns the owner key of the allocation element of the path elements
+func getOwner(elems []*gnmi.PathElem) string {
+	for _, pe := range elems {
+		if pe.GetName() == "allocation" {
+			return pe.GetKey()["owner"]
+		}
+	}
+	return ""
+}
+
+// addPool adds a pool to the tree, re-adding an existing pool updates its labels
+func (t *tree) addPool(p *pool) error {
+	if ep, ok := t.pools[p.name]; ok {
+		if ep.kind != p.kind || ep.start.Cmp(p.start) != 0 || ep.end.Cmp(p.end) != 0 {
+			return fmt.Errorf("pool %s already exists with a different definition", p.name)
+		}
+		ep.labels = p.labels
+		return nil
+	}
+	if p.isIP() {
+		for _, ep := range t.pools {
+			if !ep.isIP() || ep.ipv4 != p.ipv4 || !ep.overlaps(&p.interval) {
+				continue
+			}
+			if !ep.contains(&p.interval) && !p.contains(&ep.interval) {
+				return fmt.Errorf("pool %s overlaps with pool %s", p.name, ep.name)
+			}
+			// the allocations of the parent pools cannot overlap with the new pool
+			if ep.contains(&p.interval) {
+				for owner, al := range ep.allocations {
+					if al.overlaps(&p.interval) {
+						return fmt.Errorf("pool %s overlaps with allocation of %s in pool %s", p.name, owner, ep.name)
+					}
+				}
+			}
+		}
+	}
+	t.pools[p.name] = p
+	return nil
+}
+
+// getPools returns the pools matching the selector, ranges come first,
+// followed by the prefixes from most to least specific, followed by the
+// integer pools
+func (t *tree) getPools(selector map[string]string) []*pool {
+	pools := make([]*pool, 0)
+	for _, p := range t.pools {
+		if p.matches(selector) {
+			pools = append(pools, p)
+		}
+	}
+	rank := map[PoolKind]int{PoolKindRange: 0, PoolKindPrefix: 1, PoolKindInt: 2}
+	sort.Slice(pools, func(i, j int) bool {
+		if rank[pools[i].kind] != rank[pools[j].kind] {
+			return rank[pools[i].kind] < rank[pools[j].kind]
+		}
+		if pools[i].length != pools[j].length {
+			return pools[i].length > pools[j].length
+		}
+		return pools[i].name < pools[j].name
+	})
+	return pools
+}
+
+// getChildren returns the intervals of the pools that are part of prefix pool p,
+// these are not allocatable from p
+func (t *tree) getChildren(p *pool) []*interval {
+	children := make([]*interval, 0)
+	if p.kind != PoolKindPrefix {
+		return children
+	}
+	for _, cp := range t.pools {
+		if cp != p && cp.isIP() && cp.ipv4 == p.ipv4 && p.contains(&cp.interval) {
+			children = append(children, &cp.interval)
+		}
+	}
+	return children
+}
+
+func (p *pool) getOwners() []string {
+	owners := make([]string, 0, len(p.allocations))
+	for owner := range p.allocations {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+func getAllocation(p *pool, al *allocation) *Allocation {
+	return &Allocation{
+		Owner: al.owner,
+		Pool:  p.name,
+		Value: p.value(al),
+	}
+}
+
+func getPoolPath(pe []*gnmi.PathElem, p *pool) *gnmi.Path {
+	path := yparser.DeepCopyGnmiPath(&gnmi.Path{Elem: pe})
+	path.Elem = append(path.GetElem(), p.getPathElem())
+	return path
+}
+
+func getUpdate(p *gnmi.Path, leaf, value string) (*gnmi.Update, error) {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	path := yparser.DeepCopyGnmiPath(p)
+	path.Elem = append(path.GetElem(), &gnmi.PathElem{Name: leaf})
+	return &gnmi.Update{
+		Path: path,
+		Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: v}},
+	}, nil
+}
+
+// getRequest transforms the data supplied to Allocate, DeAllocate or Query in a Request
+func getRequest(d interface{}) (*Request, error) {
+	switch r := d.(type) {
+	case nil:
+		return &Request{}, nil
+	case *Request:
+		return r, nil
+	case Request:
+		return &r, nil
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot marshal allocation request")
+		}
+		r2 := &Request{}
+		if err := json.Unmarshal(b, r2); err != nil {
+			return nil, errors.Wrap(err, "cannot unmarshal allocation request")
+		}
+		return r2, nil
+	}
+}
@@ -0,0 +1,211 @@
+package allocator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/cache"
+)
+
+func TestAllocate(t *testing.T) {
+	pe := []*gnmi.PathElem{
+		{Name: "ipam"},
+		{Name: "tenant", Key: map[string]string{"name": "default"}},
+		{Name: "network-instance", Key: map[string]string{"name": "default"}},
+	}
+	a := New()
+	if err := a.AddPrefix(pe, "10.0.0.0/24", map[string]string{"purpose": "loopback"}); err != nil {
+		t.Fatalf("AddPrefix: %v", err)
+	}
+	if err := a.AddPrefix(pe, "10.0.0.0/28", map[string]string{"purpose": "isl"}); err != nil {
+		t.Fatalf("AddPrefix: %v", err)
+	}
+	if err := a.AddRange(pe, "10.0.0.100", "10.0.0.101", map[string]string{"purpose": "dhcp"}); err != nil {
+		t.Fatalf("AddRange: %v", err)
+	}
+	if err := a.AddRange(pe, "192.168.0.1", "192.168.0.2", nil); err == nil {
+		t.Errorf("AddRange: expected error for a range outside of any prefix")
+	}
+	if err := a.AddPrefix(pe, "10.0.0.8/30", nil); err != nil {
+		t.Fatalf("AddPrefix: %v", err)
+	}
+	if err := a.AddIntPool(pe, "asn", 65000, 65001, map[string]string{"purpose": "asn"}); err != nil {
+		t.Fatalf("AddIntPool: %v", err)
+	}
+
+	tests := []struct {
+		inp    *Request
+		exp    string
+		expErr bool
+	}{
+		// the isl prefix is excluded from the loopback prefix, so is the range
+		{inp: &Request{Owner: "lo1", Selector: map[string]string{"purpose": "loopback"}}, exp: "10.0.0.16"},
+		{inp: &Request{Owner: "lo2", Selector: map[string]string{"purpose": "loopback"}}, exp: "10.0.0.17"},
+		// idempotent re-allocation
+		{inp: &Request{Owner: "lo1", Selector: map[string]string{"purpose": "loopback"}}, exp: "10.0.0.16"},
+		// the existing allocation is returned for a selector of another pool
+		{inp: &Request{Owner: "lo1", Selector: map[string]string{"purpose": "dhcp"}}, exp: "10.0.0.16"},
+		// sub-prefix allocations, 10.0.0.8/30 is part of the isl prefix
+		{inp: &Request{Owner: "isl1", Selector: map[string]string{"purpose": "isl"}, PrefixLength: 31}, exp: "10.0.0.0/31"},
+		{inp: &Request{Owner: "isl2", Selector: map[string]string{"purpose": "isl"}, PrefixLength: 31}, exp: "10.0.0.2/31"},
+		{inp: &Request{Owner: "isl3", Selector: map[string]string{"purpose": "isl"}, PrefixLength: 30}, exp: "10.0.0.4/30"},
+		{inp: &Request{Owner: "isl4", Selector: map[string]string{"purpose": "isl"}, PrefixLength: 30}, exp: "10.0.0.12/30"},
+		{inp: &Request{Owner: "isl5", Selector: map[string]string{"purpose": "isl"}, PrefixLength: 30}, expErr: true},
+		{inp: &Request{Owner: "dhcp1", Selector: map[string]string{"purpose": "dhcp"}}, exp: "10.0.0.100"},
+		{inp: &Request{Owner: "dhcp2", Selector: map[string]string{"purpose": "dhcp"}}, exp: "10.0.0.101"},
+		{inp: &Request{Owner: "dhcp3", Selector: map[string]string{"purpose": "dhcp"}}, expErr: true},
+		{inp: &Request{Owner: "as1", Selector: map[string]string{"purpose": "asn"}}, exp: "65000"},
+		{inp: &Request{Owner: "as2", Selector: map[string]string{"purpose": "asn"}}, exp: "65001"},
+		{inp: &Request{Owner: "as3", Selector: map[string]string{"purpose": "asn"}}, expErr: true},
+		{inp: &Request{Selector: map[string]string{"purpose": "asn"}}, expErr: true},
+	}
+
+	for i, tt := range tests {
+		x, err := a.Allocate(pe, tt.inp)
+		if tt.expErr {
+			if err == nil {
+				t.Errorf("Allocate %d: expected error, got %v", i, x)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Allocate %d: %v", i, err)
+			continue
+		}
+		if v := x.(*Allocation).Value; v != tt.exp {
+			t.Errorf("Allocate %d: got %s, want %s", i, v, tt.exp)
+		}
+	}
+
+	// a json request as supplied by the dispatcher
+	if _, err := a.DeAllocate(pe, map[string]interface{}{"owner": "as1"}); err != nil {
+		t.Errorf("DeAllocate: %v", err)
+	}
+	x, err := a.Allocate(pe, map[string]interface{}{"owner": "as3", "selector": map[string]interface{}{"purpose": "asn"}})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if v := x.(*Allocation).Value; v != "65000" {
+		t.Errorf("Allocate after DeAllocate: got %s, want 65000", v)
+	}
+
+	if err := a.DeletePool(pe, "asn"); err == nil {
+		t.Errorf("DeletePool: expected error for a pool with allocations")
+	}
+}
+
+func TestUpdateStateCache(t *testing.T) {
+	target := "ipam"
+	prefix := &gnmi.Path{Target: target}
+	pe := []*gnmi.PathElem{
+		{Name: "ipam"},
+		{Name: "tenant", Key: map[string]string{"name": "default"}},
+		{Name: "network-instance", Key: map[string]string{"name": "default"}},
+	}
+	sc := cache.New([]string{target})
+	a := New(WithStateCache(sc), WithPrefix(prefix))
+	if err := a.AddPrefix(pe, "2001:db8::/64", nil); err != nil {
+		t.Fatalf("AddPrefix: %v", err)
+	}
+	for _, owner := range []string{"a", "b"} {
+		if _, err := a.Allocate(pe, &Request{Owner: owner}); err != nil {
+			t.Fatalf("Allocate: %v", err)
+		}
+	}
+	if err := a.UpdateStateCache(pe); err != nil {
+		t.Fatalf("UpdateStateCache: %v", err)
+	}
+	p := &gnmi.Path{Elem: append(pe,
+		&gnmi.PathElem{Name: "ip-prefix", Key: map[string]string{"prefix": "2001:db8::/64"}},
+		&gnmi.PathElem{Name: "allocation", Key: map[string]string{"owner": "b"}},
+		&gnmi.PathElem{Name: "value"},
+	)}
+	n, err := sc.Query(target, prefix, p)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	// the subnet-router anycast address 2001:db8:: is not allocated
+	if got := string(n.GetUpdate()[0].GetVal().GetJsonIetfVal()); got != `"2001:db8::2"` {
+		t.Errorf("state cache: got %s, want \"2001:db8::2\"", got)
+	}
+
+	if _, err := a.DeAllocate(pe, &Request{Owner: "b"}); err != nil {
+		t.Fatalf("DeAllocate: %v", err)
+	}
+	if err := a.UpdateStateCache(pe); err != nil {
+		t.Fatalf("UpdateStateCache: %v", err)
+	}
+	n, err = sc.Query(target, prefix, p)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if n != nil {
+		t.Errorf("state cache: got %v, want deleted allocation", n)
+	}
+}
+
+func TestRestoreStateCache(t *testing.T) {
+	pe := []*gnmi.PathElem{
+		{Name: "ipam"},
+		{Name: "network-instance", Key: map[string]string{"name": "default"}},
+	}
+	tests := []struct {
+		name   string
+		prefix *gnmi.Path
+		target string
+	}{
+		{name: "prefix", prefix: &gnmi.Path{Target: "ipam"}, target: "ipam"},
+		// without prefix the allocations are persisted in the default target
+		{name: "no prefix", target: "allocator"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := cache.New([]string{})
+			opts := []Option{WithStateCache(sc)}
+			if tt.prefix != nil {
+				opts = append(opts, WithPrefix(tt.prefix))
+			}
+			a := New(opts...)
+			if err := a.AddPrefix(pe, "10.0.0.0/24", nil); err != nil {
+				t.Fatalf("AddPrefix: %v", err)
+			}
+			for _, r := range []*Request{{Owner: "a"}, {Owner: "b", PrefixLength: 30}} {
+				if _, err := a.Allocate(pe, r); err != nil {
+					t.Fatalf("Allocate: %v", err)
+				}
+			}
+			if err := a.UpdateStateCache(pe); err != nil {
+				t.Fatalf("UpdateStateCache: %v", err)
+			}
+			if !sc.GetCache().HasTarget(tt.target) {
+				t.Errorf("state cache: target %s not found", tt.target)
+			}
+
+			// a new allocator restores the allocations when the pools are added
+			a = New(opts...)
+			if err := a.AddPrefix(pe, "10.0.0.0/24", nil); err != nil {
+				t.Fatalf("AddPrefix: %v", err)
+			}
+			x, err := a.Query(pe, nil)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			got := map[string]string{}
+			for _, al := range x.([]*Allocation) {
+				got[al.Owner] = al.Value
+			}
+			exp := map[string]string{"a": "10.0.0.1", "b": "10.0.0.4/30"}
+			if !reflect.DeepEqual(got, exp) {
+				t.Errorf("restored allocations: got %v, want %v", got, exp)
+			}
+			x, err = a.Allocate(pe, &Request{Owner: "c"})
+			if err != nil {
+				t.Fatalf("Allocate: %v", err)
+			}
+			if v := x.(*Allocation).Value; v != "10.0.0.2" {
+				t.Errorf("Allocate after restore: got %s, want 10.0.0.2", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package allocator
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// A PoolKind represents the kind of resources a pool allocates from
+type PoolKind string
+
+// Pool Kinds.
+const (
+	// ip prefix, allocates addresses or sub-prefixes
+	PoolKindPrefix PoolKind = "prefix"
+	// ip range, allocates addresses
+	PoolKindRange PoolKind = "range"
+	// integer pool, e.g. asn, vlan or index
+	PoolKindInt PoolKind = "int"
+)
+
+// interval is an inclusive range of ip addresses or integers
+type interval struct {
+	start *big.Int
+	end   *big.Int
+}
+
+func (i *interval) overlaps(o *interval) bool {
+	return i.start.Cmp(o.end) <= 0 && i.end.Cmp(o.start) >= 0
+}
+
+func (i *interval) contains(o *interval) bool {
+	return i.start.Cmp(o.start) <= 0 && i.end.Cmp(o.end) >= 0
+}
+
+type allocation struct {
+	interval
+	owner        string
+	prefixLength int // only set for sub-prefix allocations
+}
+
+type pool struct {
+	interval
+	kind        PoolKind
+	name        string
+	labels      map[string]string
+	ipv4        bool // only relevant for ip pools
+	length      int  // only relevant for prefix pools
+	allocations map[string]*allocation
+}
+
+func newPrefixPool(prefix string, labels map[string]string) (*pool, error) {
+	ip, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !ip.Equal(n.IP) {
+		return nil, fmt.Errorf("prefix %s is not a network address, expected %s", prefix, n.String())
+	}
+	ones, bits := n.Mask.Size()
+	start := ipToInt(n.IP)
+	return &pool{
+		interval: interval{
+			start: start,
+			end:   new(big.Int).Add(start, new(big.Int).Sub(hostSize(bits-ones), big.NewInt(1))),
+		},
+		kind:        PoolKindPrefix,
+		name:        n.String(),
+		labels:      labels,
+		ipv4:        bits == net.IPv4len*8,
+		length:      ones,
+		allocations: make(map[string]*allocation),
+	}, nil
+}
+
+func newRangePool(start, end string, labels map[string]string) (*pool, error) {
+	s := net.ParseIP(start)
+	if s == nil {
+		return nil, fmt.Errorf("invalid range start %s", start)
+	}
+	e := net.ParseIP(end)
+	if e == nil {
+		return nil, fmt.Errorf("invalid range end %s", end)
+	}
+	if (s.To4() == nil) != (e.To4() == nil) {
+		return nil, fmt.Errorf("range %s-%s mixes address families", start, end)
+	}
+	p := &pool{
+		interval:    interval{start: ipToInt(s), end: ipToInt(e)},
+		kind:        PoolKindRange,
+		name:        rangeName(start, end),
+		labels:      labels,
+		ipv4:        s.To4() != nil,
+		allocations: make(map[string]*allocation),
+	}
+	if p.start.Cmp(p.end) > 0 {
+		return nil, fmt.Errorf("range start %s is higher than range end %s", start, end)
+	}
+	return p, nil
+}
+
+func newIntPool(name string, start, end uint32, labels map[string]string) (*pool, error) {
+	if start > end {
+		return nil, fmt.Errorf("pool %s start %d is higher than end %d", name, start, end)
+	}
+	return &pool{
+		interval: interval{
+			start: new(big.Int).SetUint64(uint64(start)),
+			end:   new(big.Int).SetUint64(uint64(end)),
+		},
+		kind:        PoolKindInt,
+		name:        name,
+		labels:      labels,
+		allocations: make(map[string]*allocation),
+	}, nil
+}
+
+func rangeName(start, end string) string {
+	return start + "-" + end
+}
+
+func (p *pool) isIP() bool {
+	return p.kind == PoolKindPrefix || p.kind == PoolKindRange
+}
+
+// matches returns true if all the selector labels are present in the pool labels
+func (p *pool) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if pv, ok := p.labels[k]; !ok || pv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// allocate finds the first free block of 2^(hostbits) entries in the pool, that
+// does not overlap with any of the excluded intervals
+func (p *pool) allocate(owner string, prefixLength int, excl []*interval) (*allocation, bool) {
+	first := p.start
+	last := p.end
+	size := big.NewInt(1)
+	switch {
+	case prefixLength != 0:
+		bits := net.IPv6len * 8
+		if p.ipv4 {
+			bits = net.IPv4len * 8
+		}
+		size = hostSize(bits - prefixLength)
+	case p.kind == PoolKindPrefix && p.ipv4 && p.length < 31:
+		// the network and broadcast address are not allocatable
+		first = new(big.Int).Add(first, big.NewInt(1))
+		last = new(big.Int).Sub(last, big.NewInt(1))
+	case p.kind == PoolKindPrefix && !p.ipv4 && p.length < 127:
+		// the subnet-router anycast address is not allocatable
+		first = new(big.Int).Add(first, big.NewInt(1))
+	}
+	for _, a := range p.allocations {
+		excl = append(excl, &a.interval)
+	}
+	sort.Slice(excl, func(i, j int) bool {
+		return excl[i].start.Cmp(excl[j].start) < 0
+	})
+
+	cand := alignUp(first, size)
+	for {
+		c := &interval{start: cand, end: new(big.Int).Sub(new(big.Int).Add(cand, size), big.NewInt(1))}
+		if c.end.Cmp(last) > 0 {
+			return nil, false
+		}
+		overlap := false
+		for _, iv := range excl {
+			if c.overlaps(iv) {
+				cand = alignUp(new(big.Int).Add(iv.end, big.NewInt(1)), size)
+				overlap = true
+				break
+			}
+		}
+		if !overlap {
+			return &allocation{interval: *c, owner: owner, prefixLength: prefixLength}, true
+		}
+	}
+}
+
+// value returns the string representation of the allocation
+func (p *pool) value(a *allocation) string {
+	switch {
+	case p.kind == PoolKindInt:
+		return a.start.String()
+	case a.prefixLength != 0:
+		return fmt.Sprintf("%s/%d", intToIP(a.start, p.ipv4).String(), a.prefixLength)
+	default:
+		return intToIP(a.start, p.ipv4).String()
+	}
+}
+
+// parseAllocation returns the allocation of owner with the string
+// representation value, the inverse of value
+func (p *pool) parseAllocation(owner, value string) (*allocation, error) {
+	if p.kind == PoolKindInt {
+		i, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer allocation %s", value)
+		}
+		return &allocation{interval: interval{start: i, end: i}, owner: owner}, nil
+	}
+	if strings.Contains(value, "/") {
+		ip, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		if !ip.Equal(n.IP) || (ip.To4() != nil) != p.ipv4 {
+			return nil, fmt.Errorf("invalid prefix allocation %s", value)
+		}
+		ones, bits := n.Mask.Size()
+		start := ipToInt(n.IP)
+		return &allocation{
+			interval: interval{
+				start: start,
+				end:   new(big.Int).Sub(new(big.Int).Add(start, hostSize(bits-ones)), big.NewInt(1)),
+			},
+			owner:        owner,
+			prefixLength: ones,
+		}, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil || (ip.To4() != nil) != p.ipv4 {
+		return nil, fmt.Errorf("invalid address allocation %s", value)
+	}
+	i := ipToInt(ip)
+	return &allocation{interval: interval{start: i, end: i}, owner: owner}, nil
+}
+
+// overlapsAllocation returns true if i overlaps with an allocation of the pool
+func (p *pool) overlapsAllocation(i *interval) bool {
+	for _, a := range p.allocations {
+		if a.overlaps(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPathElem returns the path element the pool is stored at in the state cache
+func (p *pool) getPathElem() *gnmi.PathElem {
+	switch p.kind {
+	case PoolKindPrefix:
+		return &gnmi.PathElem{Name: "ip-prefix", Key: map[string]string{"prefix": p.name}}
+	case PoolKindRange:
+		return &gnmi.PathElem{Name: "ip-range", Key: map[string]string{
+			"start": intToIP(p.start, p.ipv4).String(),
+			"end":   intToIP(p.end, p.ipv4).String(),
+		}}
+	default:
+		return &gnmi.PathElem{Name: "pool", Key: map[string]string{"name": p.name}}
+	}
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		return new(big.Int).SetBytes(ip4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(i *big.Int, ipv4 bool) net.IP {
+	l := net.IPv6len
+	if ipv4 {
+		l = net.IPv4len
+	}
+	b := i.Bytes()
+	ip := make(net.IP, l)
+	copy(ip[l-len(b):], b)
+	return ip
+}
+
+// hostSize returns 2^hostBits
+func hostSize(hostBits int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+}
+
+// alignUp returns the lowest multiple of size that is higher or equal than x
+func alignUp(x, size *big.Int) *big.Int {
+	r := new(big.Int).Add(x, new(big.Int).Sub(size, big.NewInt(1)))
+	r.Div(r, size)
+	return r.Mul(r, size)
+}
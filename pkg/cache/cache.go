@@ -1,10 +1,12 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,13 +16,20 @@ import (
 	"github.com/yndd/ndd-yang/pkg/occache"
 	"github.com/yndd/ndd-yang/pkg/octree"
 	"github.com/yndd/ndd-yang/pkg/parser"
+	"github.com/yndd/ndd-yang/pkg/tracing"
 	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+	"google.golang.org/protobuf/proto"
 )
 
 type Cache struct {
-	c   *occache.Cache
-	p   *parser.Parser
-	log logging.Logger
+	c      *occache.Cache
+	p      *parser.Parser
+	log    logging.Logger
+	ocOpts []occache.Option
+	tracer tracing.Tracer
+	ctx    context.Context
+	rs     *yentry.Entry
 }
 
 // Option can be used to manipulate Options.
@@ -38,24 +47,232 @@ func WithParser(l logging.Logger) Option {
 	}
 }
 
+// WithHistory keeps the previous values of every leaf in the cache, bounded by
+// depth values and age per leaf.
+func WithHistory(depth int, age time.Duration) Option {
+	return func(c *Cache) {
+		c.ocOpts = append(c.ocOpts, occache.WithHistory(depth, age))
+	}
+}
+
+// WithQuota bounds the number of leaves and bytes of every target in the cache.
+func WithQuota(q occache.Quota) Option {
+	return func(c *Cache) {
+		c.ocOpts = append(c.ocOpts, occache.WithQuota(q))
+	}
+}
+
+// WithConfig marks the cache as a config cache, its entries are never evicted.
+func WithConfig() Option {
+	return func(c *Cache) {
+		c.ocOpts = append(c.ocOpts, occache.WithConfig())
+	}
+}
+
+// WithTracer traces the cache queries with t.
+func WithTracer(t tracing.Tracer) Option {
+	return func(c *Cache) {
+		c.tracer = t
+	}
+}
+
+// WithRootSchema makes the cache aware of the choices, presence containers,
+// ordering and value types of the schema rs. An update of a member of a case
+// deletes the members of the other cases, empty presence containers are kept,
+// the leaf-lists ordered by system are stored sorted, identityref and
+// enumeration values are stored without module prefix and union values are
+// stored with the type of the member they resolve to.
+func WithRootSchema(rs *yentry.Entry) Option {
+	return func(c *Cache) {
+		c.rs = rs
+	}
+}
+
 func New(t []string, opts ...Option) *Cache {
 	c := &Cache{
-		c: occache.New(t),
+		tracer: tracing.NewNopTracer(),
+		ctx:    context.Background(),
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.c = occache.New(t, c.ocOpts...)
 
 	return c
 }
 
+// WithContext returns a shallow copy of the cache which starts its spans as
+// children of the span in ctx.
+func (c *Cache) WithContext(ctx context.Context) *Cache {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// Context returns the context of the cache.
+func (c *Cache) Context() context.Context {
+	return c.ctx
+}
+
+// Tracer returns the tracer of the cache.
+func (c *Cache) Tracer() tracing.Tracer {
+	return c.tracer
+}
+
+func (c *Cache) startSpan(name, t string, p *gnmi.Path) tracing.Span {
+	_, span := c.tracer.Start(c.ctx, name, tracing.String(tracing.AttrTarget, t), tracing.Path(tracing.AttrPath, p))
+	return span
+}
+
+func endSpan(span tracing.Span, found bool, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.SetAttributes(tracing.Bool(tracing.AttrFound, found))
+	span.End()
+}
+
 func (c *Cache) GetCache() *occache.Cache {
 	return c.c
 }
 
 func (c *Cache) GnmiUpdate(t string, n *gnmi.Notification) error {
-	return c.GetCache().GetTarget(t).GnmiUpdate(n)
+	tc := c.GetCache().GetTarget(t)
+	if dn := c.caseDeletes(t, n); dn != nil {
+		if err := tc.GnmiUpdate(dn); err != nil {
+			return err
+		}
+	}
+	return tc.GnmiUpdate(c.normalize(n))
+}
+
+// isLeafList returns true if l holds values and not list entries
+func isLeafList(l []interface{}) bool {
+	for _, v := range l {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// normalize returns the notification with canonical values, the identityref
+// and enumeration values are stored without module prefix, union values are
+// stored with the type of the resolved member type and the leaf-lists ordered
+// by system are sorted.
+func (c *Cache) normalize(n *gnmi.Notification) *gnmi.Notification {
+	if c.rs == nil {
+		return n
+	}
+	var nn *gnmi.Notification
+	for i, u := range n.GetUpdate() {
+		fp := &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), u.GetPath().GetElem()...)}
+		val, ok := c.normalizeValue(fp, u.GetVal())
+		if !ok {
+			continue
+		}
+		if nn == nil {
+			// copy the notification to leave the notification of the caller untouched
+			nn = &gnmi.Notification{
+				Timestamp: n.GetTimestamp(),
+				Prefix:    n.GetPrefix(),
+				Alias:     n.GetAlias(),
+				Atomic:    n.GetAtomic(),
+				Delete:    n.GetDelete(),
+				Update:    append([]*gnmi.Update{}, n.GetUpdate()...),
+			}
+		}
+		nn.Update[i] = &gnmi.Update{Path: u.GetPath(), Val: val, Duplicates: u.GetDuplicates()}
+	}
+	if nn == nil {
+		return n
+	}
+	return nn
+}
+
+// normalizeValue returns the normalized value of the leaf at path p and true
+// if it differs from v.
+func (c *Cache) normalizeValue(p *gnmi.Path, v *gnmi.TypedValue) (*gnmi.TypedValue, bool) {
+	var b []byte
+	ietf := true
+	switch tv := v.GetValue().(type) {
+	case *gnmi.TypedValue_StringVal:
+		// the typed value of a union leaf is selected by the resolved member type
+		if t, err := yparser.ResolveUnion(c.rs, p, tv.StringVal); err == nil && t != nil {
+			if nv := yparser.GetUnionTypedValue(t, tv.StringVal); !proto.Equal(nv, v) {
+				return nv, true
+			}
+			return nil, false
+		}
+		if s, ok := yparser.NormalizeLeafValue(c.rs, p, tv.StringVal).(string); ok && s != tv.StringVal {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: s}}, true
+		}
+		return nil, false
+	case *gnmi.TypedValue_JsonIetfVal:
+		b = tv.JsonIetfVal
+	case *gnmi.TypedValue_JsonVal:
+		b, ietf = tv.JsonVal, false
+	default:
+		return nil, false
+	}
+	var x interface{}
+	if err := json.Unmarshal(b, &x); err != nil {
+		return nil, false
+	}
+	switch xx := x.(type) {
+	case map[string]interface{}:
+		return nil, false
+	case []interface{}:
+		if !isLeafList(xx) {
+			return nil, false
+		}
+		x = yparser.NormalizeLeafValue(c.rs, p, xx)
+		if !yparser.IsOrderedByUser(c.rs, p) {
+			x = yparser.SortLeafList(x.([]interface{}))
+		}
+	default:
+		x = yparser.NormalizeLeafValue(c.rs, p, xx)
+	}
+	nb, err := json.Marshal(x)
+	if err != nil || string(nb) == string(b) {
+		return nil, false
+	}
+	if ietf {
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: nb}}, true
+	}
+	return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: nb}}, true
+}
+
+// caseDeletes returns a notification deleting the members of the other cases
+// of the choices set by the updates in n which are present in the cache.
+func (c *Cache) caseDeletes(t string, n *gnmi.Notification) *gnmi.Notification {
+	if c.rs == nil || len(n.GetUpdate()) == 0 {
+		return nil
+	}
+	upds := make([]*gnmi.Update, 0, len(n.GetUpdate()))
+	for _, u := range n.GetUpdate() {
+		upds = append(upds, &gnmi.Update{
+			Path: &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), u.GetPath().GetElem()...)},
+			Val:  u.GetVal(),
+		})
+	}
+	prefix := &gnmi.Path{Target: n.GetPrefix().GetTarget(), Origin: n.GetPrefix().GetOrigin()}
+	var deletes []*gnmi.Path
+	for _, d := range yparser.GetCaseDeletes(c.rs, upds) {
+		if ns, err := c.queryAll(t, prefix, d); err == nil && len(ns) != 0 {
+			deletes = append(deletes, d)
+		}
+	}
+	if len(deletes) == 0 {
+		return nil
+	}
+	return &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    prefix,
+		Delete:    deletes,
+	}
 }
 
 /*
@@ -239,7 +456,12 @@ func (c *Cache) GetNotificationFromUpdate(prefix *gnmi.Path, u *gnmi.Update, has
 		if len(value) == 0 { // this covers an empty map[string]interface{} e.g. routing-policy/policy/action/accept map[string]interface{}
 			// only insert the empty entries if the pathelem does not contain a key
 			// This allows to insert a container without leafs. Only allows for containers without keys
-			if !hasKey {
+			// When the schema is known only presence containers are inserted.
+			insert := !hasKey
+			if c.rs != nil {
+				insert = c.rs.GetEntry(&gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, prefix.GetElem()...), u.GetPath().GetElem()...)}).GetPresence()
+			}
+			if insert {
 				update := &gnmi.Update{
 					Path: u.GetPath(),
 					Val:  u.GetVal(),
@@ -314,139 +536,744 @@ func (c *Cache) GetNotificationFromDelete(prefix *gnmi.Path, p *gnmi.Path) (*gnm
 
 }
 
-func (c *Cache) GetGnmiUpdateAsJsonBlob(t, o string, u *gnmi.Update) error {
+// GetGnmiUpdateAsJsonBlob returns the data of the subtree p as container and
+// list level updates with a JSON IETF blob, the inverse of the granular leaf
+// updates stored in the cache. The keys of a list entry are part of the path
+// of its update, containers without leafs are left out unless they are
+// presence containers.
+func (c *Cache) GetGnmiUpdateAsJsonBlob(t string, prefix *gnmi.Path, p *gnmi.Path, rs *yentry.Entry, opts ...yparser.JSONOption) ([]*gnmi.Update, error) {
+	span := c.startSpan("cache.GetGnmiUpdateAsJsonBlob", t, p)
+	upds, err := c.getGnmiUpdateAsJsonBlob(t, prefix, p, rs, opts...)
+	span.SetAttributes(tracing.Int(tracing.AttrCount, len(upds)))
+	endSpan(span, len(upds) != 0, err)
+	return upds, err
+}
 
-	// to retrun the data we want to create a function that return the updates as a json blob iso individual path
-	return nil
+func (c *Cache) getGnmiUpdateAsJsonBlob(t string, prefix *gnmi.Path, p *gnmi.Path, rs *yentry.Entry, opts ...yparser.JSONOption) ([]*gnmi.Update, error) {
+	p, ps, err := yparser.ParsePredicates(rs, prefix, p)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.getJson(t, prefix, p, rs, yparser.GetLeafFilter(opts...), append(ps, yparser.GetPredicates(opts...)...))
+	if err != nil || d == nil {
+		return nil, err
+	}
+	if d = yparser.ProcessJSON(rs, p, d, opts...); d == nil {
+		return nil, nil
+	}
+
+	// the data of a list without key or with a wildcard key holds the list
+	// itself, the updates are relative to the parent of the list
+	base := p
+	if elems := p.GetElem(); len(elems) != 0 && len(rs.GetKeys(p)) != 0 && !hasExactKey(elems[len(elems)-1]) {
+		base = &gnmi.Path{Elem: elems[:len(elems)-1]}
+	}
+	upds, err := yparser.GetUpdatesFromJSON(base, d, rs)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]*gnmi.Update, 0, len(upds))
+	for _, u := range upds {
+		elems := u.GetPath().GetElem()
+		if base != p && len(elems) == len(base.GetElem()) {
+			// the data holds the list only, not the leafs of its parent
+			continue
+		}
+		if string(u.GetVal().GetJsonIetfVal()) == "{}" && (len(elems) == 0 || len(elems[len(elems)-1].GetKey()) == 0) &&
+			!rs.GetEntry(u.GetPath()).GetPresence() {
+			continue
+		}
+		blobs = append(blobs, u)
+	}
+	return blobs, nil
 }
 
-func (c *Cache) QueryAll(t string, prefix *gnmi.Path, p *gnmi.Path) ([]*gnmi.Notification, error) {
+func hasExactKey(e *gnmi.PathElem) bool {
+	if len(e.GetKey()) == 0 {
+		return false
+	}
+	for _, v := range e.GetKey() {
+		if v == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryAll returns the notifications of the leafs matching the query path p,
+// the data type, leaf filter and predicate options of the JSON options apply.
+func (c *Cache) QueryAll(t string, prefix *gnmi.Path, p *gnmi.Path, opts ...yparser.JSONOption) ([]*gnmi.Notification, error) {
+	span := c.startSpan("cache.QueryAll", t, p)
+	ns, err := c.queryAll(t, prefix, p, opts...)
+	span.SetAttributes(tracing.Int(tracing.AttrCount, len(ns)))
+	endSpan(span, len(ns) != 0, err)
+	return ns, err
+}
+
+func (c *Cache) queryAll(t string, prefix *gnmi.Path, p *gnmi.Path, opts ...yparser.JSONOption) ([]*gnmi.Notification, error) {
+	f := yparser.GetLeafFilter(opts...)
+	dataType := yparser.GetOptionsDataType(opts...)
+	p, ps, err := yparser.ParsePredicates(c.rs, prefix, p)
+	if err != nil {
+		return nil, err
+	}
+	m := newPredicateMatcher(append(ps, yparser.GetPredicates(opts...)...))
 	notifications := []*gnmi.Notification{}
 	fp, err := path.CompletePath(prefix, p)
 	if err != nil {
 		return nil, err
 	}
-	//pp := path.ToStrings(fp, true)
-	if err := c.c.Query(t, fp,
-		func(_ []string, _ *octree.Leaf, n interface{}) error {
-			if n, ok := n.(*gnmi.Notification); ok {
-				notifications = append(notifications, n)
+	qp := queryPath(prefix, p)
+	// the leaf filter applies after the predicates are evaluated, the
+	// predicate leafs do not need to be selected
+	add := func(n *gnmi.Notification) {
+		if n = filterLeafs(c.rs, n, qp, f); n == nil {
+			return
+		}
+		if n = yparser.FilterNotification(c.rs, n, dataType); n != nil {
+			notifications = append(notifications, n)
+		}
+	}
+	var pending []*gnmi.Notification
+	if err := c.queryNotifications(t, fp, newQueryWalk(c.rs, prefix, p, f, m),
+		func(n *gnmi.Notification) error {
+			n, pn := m.filter(n)
+			if pn != nil {
+				pending = append(pending, pn)
+			}
+			if n != nil {
+				add(n)
 			}
 			return nil
 		}); err != nil {
 		return nil, err
 	}
+	if pending, err = c.filterPredicates(t, queryOrigin(prefix, p), m, pending); err != nil {
+		return nil, err
+	}
+	for _, n := range pending {
+		add(n)
+	}
 	return notifications, nil
 }
 
-func (c *Cache) Query(t string, prefix *gnmi.Path, p *gnmi.Path) (*gnmi.Notification, error) {
-	var notification *gnmi.Notification
-	fp, err := path.CompletePath(prefix, p)
-	if err != nil {
+func (c *Cache) Query(t string, prefix *gnmi.Path, p *gnmi.Path, opts ...yparser.JSONOption) (*gnmi.Notification, error) {
+	span := c.startSpan("cache.Query", t, p)
+	n, err := c.query(t, prefix, p, opts...)
+	endSpan(span, n != nil, err)
+	return n, err
+}
+
+func (c *Cache) query(t string, prefix *gnmi.Path, p *gnmi.Path, opts ...yparser.JSONOption) (*gnmi.Notification, error) {
+	notifications, err := c.queryAll(t, prefix, p, opts...)
+	if err != nil || len(notifications) == 0 {
 		return nil, err
 	}
-	//pp := path.ToStrings(fp, true)
-	if err := c.c.Query(t, fp,
-		func(_ []string, _ *octree.Leaf, n interface{}) error {
-			if n, ok := n.(*gnmi.Notification); ok {
-				notification = n
+	return notifications[len(notifications)-1], nil
+}
+
+// queryNotifications calls fn for the notifications of the leafs matching the
+// query q, the tree nodes pruned by the walk w are not visited. An atomic group
+// is stored as a single leaf, it is expanded into its members matching q, also
+// when q points inside the group. The predicates of the walk are evaluated on
+// the whole group.
+func (c *Cache) queryNotifications(t string, q []string, w *queryWalk, fn func(n *gnmi.Notification) error) error {
+	found := false
+	visit := func(n *gnmi.Notification) error {
+		w.visit(n)
+		if n = expandAtomic(n, q); n == nil {
+			return nil
+		}
+		found = true
+		return fn(n)
+	}
+	if err := c.c.QueryFiltered(t, q, w.keep(),
+		func(_ []string, _ *octree.Leaf, v interface{}) error {
+			if n, ok := v.(*gnmi.Notification); ok {
+				return visit(n)
 			}
 			return nil
-		}); err != nil {
+		}); err != nil || found {
+		return err
+	}
+	// look for an atomic group holding q, the search stops at the first
+	// ancestor of q present in the cache
+	for i := len(q) - 1; i > 0; i-- {
+		wildcard := false
+		for _, s := range q[:i] {
+			if s == "*" {
+				wildcard = true
+			}
+		}
+		if !wildcard {
+			v, ok := c.c.GetLeafValue(t, q[:i])
+			if !ok {
+				continue
+			}
+			if n, ok := v.(*gnmi.Notification); ok && n.GetAtomic() {
+				return visit(n)
+			}
+			return nil
+		}
+		// the ancestors with a wildcard can match multiple groups
+		deeper := false
+		err := c.c.Query(t, q[:i],
+			func(p []string, _ *octree.Leaf, v interface{}) error {
+				if len(p) != i {
+					deeper = true
+					return nil
+				}
+				if n, ok := v.(*gnmi.Notification); ok && n.GetAtomic() {
+					return visit(n)
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		if found || deeper {
+			return nil
+		}
+	}
+	return nil
+}
+
+// expandAtomic returns the members of the atomic group n at or below the
+// query q with their full path, nil if no member matches. Other notifications
+// are returned as is.
+func expandAtomic(n *gnmi.Notification, q []string) *gnmi.Notification {
+	if !n.GetAtomic() {
+		return n
+	}
+	prefix := n.GetPrefix()
+	gp := path.ToStrings(&gnmi.Path{Origin: prefix.GetOrigin(), Elem: prefix.GetElem()}, true)
+	en := &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    &gnmi.Path{Target: prefix.GetTarget(), Origin: prefix.GetOrigin()},
+		Alias:     n.GetAlias(),
+	}
+	for _, u := range n.GetUpdate() {
+		if !matchQuery(q, append(gp[:len(gp):len(gp)], path.ToStrings(u.GetPath(), false)...)) {
+			continue
+		}
+		en.Update = append(en.Update, &gnmi.Update{
+			Path:       &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, prefix.GetElem()...), u.GetPath().GetElem()...)},
+			Val:        u.GetVal(),
+			Duplicates: u.GetDuplicates(),
+		})
+	}
+	if len(en.Update) == 0 {
+		return nil
+	}
+	return en
+}
+
+// queryPath returns the path elements of the prefix and path p of a query.
+func queryPath(prefix, p *gnmi.Path) *gnmi.Path {
+	return &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, prefix.GetElem()...), p.GetElem()...)}
+}
+
+// queryWalk prunes the tree walk of a query by the predicates and the leaf
+// filter. The predicates are evaluated when the walk enters a list entry, the
+// entries which do not match are not walked. The nodes deeper than the depth of
+// the filter and the leafs which are not selected by name are not walked. The
+// schema maps the tree nodes to path elements, the nodes unknown to the schema
+// are walked and filtered per leaf.
+type queryWalk struct {
+	rs *yentry.Entry
+	f  *yparser.LeafFilter
+	m  *predicateMatcher
+	// origin is the number of origin elements of the tree paths
+	origin int
+	// depth is the number of path elements of the query path
+	depth int
+}
+
+// newQueryWalk returns the walk of the query p with prefix, nil if the walk
+// is not pruned. Without schema the tree is not pruned.
+func newQueryWalk(rs *yentry.Entry, prefix, p *gnmi.Path, f *yparser.LeafFilter, m *predicateMatcher) *queryWalk {
+	if f.IsEmpty() && m == nil {
+		return nil
+	}
+	w := &queryWalk{rs: rs, f: f, m: m, depth: len(queryPath(prefix, p).GetElem())}
+	if queryOrigin(prefix, p) != "" {
+		w.origin = 1
+	}
+	return w
+}
+
+// keep returns the octree KeepFunc of the walk, nil walks all nodes.
+func (w *queryWalk) keep() octree.KeepFunc {
+	if w == nil || w.rs == nil {
+		return nil
+	}
+	return w.keepNode
+}
+
+// visit evaluates the predicates of the walk on the leafs of n.
+func (w *queryWalk) visit(n *gnmi.Notification) {
+	if w != nil {
+		w.m.visit(n)
+	}
+}
+
+func (w *queryWalk) keepNode(s []string, n octree.QueryNode) bool {
+	if len(s) < w.origin {
+		return true
+	}
+	elems, e, ok := treeElems(w.rs, s[w.origin:])
+	if !ok {
+		return true
+	}
+	if e != nil && !n.IsLeaf() && !w.m.enter(elems, e, n) {
+		return false
+	}
+	if w.f.IsEmpty() || len(elems) <= w.depth {
+		return true
+	}
+	if w.f.Depth != 0 && len(elems)-w.depth > w.f.Depth {
+		return false
+	}
+	// a leaf holding a container or list is an atomic group, its members
+	// are filtered when the group is expanded
+	if !n.IsLeaf() || e != nil {
+		return true
+	}
+	name := elems[len(elems)-1].GetName()
+	if len(elems) > 1 {
+		if _, ok := elems[len(elems)-2].GetKey()[name]; ok {
+			return true
+		}
+	}
+	if len(w.f.Include) != 0 && !w.f.Include[name] {
+		return false
+	}
+	return !w.f.Exclude[name]
+}
+
+// treeElems returns the path elements of the tree path s without origin and
+// the schema entry of the last element, nil for a leaf. The keys of a list
+// follow the list name in the tree path ordered by key name, the element of a
+// list node holds the keys up to s. False is returned for a path the schema
+// does not know.
+func treeElems(rs *yentry.Entry, s []string) ([]*gnmi.PathElem, *yentry.Entry, bool) {
+	elems := make([]*gnmi.PathElem, 0, len(s))
+	e := rs
+	for i := 0; i < len(s); {
+		pe := &gnmi.PathElem{Name: s[i]}
+		elems = append(elems, pe)
+		i++
+		c, ok := e.GetChildren()[pe.GetName()]
+		if !ok {
+			// a leaf, the schema does not know the elements below it
+			return elems, nil, i == len(s)
+		}
+		keys := append([]string{}, c.GetKey()...)
+		sort.Strings(keys)
+		for _, k := range keys {
+			if i == len(s) {
+				break
+			}
+			if pe.Key == nil {
+				pe.Key = make(map[string]string, len(keys))
+			}
+			pe.Key[k] = s[i]
+			i++
+		}
+		e = c
+	}
+	return elems, e, true
+}
+
+// filterLeafs returns the notification with the updates kept by the leaf
+// filter f of the query path qp, nil if no update is kept. The query walk
+// prunes the tree by the same filter, filterLeafs filters the members of the
+// atomic groups and the leafs unknown to the schema.
+func filterLeafs(rs *yentry.Entry, n *gnmi.Notification, qp *gnmi.Path, f *yparser.LeafFilter) *gnmi.Notification {
+	if f.IsEmpty() {
+		return n
+	}
+	upds := make([]*gnmi.Update, 0, len(n.GetUpdate()))
+	for _, u := range n.GetUpdate() {
+		if f.Match(rs, qp, queryPath(n.GetPrefix(), u.GetPath())) {
+			upds = append(upds, u)
+		}
+	}
+	switch len(upds) {
+	case 0:
+		return nil
+	case len(n.GetUpdate()):
+		return n
+	}
+	return &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Alias:     n.GetAlias(),
+		Atomic:    n.GetAtomic(),
+		Update:    upds,
+	}
+}
+
+// matchQuery returns true if the path p is at or below the query q.
+func matchQuery(q, p []string) bool {
+	if len(p) < len(q) {
+		return false
+	}
+	for i, s := range q {
+		if s != "*" && s != p[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// queryOrigin returns the origin of a query, set in the prefix or the path.
+func queryOrigin(prefix, p *gnmi.Path) string {
+	if prefix.GetOrigin() != "" {
+		return prefix.GetOrigin()
+	}
+	return p.GetOrigin()
+}
+
+// predicateMatcher holds the results of the predicates of a query per list
+// entry. The predicates are evaluated while walking the tree when the walk
+// enters a list entry or visits an atomic group holding the predicate leaf.
+// Without schema the list entries are not known to the walk, the predicate
+// leafs which are not visited by the query are looked up after the walk.
+type predicateMatcher struct {
+	ps      []*yparser.Predicate
+	results map[string]bool
+}
+
+func newPredicateMatcher(ps []*yparser.Predicate) *predicateMatcher {
+	if len(ps) == 0 {
+		return nil
+	}
+	return &predicateMatcher{ps: ps, results: make(map[string]bool)}
+}
+
+func entryKey(i int, entry []*gnmi.PathElem) string {
+	return fmt.Sprintf("%d%s", i, yparser.GnmiPath2XPath(&gnmi.Path{Elem: entry}, true))
+}
+
+// visit evaluates the predicates on the leafs of the notification n.
+func (m *predicateMatcher) visit(n *gnmi.Notification) {
+	if m == nil {
+		return
+	}
+	for _, u := range n.GetUpdate() {
+		l := queryPath(n.GetPrefix(), u.GetPath()).GetElem()
+		for i, p := range m.ps {
+			if p.IsLeaf(l) {
+				entry, _ := p.Entry(l)
+				m.results[entryKey(i, entry)] = p.Match(u.GetVal())
+			}
+		}
+	}
+}
+
+// enter evaluates the predicates on the list entry elems with schema entry e at
+// the tree node n and returns false if the entry does not match.
+func (m *predicateMatcher) enter(elems []*gnmi.PathElem, e *yentry.Entry, n octree.QueryNode) bool {
+	if m == nil || len(e.GetKey()) == 0 || len(elems[len(elems)-1].GetKey()) != len(e.GetKey()) {
+		return true
+	}
+	// the entry is matched by a leaf path below it
+	l := append(elems[:len(elems):len(elems)], &gnmi.PathElem{})
+	for i, p := range m.ps {
+		if entry, ok := p.Entry(l); !ok || len(entry) != len(elems) {
+			continue
+		}
+		var v *gnmi.TypedValue
+		if x, ok := n.GetLeafValue(path.ToStrings(p.Leaf, false)).(*gnmi.Notification); ok {
+			for _, u := range x.GetUpdate() {
+				if p.IsLeaf(queryPath(x.GetPrefix(), u.GetPath()).GetElem()) {
+					v = u.GetVal()
+				}
+			}
+		}
+		r := p.Match(v)
+		m.results[entryKey(i, elems)] = r
+		if !r {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the notification with the updates of the list entries
+// matching all predicates and the notification with the updates of the
+// entries without result yet, the updates outside the lists of the predicates
+// are kept.
+func (m *predicateMatcher) filter(n *gnmi.Notification) (*gnmi.Notification, *gnmi.Notification) {
+	if m == nil {
+		return n, nil
+	}
+	var upds, pending []*gnmi.Update
+	for _, u := range n.GetUpdate() {
+		l := queryPath(n.GetPrefix(), u.GetPath()).GetElem()
+		match, known := true, true
+		for i, p := range m.ps {
+			entry, ok := p.Entry(l)
+			if !ok {
+				continue
+			}
+			r, ok := m.results[entryKey(i, entry)]
+			switch {
+			case !ok:
+				known = false
+			case !r:
+				match = false
+			}
+		}
+		switch {
+		case !match:
+		case !known:
+			pending = append(pending, u)
+		default:
+			upds = append(upds, u)
+		}
+	}
+	return withUpdates(n, upds), withUpdates(n, pending)
+}
+
+// withUpdates returns the notification n with the updates upds, nil without
+// updates.
+func withUpdates(n *gnmi.Notification, upds []*gnmi.Update) *gnmi.Notification {
+	switch len(upds) {
+	case 0:
+		return nil
+	case len(n.GetUpdate()):
+		return n
+	}
+	return &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Alias:     n.GetAlias(),
+		Atomic:    n.GetAtomic(),
+		Update:    upds,
+	}
+}
+
+// filterPredicates returns the notifications with the updates of the list
+// entries matching all predicates of m, the updates outside the lists of the
+// predicates are kept.
+func (c *Cache) filterPredicates(t, origin string, m *predicateMatcher, ns []*gnmi.Notification) ([]*gnmi.Notification, error) {
+	if m == nil {
+		return ns, nil
+	}
+	result := make([]*gnmi.Notification, 0, len(ns))
+	for _, n := range ns {
+		upds := make([]*gnmi.Update, 0, len(n.GetUpdate()))
+		for _, u := range n.GetUpdate() {
+			ok, err := c.matchPredicates(t, origin, m, queryPath(n.GetPrefix(), u.GetPath()).GetElem())
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				upds = append(upds, u)
+			}
+		}
+		if n = withUpdates(n, upds); n != nil {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// matchPredicates returns true if the list entries of the leaf path l match
+// the predicates of m, the predicate leafs which were not visited by the query
+// are looked up in the cache.
+func (c *Cache) matchPredicates(t, origin string, m *predicateMatcher, l []*gnmi.PathElem) (bool, error) {
+	for i, p := range m.ps {
+		entry, ok := p.Entry(l)
+		if !ok {
+			continue
+		}
+		k := entryKey(i, entry)
+		r, ok := m.results[k]
+		if !ok {
+			q, err := path.CompletePath(&gnmi.Path{Origin: origin}, p.LeafPath(entry))
+			if err != nil {
+				return false, err
+			}
+			var v *gnmi.TypedValue
+			if err := c.queryNotifications(t, q, nil,
+				func(n *gnmi.Notification) error {
+					for _, u := range n.GetUpdate() {
+						if p.IsLeaf(queryPath(n.GetPrefix(), u.GetPath()).GetElem()) {
+							v = u.GetVal()
+						}
+					}
+					return nil
+				}); err != nil {
+				return false, err
+			}
+			r = p.Match(v)
+			m.results[k] = r
+		}
+		if !r {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// QueryAt returns the notification of the leaf at the path which was current at
+// time ts, nil if the value at ts is not retained in the history of the leaf.
+func (c *Cache) QueryAt(t string, prefix *gnmi.Path, p *gnmi.Path, ts time.Time) (*gnmi.Notification, error) {
+	fp, err := path.CompletePath(prefix, p)
+	if err != nil {
+		return nil, err
+	}
+	tc := c.c.GetTarget(t)
+	if tc == nil {
+		return nil, fmt.Errorf("target %q not found in cache", t)
+	}
+	return tc.ValueAt(fp, ts), nil
+}
+
+// History returns the retained notifications of the leaf at the path, oldest
+// first and ending with the current value.
+func (c *Cache) History(t string, prefix *gnmi.Path, p *gnmi.Path) ([]*gnmi.Notification, error) {
+	fp, err := path.CompletePath(prefix, p)
+	if err != nil {
 		return nil, err
 	}
-	return notification, nil
+	tc := c.c.GetTarget(t)
+	if tc == nil {
+		return nil, fmt.Errorf("target %q not found in cache", t)
+	}
+	return tc.History(fp), nil
+}
+
+// GetJson returns the data of the subtree p as JSON, the options apply or strip
+// the schema defaults, filter the data by GetRequest data type, limit the
+// leafs by depth and name and select the list entries by predicates.
+func (c *Cache) GetJson(t string, prefix *gnmi.Path, p *gnmi.Path, rs *yentry.Entry, opts ...yparser.JSONOption) (interface{}, error) {
+	span := c.startSpan("cache.GetJson", t, p)
+	p, ps, err := yparser.ParsePredicates(rs, prefix, p)
+	var d interface{}
+	if err == nil {
+		d, err = c.getJson(t, prefix, p, rs, yparser.GetLeafFilter(opts...), append(ps, yparser.GetPredicates(opts...)...))
+	}
+	if err == nil && d != nil {
+		d = yparser.ProcessJSON(rs, p, d, opts...)
+	}
+	endSpan(span, d != nil, err)
+	return d, err
 }
 
-func (c *Cache) GetJson(t string, prefix *gnmi.Path, p *gnmi.Path, rs *yentry.Entry) (interface{}, error) {
+func (c *Cache) getJson(t string, prefix *gnmi.Path, p *gnmi.Path, rs *yentry.Entry, f *yparser.LeafFilter, ps []*yparser.Predicate) (interface{}, error) {
 	var err error
 	fp, err := path.CompletePath(prefix, p)
 	if err != nil {
 		return nil, err
 	}
+	qp := queryPath(prefix, p)
+	m := newPredicateMatcher(ps)
 	var data interface{}
-	//pp := path.ToStrings(p, true)
-	if err := c.c.Query(t, fp,
-		func(_ []string, _ *octree.Leaf, n interface{}) error {
-			if n, ok := n.(*gnmi.Notification); ok {
-				for _, u := range n.GetUpdate() {
-
-					// if the last element of the path has a key and the key is a wildcard or is not present
-					// we leave the last element present
-					// if the key is present we delete
-
-					// check in the schema if a last element has a key
-					pathElemHasKey := false
-					if len(rs.GetKeys(p)) > 0 {
-						pathElemHasKey = true
-					}
-					// check if the requested path has a key
-					pathElemReqHasKey := false
-					if len(p.GetElem()) > 0 && len(p.GetElem()[len(p.GetElem())-1].Key) > 0 {
-						pathElemReqHasKey = true
+	var pending []*gnmi.Notification
+	add := func(n *gnmi.Notification) error {
+		for _, u := range n.GetUpdate() {
+
+			// if the last element of the path has a key and the key is a wildcard or is not present
+			// we leave the last element present
+			// if the key is present we delete
+
+			// check in the schema if a last element has a key
+			pathElemHasKey := false
+			if len(rs.GetKeys(p)) > 0 {
+				pathElemHasKey = true
+			}
+			// check if the requested path has a key
+			pathElemReqHasKey := false
+			if len(p.GetElem()) > 0 && len(p.GetElem()[len(p.GetElem())-1].Key) > 0 {
+				pathElemReqHasKey = true
+			}
+			pathElem := []*gnmi.PathElem{}
+			if pathElemHasKey {
+				if pathElemReqHasKey {
+					wildcard := false
+					for _, v := range p.GetElem()[len(p.GetElem())-1].Key {
+						if v == "*" {
+							wildcard = true
+						}
 					}
-					pathElem := []*gnmi.PathElem{}
-					if pathElemHasKey {
-						if pathElemReqHasKey {
-							wildcard := false
-							for _, v := range p.GetElem()[len(p.GetElem())-1].Key {
-								if v == "*" {
-									wildcard = true
-								}
-							}
-							if wildcard {
-								// pathEleme has key and key has wildcard, we dont strip the last Elem
-								// remove the original pathElements from the notification path except the last one
-								if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
-									pathElem = u.GetPath().GetElem()[len(p.GetElem())-1:]
-									//pathElem[len(p.GetElem())-1] = &gnmi.PathElem{Name: "", Key: map[string]string{}}
-								}
-							} else {
-								// pathEleme has key and key hasno wildcard,
-								// remove the original pathElements from the notification path
-								if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
-									pathElem = u.GetPath().GetElem()[len(p.GetElem()):]
-								}
-							}
-						} else {
-							// pathEleme has key and key is not present, we dont strip the last Eleem
-							// remove the original pathElements from the notification path except the last one
-							if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
-								pathElem = u.GetPath().GetElem()[len(p.GetElem())-1:]
-								//pathElem[len(p.GetElem())-1] = &gnmi.PathElem{Name: "", Key: map[string]string{}}
-							}
+					if wildcard {
+						// pathEleme has key and key has wildcard, we dont strip the last Elem
+						// remove the original pathElements from the notification path except the last one
+						if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
+							pathElem = u.GetPath().GetElem()[len(p.GetElem())-1:]
+							//pathElem[len(p.GetElem())-1] = &gnmi.PathElem{Name: "", Key: map[string]string{}}
 						}
 					} else {
-						// pathElem has no key
+						// pathEleme has key and key hasno wildcard,
 						// remove the original pathElements from the notification path
 						if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
 							pathElem = u.GetPath().GetElem()[len(p.GetElem()):]
 						}
 					}
+				} else {
+					// pathEleme has key and key is not present, we dont strip the last Eleem
+					// remove the original pathElements from the notification path except the last one
+					if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
+						pathElem = u.GetPath().GetElem()[len(p.GetElem())-1:]
+						//pathElem[len(p.GetElem())-1] = &gnmi.PathElem{Name: "", Key: map[string]string{}}
+					}
+				}
+			} else {
+				// pathElem has no key
+				// remove the original pathElements from the notification path
+				if len(p.GetElem()) <= len(u.GetPath().GetElem()) {
+					pathElem = u.GetPath().GetElem()[len(p.GetElem()):]
+				}
+			}
 
-					/*
-						if len(p.GetElem()) > 1 &&
-							p.GetElem()[0].GetName() == "routing-policy" &&
-							p.GetElem()[1].GetName() == "policy" {
-							if len(pathElem) == 0 {
-								fmt.Printf("addData, Len: %d, Elem: %s, Key: %v, Data: %v\n", len(pathElem), pathElem[0].GetName(), pathElem[0].GetKey(), data)
-							} else {
-								fmt.Printf("addData, Len: %d, Data: %v\n", len(pathElem), data)
-							}
-						}
-					*/
-					if data, err = c.addData(data, pathElem, u.GetVal()); err != nil {
-						return err
+			/*
+				if len(p.GetElem()) > 1 &&
+					p.GetElem()[0].GetName() == "routing-policy" &&
+					p.GetElem()[1].GetName() == "policy" {
+					if len(pathElem) == 0 {
+						fmt.Printf("addData, Len: %d, Elem: %s, Key: %v, Data: %v\n", len(pathElem), pathElem[0].GetName(), pathElem[0].GetKey(), data)
+					} else {
+						fmt.Printf("addData, Len: %d, Data: %v\n", len(pathElem), data)
 					}
+				}
+			*/
+			if data, err = c.addData(data, pathElem, u.GetVal()); err != nil {
+				return err
+			}
 
-					//fmt.Printf("data: %v\n", data)
+			//fmt.Printf("data: %v\n", data)
 
-				}
+		}
+		return nil
+	}
+	if err := c.queryNotifications(t, fp, newQueryWalk(rs, prefix, p, f, m),
+		func(n *gnmi.Notification) error {
+			n, pn := m.filter(n)
+			if pn != nil {
+				pending = append(pending, pn)
 			}
-			return nil
+			if n = filterLeafs(rs, n, qp, f); n == nil {
+				return nil
+			}
+			return add(n)
 		}); err != nil {
 		return nil, err
 	}
+	if pending, err = c.filterPredicates(t, queryOrigin(prefix, p), m, pending); err != nil {
+		return nil, err
+	}
+	for _, n := range pending {
+		if n = filterLeafs(rs, n, qp, f); n == nil {
+			continue
+		}
+		if err := add(n); err != nil {
+			return nil, err
+		}
+	}
 	return data, nil
 }
 
@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/openconfig/gnmi/path"
 	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/container"
 	"github.com/yndd/ndd-yang/pkg/octree"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
 )
 
 //{"level":"debug","ts":1633674399.5347052,"logger":"ipam","msg":"Create Fine Grane Updates","resource":"ipam-default-ipprefix-isl-ipv4","Resource":"ipam-default-ipprefix-isl-ipv4","Path":"/ipam/tenant[name=default]/network-instance[name=default]/ip-prefix[prefix=100.64.0.0/16]","Value":"json_ietf_val:\"{\\\"address-allocation-strategy\\\":\\\"first-address\\\",\\\"admin-state\\\":\\\"enable\\\"}\""}
@@ -428,3 +433,757 @@ func TestGetJson(t *testing.T) {
 		//}
 	}
 }
+
+func TestQueryAt(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	p := &gnmi.Path{Elem: []*gnmi.PathElem{
+		{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+		{Name: "oper-state"},
+	}}
+	c := New([]string{target}, WithHistory(2, 0))
+	for i, v := range []string{"up", "down", "up", "down"} {
+		n := &gnmi.Notification{
+			Timestamp: int64(10 * (i + 1)),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: p, Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}}},
+		}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	h, err := c.History(target, prefix, p)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	// 2 previous values and the current value
+	if len(h) != 3 {
+		t.Fatalf("History: got %d values, want 3", len(h))
+	}
+
+	tests := []struct {
+		inp int64
+		exp string
+	}{
+		// no longer retained given the depth of 2
+		{inp: 15, exp: ""},
+		{inp: 20, exp: "down"},
+		{inp: 35, exp: "up"},
+		{inp: 100, exp: "down"},
+	}
+	for _, tt := range tests {
+		n, err := c.QueryAt(target, prefix, p, time.Unix(0, tt.inp))
+		if err != nil {
+			t.Fatalf("QueryAt: %v", err)
+		}
+		got := ""
+		if n != nil {
+			got = n.GetUpdate()[0].GetVal().GetStringVal()
+		}
+		if got != tt.exp {
+			t.Errorf("QueryAt %d: got %q, want %q", tt.inp, got, tt.exp)
+		}
+	}
+}
+
+func TestChoiceCase(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := &yentry.Entry{Name: "root"}
+	action := &yentry.Entry{Name: "action", Parent: rs,
+		Choices: map[string]map[string][]string{
+			"result": {"accept": {"accept"}, "reject": {"reject-code"}},
+		},
+	}
+	action.Children = map[string]*yentry.Entry{"accept": {Name: "accept", Parent: action, Presence: true}}
+	rs.Children = map[string]*yentry.Entry{"action": action}
+	c := New([]string{target}, WithRootSchema(rs))
+
+	update := func(xpath string, v interface{}) {
+		b, _ := json.Marshal(v)
+		n, err := c.GetNotificationFromUpdate(prefix, &gnmi.Update{
+			Path: yparser.Xpath2GnmiPath(xpath, 0),
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: b}},
+		}, false)
+		if err != nil {
+			t.Fatalf("GetNotificationFromUpdate: %v", err)
+		}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	update("/action", map[string]interface{}{"reject-code": 1})
+	update("/action/accept", map[string]interface{}{})
+	d, err := c.GetJson(target, prefix, yparser.Xpath2GnmiPath("/action", 0), rs)
+	if err != nil {
+		t.Fatalf("GetJson: %v", err)
+	}
+	if exp := map[string]interface{}{"accept": map[string]interface{}{}}; !reflect.DeepEqual(d, exp) {
+		t.Errorf("GetJson: got %v, want %v", d, exp)
+	}
+
+	update("/action", map[string]interface{}{"reject-code": 2})
+	d, err = c.GetJson(target, prefix, yparser.Xpath2GnmiPath("/action", 0), rs)
+	if err != nil {
+		t.Fatalf("GetJson: %v", err)
+	}
+	if exp := map[string]interface{}{"reject-code": float64(2)}; !reflect.DeepEqual(d, exp) {
+		t.Errorf("GetJson: got %v, want %v", d, exp)
+	}
+}
+
+func TestLeafListOrder(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := &yentry.Entry{Name: "root", OrderedByUser: map[string]bool{"community": true}}
+	c := New([]string{target}, WithRootSchema(rs))
+
+	for _, v := range []string{`["b","a"]`, `["a","b"]`} {
+		n := &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update: []*gnmi.Update{
+				{Path: yparser.Xpath2GnmiPath("/prefix", 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(v)}}},
+				{Path: yparser.Xpath2GnmiPath("/community", 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(v)}}},
+			},
+		}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+		if string(n.GetUpdate()[0].GetVal().GetJsonIetfVal()) != v {
+			t.Errorf("GnmiUpdate modified the notification")
+		}
+		d, err := c.GetJson(target, prefix, &gnmi.Path{}, rs)
+		if err != nil {
+			t.Fatalf("GetJson: %v", err)
+		}
+		var community interface{}
+		json.Unmarshal([]byte(v), &community)
+		exp := map[string]interface{}{"prefix": []interface{}{"a", "b"}, "community": community}
+		if !reflect.DeepEqual(d, exp) {
+			t.Errorf("GetJson: got %v, want %v", d, exp)
+		}
+	}
+}
+
+func TestNormalizeIdentity(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := &yentry.Entry{Name: "root", IdentityRefs: map[string][]string{"type": {"ethernet"}}}
+	c := New([]string{target}, WithRootSchema(rs))
+
+	for _, val := range []*gnmi.TypedValue{
+		{Value: &gnmi.TypedValue_StringVal{StringVal: "srl_nokia-if:ethernet"}},
+		{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`"srl_nokia-if:ethernet"`)}},
+	} {
+		n := &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath("/type", 0), Val: val}},
+		}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+		d, err := c.GetJson(target, prefix, &gnmi.Path{}, rs)
+		if err != nil {
+			t.Fatalf("GetJson: %v", err)
+		}
+		if exp := map[string]interface{}{"type": "ethernet"}; !reflect.DeepEqual(d, exp) {
+			t.Errorf("GetJson: got %v, want %v", d, exp)
+		}
+	}
+}
+
+func TestUnionTypedValue(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := &yentry.Entry{Name: "root", Unions: map[string][]*container.UnionType{
+		"vlan-id": {{Kind: "uint16"}, {Kind: "enumeration", Enum: []string{"auto"}}},
+	}}
+	c := New([]string{target}, WithRootSchema(rs))
+
+	tests := []struct {
+		value string
+		exp   interface{}
+	}{
+		{value: "100", exp: uint64(100)},
+		{value: "auto", exp: "auto"},
+	}
+	for _, tt := range tests {
+		n := &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update: []*gnmi.Update{{
+				Path: yparser.Xpath2GnmiPath("/vlan-id", 0),
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: tt.value}},
+			}},
+		}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+		d, err := c.GetJson(target, prefix, &gnmi.Path{}, rs)
+		if err != nil {
+			t.Fatalf("GetJson: %v", err)
+		}
+		if exp := map[string]interface{}{"vlan-id": tt.exp}; !reflect.DeepEqual(d, exp) {
+			t.Errorf("GetJson: got %#v, want %#v", d, exp)
+		}
+	}
+}
+
+func TestGetGnmiUpdateAsJsonBlob(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs}
+	subitfce := &yentry.Entry{Name: "subinterface", Key: []string{"index"}, Parent: itfce}
+	vlan := &yentry.Entry{Name: "vlan", Parent: subitfce}
+	subitfce.Children = map[string]*yentry.Entry{"vlan": vlan}
+	itfce.Children = map[string]*yentry.Entry{"subinterface": subitfce}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	c := New([]string{target}, WithRootSchema(rs))
+
+	d := map[string]interface{}{
+		"interface": []interface{}{
+			map[string]interface{}{
+				"name":        "e1",
+				"admin-state": "enable",
+				"subinterface": []interface{}{
+					map[string]interface{}{"index": "1", "vlan": map[string]interface{}{"encap": "untagged"}},
+					map[string]interface{}{"index": "2"},
+				},
+			},
+			map[string]interface{}{"name": "e2", "description": "uplink"},
+		},
+	}
+	leafs, err := yparser.GetGranularUpdatesFromJSON(&gnmi.Path{}, d, rs)
+	if err != nil {
+		t.Fatalf("GetGranularUpdatesFromJSON: %v", err)
+	}
+	for _, u := range leafs {
+		n, err := c.GetNotificationFromUpdate(prefix, u, false)
+		if err != nil {
+			t.Fatalf("GetNotificationFromUpdate: %v", err)
+		}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		xpath string
+		exp   map[string]string
+	}{
+		{name: "root", xpath: "/", exp: map[string]string{
+			"/interface[name=e1]":                            `{"admin-state":"enable"}`,
+			"/interface[name=e1]/subinterface[index=1]":      `{}`,
+			"/interface[name=e1]/subinterface[index=1]/vlan": `{"encap":"untagged"}`,
+			"/interface[name=e1]/subinterface[index=2]":      `{}`,
+			"/interface[name=e2]":                            `{"description":"uplink"}`,
+		}},
+		{name: "list entry", xpath: "/interface[name=e2]", exp: map[string]string{
+			"/interface[name=e2]": `{"description":"uplink"}`,
+		}},
+		{name: "wildcard", xpath: "/interface[name=e1]/subinterface[index=*]", exp: map[string]string{
+			"/interface[name=e1]/subinterface[index=1]":      `{}`,
+			"/interface[name=e1]/subinterface[index=1]/vlan": `{"encap":"untagged"}`,
+			"/interface[name=e1]/subinterface[index=2]":      `{}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upds, err := c.GetGnmiUpdateAsJsonBlob(target, prefix, yparser.Xpath2GnmiPath(tt.xpath, 0), rs)
+			if err != nil {
+				t.Fatalf("GetGnmiUpdateAsJsonBlob: %v", err)
+			}
+			got := make(map[string]string)
+			for _, u := range upds {
+				got[yparser.GnmiPath2XPath(u.GetPath(), true)] = string(u.GetVal().GetJsonIetfVal())
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("GetGnmiUpdateAsJsonBlob: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	group := &gnmi.Path{Target: target, Elem: []*gnmi.PathElem{
+		{Name: "interface", Key: map[string]string{"name": "e1"}},
+		{Name: "state"},
+	}}
+	strVal := func(s string) *gnmi.TypedValue {
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: s}}
+	}
+	c := New([]string{target})
+	if err := c.GnmiUpdate(target, &gnmi.Notification{
+		Timestamp: 1,
+		Prefix:    prefix,
+		Update: []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}, {Name: "description"}}},
+			Val:  strVal("uplink"),
+		}},
+	}); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	if err := c.GnmiUpdate(target, &gnmi.Notification{
+		Timestamp: 2,
+		Prefix:    group,
+		Atomic:    true,
+		Update: []*gnmi.Update{
+			{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "oper-state"}}}, Val: strVal("up")},
+			{Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "counters"}, {Name: "in-pkts"}}}, Val: strVal("10")},
+		},
+	}); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+
+	tests := []struct {
+		inp  string
+		exp  []string
+		json string
+	}{
+		{inp: "/interface[name=e1]/state/oper-state", exp: []string{"/interface[name=e1]/state/oper-state=up"}, json: `null`},
+		{inp: "/interface[name=e1]/state/counters", exp: []string{"/interface[name=e1]/state/counters/in-pkts=10"}, json: `{"in-pkts":"10"}`},
+		{inp: "/interface[name=e1]/state", exp: []string{
+			"/interface[name=e1]/state/oper-state=up",
+			"/interface[name=e1]/state/counters/in-pkts=10",
+		}, json: `{"counters":{"in-pkts":"10"},"oper-state":"up"}`},
+		{inp: "/interface[name=e1]", exp: []string{
+			"/interface[name=e1]/description=uplink",
+			"/interface[name=e1]/state/oper-state=up",
+			"/interface[name=e1]/state/counters/in-pkts=10",
+		}, json: `{"description":"uplink","state":{"counters":{"in-pkts":"10"},"oper-state":"up"}}`},
+		{inp: "/interface[name=e1]/state/admin-state", json: `null`},
+		{inp: "/interface[name=e2]/state/oper-state", json: `null`},
+	}
+	for _, tt := range tests {
+		p := yparser.Xpath2GnmiPath(tt.inp, 0)
+		ns, err := c.QueryAll(target, prefix, p)
+		if err != nil {
+			t.Fatalf("QueryAll %s: %v", tt.inp, err)
+		}
+		got := make(map[string]bool)
+		for _, n := range ns {
+			if n.GetAtomic() {
+				t.Errorf("QueryAll %s: got an unexpanded atomic group", tt.inp)
+			}
+			for _, u := range n.GetUpdate() {
+				got[yparser.GnmiPath2XPath(u.GetPath(), true)+"="+u.GetVal().GetStringVal()] = true
+			}
+		}
+		if len(got) != len(tt.exp) {
+			t.Errorf("QueryAll %s: got %v, want %v", tt.inp, got, tt.exp)
+		}
+		for _, e := range tt.exp {
+			if !got[e] {
+				t.Errorf("QueryAll %s: missing %s in %v", tt.inp, e, got)
+			}
+		}
+		d, err := c.GetJson(target, prefix, p, nil)
+		if err != nil {
+			t.Fatalf("GetJson %s: %v", tt.inp, err)
+		}
+		if b, _ := json.Marshal(d); string(b) != tt.json {
+			t.Errorf("GetJson %s: got %s, want %s", tt.inp, b, tt.json)
+		}
+	}
+
+	// an atomic delete removes the whole group
+	if err := c.GnmiUpdate(target, &gnmi.Notification{
+		Timestamp: 3,
+		Prefix:    group,
+		Atomic:    true,
+		Delete:    []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "oper-state"}}}},
+	}); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	p := yparser.Xpath2GnmiPath("/interface[name=e1]/state/counters", 0)
+	if n, err := c.Query(target, prefix, p); err != nil || n != nil {
+		t.Errorf("Query after atomic delete: got %v, %v, want none", n, err)
+	}
+}
+
+func TestDataType(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs}
+	config := &yentry.Entry{Name: "config", Parent: itfce, LeafTypes: map[string]string{"description": "string"}}
+	state := &yentry.Entry{Name: "state", Parent: itfce, ReadOnly: true,
+		LeafTypes: map[string]string{"description": "string", "oper-state": "string"}}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	itfce.Children = map[string]*yentry.Entry{"config": config, "state": state}
+	c := New([]string{target}, WithRootSchema(rs))
+	for xpath, v := range map[string]string{
+		"/interface[name=e1]/name":               "e1",
+		"/interface[name=e1]/config/description": "uplink",
+		"/interface[name=e1]/state/description":  "uplink",
+		"/interface[name=e1]/state/oper-state":   "up",
+	} {
+		if err := c.GnmiUpdate(target, &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath(xpath, 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}}},
+		}); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	tests := []struct {
+		dataType gnmi.GetRequest_DataType
+		leafs    int
+		json     string
+	}{
+		{dataType: gnmi.GetRequest_ALL, leafs: 4,
+			json: `{"interface":[{"config":{"description":"uplink"},"name":"e1","state":{"description":"uplink","oper-state":"up"}}]}`},
+		{dataType: gnmi.GetRequest_CONFIG, leafs: 2,
+			json: `{"interface":[{"config":{"description":"uplink"},"name":"e1"}]}`},
+		{dataType: gnmi.GetRequest_STATE, leafs: 2,
+			json: `{"interface":[{"name":"e1","state":{"description":"uplink","oper-state":"up"}}]}`},
+		{dataType: gnmi.GetRequest_OPERATIONAL, leafs: 1,
+			json: `{"interface":[{"name":"e1","state":{"oper-state":"up"}}]}`},
+	}
+	for _, tt := range tests {
+		ns, err := c.QueryAll(target, prefix, yparser.Xpath2GnmiPath("/interface[name=e1]", 0), yparser.WithDataType(tt.dataType))
+		if err != nil {
+			t.Fatalf("QueryAll: %v", err)
+		}
+		if len(ns) != tt.leafs {
+			t.Errorf("QueryAll %s: got %d leafs, want %d", tt.dataType, len(ns), tt.leafs)
+		}
+		d, err := c.GetJson(target, prefix, &gnmi.Path{}, rs, yparser.WithDataType(tt.dataType))
+		if err != nil {
+			t.Fatalf("GetJson: %v", err)
+		}
+		if b, _ := json.Marshal(d); string(b) != tt.json {
+			t.Errorf("GetJson %s: got %s, want %s", tt.dataType, b, tt.json)
+		}
+	}
+	n, err := c.Query(target, prefix, yparser.Xpath2GnmiPath("/interface[name=e1]/state/oper-state", 0), yparser.WithDataType(gnmi.GetRequest_CONFIG))
+	if err != nil || n != nil {
+		t.Errorf("Query: got %v, %v, want none", n, err)
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	c := New([]string{target})
+	for xpath, v := range map[string]string{
+		"/interface[name=e1]/name":                             "e1",
+		"/interface[name=e1]/admin-state":                      "enable",
+		"/interface[name=e1]/oper-state":                       "up",
+		"/interface[name=e1]/description":                      "uplink",
+		"/interface[name=e1]/subinterface[index=0]/index":      "0",
+		"/interface[name=e1]/subinterface[index=0]/oper-state": "down",
+		"/interface[name=e2]/name":                             "e2",
+		"/interface[name=e2]/oper-state":                       "down",
+	} {
+		if err := c.GnmiUpdate(target, &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath(xpath, 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}}},
+		}); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		opts  []yparser.JSONOption
+		leafs int
+		json  string
+	}{
+		{
+			name: "depth", path: "/interface[name=e1]",
+			opts:  []yparser.JSONOption{yparser.WithDepth(1)},
+			leafs: 4,
+			json:  `{"admin-state":"enable","description":"uplink","name":"e1","oper-state":"up"}`,
+		},
+		{
+			name: "include", path: "/interface[name=e1]",
+			opts:  []yparser.JSONOption{yparser.WithIncludeLeafs("oper-state")},
+			leafs: 4,
+			json:  `{"name":"e1","oper-state":"up","subinterface":[{"index":"0","oper-state":"down"}]}`,
+		},
+		{
+			name: "include with depth", path: "/interface[name=e1]",
+			opts:  []yparser.JSONOption{yparser.WithDepth(1), yparser.WithIncludeLeafs("admin-state", "oper-state")},
+			leafs: 3,
+			json:  `{"admin-state":"enable","name":"e1","oper-state":"up"}`,
+		},
+		{
+			name: "exclude", path: "/interface[name=e2]",
+			opts:  []yparser.JSONOption{yparser.WithExcludeLeafs("oper-state")},
+			leafs: 1,
+			json:  `{"name":"e2"}`,
+		},
+	}
+	for _, tt := range tests {
+		p := yparser.Xpath2GnmiPath(tt.path, 0)
+		ns, err := c.QueryAll(target, prefix, p, tt.opts...)
+		if err != nil {
+			t.Fatalf("QueryAll: %v", err)
+		}
+		if len(ns) != tt.leafs {
+			t.Errorf("%s: QueryAll got %d leafs, want %d", tt.name, len(ns), tt.leafs)
+		}
+		d, err := c.GetJson(target, prefix, p, nil, tt.opts...)
+		if err != nil {
+			t.Fatalf("GetJson: %v", err)
+		}
+		if b, _ := json.Marshal(d); string(b) != tt.json {
+			t.Errorf("%s: GetJson got %s, want %s", tt.name, b, tt.json)
+		}
+	}
+}
+
+func TestQueryWalk(t *testing.T) {
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs}
+	subitfce := &yentry.Entry{Name: "subinterface", Key: []string{"index"}, Parent: itfce}
+	ipv4 := &yentry.Entry{Name: "ipv4", Parent: subitfce}
+	address := &yentry.Entry{Name: "address", Key: []string{"ip"}, Parent: ipv4}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	itfce.Children = map[string]*yentry.Entry{"subinterface": subitfce}
+	subitfce.Children = map[string]*yentry.Entry{"ipv4": ipv4}
+	ipv4.Children = map[string]*yentry.Entry{"address": address}
+
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	c := New([]string{target}, WithRootSchema(rs))
+	for xpath, v := range map[string]string{
+		"/interface[name=e1]/name":                                              "e1",
+		"/interface[name=e1]/oper-state":                                        "up",
+		"/interface[name=e1]/description":                                       "uplink",
+		"/interface[name=e1]/subinterface[index=0]/index":                       "0",
+		"/interface[name=e1]/subinterface[index=0]/ipv4/address[ip=1.1.1.1]/ip": "1.1.1.1",
+	} {
+		if err := c.GnmiUpdate(target, &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath(xpath, 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}}},
+		}); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	p := yparser.Xpath2GnmiPath("/interface[name=e1]", 0)
+	w := newQueryWalk(rs, prefix, p, yparser.GetLeafFilter(yparser.WithDepth(1), yparser.WithExcludeLeafs("description")), nil)
+	fp, err := path.CompletePath(prefix, p)
+	if err != nil {
+		t.Fatalf("CompletePath: %v", err)
+	}
+	keep := w.keep()
+	var leafs []string
+	if err := c.GetCache().QueryFiltered(target, fp,
+		func(s []string, n octree.QueryNode) bool {
+			// the subtree below the depth is not walked
+			if len(s) > 5 {
+				t.Errorf("walked %v below the depth", s)
+			}
+			return keep(s, n)
+		},
+		func(s []string, _ *octree.Leaf, _ interface{}) error {
+			leafs = append(leafs, strings.Join(s, "/"))
+			return nil
+		}); err != nil {
+		t.Fatalf("QueryFiltered: %v", err)
+	}
+	sort.Strings(leafs)
+	if exp := []string{"interface/e1/name", "interface/e1/oper-state"}; !reflect.DeepEqual(leafs, exp) {
+		t.Errorf("QueryFiltered: got %v, want %v", leafs, exp)
+	}
+}
+
+func TestQueryWalkPredicates(t *testing.T) {
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs}
+	subitfce := &yentry.Entry{Name: "subinterface", Key: []string{"index"}, Parent: itfce}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	itfce.Children = map[string]*yentry.Entry{"subinterface": subitfce}
+
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	c := New([]string{target}, WithRootSchema(rs))
+	for xpath, v := range map[string]string{
+		"/interface[name=e1]/name":                        "e1",
+		"/interface[name=e1]/oper-state":                  "up",
+		"/interface[name=e1]/subinterface[index=0]/index": "0",
+		"/interface[name=e2]/name":                        "e2",
+		"/interface[name=e2]/oper-state":                  "down",
+		"/interface[name=e2]/subinterface[index=0]/index": "0",
+	} {
+		if err := c.GnmiUpdate(target, &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath(xpath, 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}}},
+		}); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+
+	// the predicate leaf is not selected by the depth
+	p, ps, err := yparser.ParsePredicates(rs, prefix, yparser.Xpath2GnmiPath("/interface[oper-state=down]/subinterface", 0))
+	if err != nil {
+		t.Fatalf("ParsePredicates: %v", err)
+	}
+	m := newPredicateMatcher(ps)
+	w := newQueryWalk(rs, prefix, p, nil, m)
+	fp, err := path.CompletePath(prefix, p)
+	if err != nil {
+		t.Fatalf("CompletePath: %v", err)
+	}
+	keep := w.keep()
+	var leafs []string
+	if err := c.GetCache().QueryFiltered(target, fp,
+		func(s []string, n octree.QueryNode) bool {
+			// the entry which does not match is not walked
+			if len(s) > 2 && s[1] == "e1" {
+				t.Errorf("walked %v of a list entry which does not match", s)
+			}
+			return keep(s, n)
+		},
+		func(s []string, _ *octree.Leaf, _ interface{}) error {
+			leafs = append(leafs, strings.Join(s, "/"))
+			return nil
+		}); err != nil {
+		t.Fatalf("QueryFiltered: %v", err)
+	}
+	if exp := []string{"interface/e2/subinterface/0/index"}; !reflect.DeepEqual(leafs, exp) {
+		t.Errorf("QueryFiltered: got %v, want %v", leafs, exp)
+	}
+	if r, ok := m.results[entryKey(0, yparser.Xpath2GnmiPath("/interface[name=e2]", 0).GetElem())]; !ok || !r {
+		t.Errorf("predicate result of e2: got %t %t, want true true", r, ok)
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs,
+		LeafTypes: map[string]string{"name": "string", "oper-state": "string", "mtu": "string", "description": "string"},
+	}
+	subitfce := &yentry.Entry{Name: "subinterface", Key: []string{"index"}, Parent: itfce,
+		LeafTypes: map[string]string{"index": "string", "oper-state": "string"},
+	}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	itfce.Children = map[string]*yentry.Entry{"subinterface": subitfce}
+
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	c := New([]string{target}, WithRootSchema(rs))
+	for xpath, v := range map[string]string{
+		"/interface[name=e1]/name":                             "e1",
+		"/interface[name=e1]/oper-state":                       "up",
+		"/interface[name=e1]/mtu":                              "9216",
+		"/interface[name=e1]/description":                      "uplink",
+		"/interface[name=e1]/subinterface[index=0]/index":      "0",
+		"/interface[name=e1]/subinterface[index=0]/oper-state": "down",
+		"/interface[name=e2]/name":                             "e2",
+		"/interface[name=e2]/oper-state":                       "down",
+		"/interface[name=e2]/mtu":                              "1500",
+		"/interface[name=e3]/name":                             "e3",
+	} {
+		if err := c.GnmiUpdate(target, &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath(xpath, 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}}},
+		}); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+	down, err := yparser.NewPredicate(yparser.Xpath2GnmiPath("/interface", 0), "oper-state", yparser.PredicateEqual, "down")
+	if err != nil {
+		t.Fatalf("NewPredicate: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		opts  []yparser.JSONOption
+		leafs int
+		json  string
+	}{
+		{
+			name: "equal", path: "/interface[oper-state=down]",
+			leafs: 3,
+			json:  `{"interface":[{"mtu":"1500","name":"e2","oper-state":"down"}]}`,
+		},
+		{
+			// the entries without the leaf do not match
+			name: "not equal", path: "/interface[oper-state!=down]",
+			leafs: 6,
+			json:  `{"interface":[{"description":"uplink","mtu":"9216","name":"e1","oper-state":"up","subinterface":[{"index":"0","oper-state":"down"}]}]}`,
+		},
+		{
+			name: "regex", path: "/interface[description~=^up]",
+			leafs: 6,
+			json:  `{"interface":[{"description":"uplink","mtu":"9216","name":"e1","oper-state":"up","subinterface":[{"index":"0","oper-state":"down"}]}]}`,
+		},
+		{
+			name: "greater", path: "/interface[mtu>9000]",
+			leafs: 6,
+			json:  `{"interface":[{"description":"uplink","mtu":"9216","name":"e1","oper-state":"up","subinterface":[{"index":"0","oper-state":"down"}]}]}`,
+		},
+		{
+			name: "less or equal with exact key", path: "/interface[name=e2,mtu<=1500]",
+			leafs: 3,
+			json:  `{"mtu":"1500","name":"e2","oper-state":"down"}`,
+		},
+		{
+			name: "exists", path: "/interface[description]",
+			opts:  []yparser.JSONOption{yparser.WithIncludeLeafs("mtu")},
+			leafs: 3,
+			json:  `{"interface":[{"mtu":"9216","name":"e1","subinterface":[{"index":"0"}]}]}`,
+		},
+		{
+			// the predicate leaf is outside the query path
+			name: "parent list", path: "/interface[oper-state=up]/subinterface",
+			leafs: 2,
+			json:  `{"subinterface":[{"index":"0","oper-state":"down"}]}`,
+		},
+		{
+			// the predicate leaf is filtered from the results
+			name: "option", path: "/interface",
+			opts:  []yparser.JSONOption{yparser.WithPredicates(down), yparser.WithExcludeLeafs("oper-state")},
+			leafs: 2,
+			json:  `{"interface":[{"mtu":"1500","name":"e2"}]}`,
+		},
+		{
+			name: "no match", path: "/interface[oper-state=testing]",
+			json: `null`,
+		},
+	}
+	for _, tt := range tests {
+		p := yparser.Xpath2GnmiPath(tt.path, 0)
+		ns, err := c.QueryAll(target, prefix, p, tt.opts...)
+		if err != nil {
+			t.Fatalf("QueryAll: %v", err)
+		}
+		if len(ns) != tt.leafs {
+			t.Errorf("%s: QueryAll got %d leafs, want %d", tt.name, len(ns), tt.leafs)
+		}
+		d, err := c.GetJson(target, prefix, p, rs, tt.opts...)
+		if err != nil {
+			t.Fatalf("GetJson: %v", err)
+		}
+		if b, _ := json.Marshal(d); string(b) != tt.json {
+			t.Errorf("%s: GetJson got %s, want %s", tt.name, b, tt.json)
+		}
+	}
+
+	n, err := c.Query(target, prefix, yparser.Xpath2GnmiPath("/interface[oper-state=down]/mtu", 0))
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if v := n.GetUpdate()[0].GetVal().GetStringVal(); v != "1500" {
+		t.Errorf("Query: got %s, want 1500", v)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cachemetrics exports the per target metadata of the caches as
+// prometheus metrics.
+package cachemetrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/latency"
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/occache"
+)
+
+// Cache roles used as value of the role label.
+const (
+	RoleConfig = "config"
+	RoleState  = "state"
+	RoleTarget = "target"
+)
+
+const (
+	namespace = "ndd"
+	subsystem = "cache"
+)
+
+var labels = []string{"role", "target"}
+
+// metric maps an integer metadata value to a prometheus metric.
+type metric struct {
+	meta      string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	scale     float64
+}
+
+func newMetric(meta, name, help string, valueType prometheus.ValueType, scale float64) metric {
+	return metric{
+		meta:      meta,
+		desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil),
+		valueType: valueType,
+		scale:     scale,
+	}
+}
+
+var (
+	intMetrics = []metric{
+		newMetric(metadata.LeafCount, "leaves", "Number of leaves in the cache.", prometheus.GaugeValue, 1),
+		newMetric(metadata.AddCount, "leaves_added_total", "Total number of leaves added.", prometheus.CounterValue, 1),
+		newMetric(metadata.DelCount, "leaves_deleted_total", "Total number of leaves deleted.", prometheus.CounterValue, 1),
+		newMetric(metadata.UpdateCount, "leaves_updated_total", "Total number of leaf updates received.", prometheus.CounterValue, 1),
+		newMetric(metadata.StaleCount, "leaves_stale_total", "Total number of leaf updates older than the cached value.", prometheus.CounterValue, 1),
+		newMetric(metadata.SuppressedCount, "leaves_suppressed_total", "Total number of leaf updates with the cached value.", prometheus.CounterValue, 1),
+		newMetric(metadata.EmptyCount, "notifications_empty_total", "Total number of notifications without updates or deletes.", prometheus.CounterValue, 1),
+		newMetric(metadata.Size, "size_bytes", "Size of the values in the cache.", prometheus.GaugeValue, 1),
+		newMetric(metadata.LatestTimestamp, "latest_timestamp_seconds", "Latest timestamp of an update.", prometheus.GaugeValue, 1e-9),
+		newMetric(occache.EvictCount, "leaves_evicted_total", "Total number of leaves evicted to stay within the quota.", prometheus.CounterValue, 1),
+		newMetric(occache.RejectCount, "leaves_rejected_total", "Total number of leaf updates rejected by the quota.", prometheus.CounterValue, 1),
+		newMetric(occache.QuotaSize, "quota_size_bytes", "Size accounted against the quota.", prometheus.GaugeValue, 1),
+	}
+	boolMetrics = []metric{
+		newMetric(metadata.Connected, "connected", "Whether updates are received for the target.", prometheus.GaugeValue, 1),
+		newMetric(metadata.Sync, "sync", "Whether the cache is in sync with the target.", prometheus.GaugeValue, 1),
+	}
+	latencyDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "latency_seconds"),
+		"Latency of the updates during a time window.", append(labels, "window", "stat"), nil)
+)
+
+// Collector is a prometheus.Collector of the metadata of the caches.
+type Collector struct {
+	mu     sync.RWMutex
+	caches map[string]*occache.Cache
+}
+
+// NewCollector returns a Collector without caches.
+func NewCollector() *Collector {
+	return &Collector{
+		caches: make(map[string]*occache.Cache),
+	}
+}
+
+// Add adds the occache with role to the collector.
+func (c *Collector) Add(role string, oc *occache.Cache) {
+	defer c.mu.Unlock()
+	c.mu.Lock()
+	c.caches[role] = oc
+}
+
+// AddCache adds the cache with role to the collector.
+func (c *Collector) AddCache(role string, cc *cache.Cache) {
+	c.Add(role, cc.GetCache())
+}
+
+// Remove removes the cache with role from the collector.
+func (c *Collector) Remove(role string) {
+	defer c.mu.Unlock()
+	c.mu.Lock()
+	delete(c.caches, role)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range intMetrics {
+		ch <- m.desc
+	}
+	for _, m := range boolMetrics {
+		ch <- m.desc
+	}
+	ch <- latencyDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	defer c.mu.RUnlock()
+	c.mu.RLock()
+	for role, oc := range c.caches {
+		for target, md := range oc.Metadata() {
+			for _, m := range intMetrics {
+				v, err := md.GetInt(m.meta)
+				if err != nil {
+					continue
+				}
+				ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, float64(v)*m.scale, role, target)
+			}
+			for _, m := range boolMetrics {
+				v, err := md.GetBool(m.meta)
+				if err != nil {
+					continue
+				}
+				var f float64
+				if v {
+					f = 1
+				}
+				ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, f, role, target)
+			}
+			for _, w := range oc.LatencyWindows() {
+				for _, st := range []latency.StatType{latency.Avg, latency.Max, latency.Min} {
+					v, err := md.GetInt(latency.MetadataName(w, st))
+					if err != nil {
+						continue
+					}
+					ch <- prometheus.MustNewConstMetric(latencyDesc, prometheus.GaugeValue, time.Duration(v).Seconds(),
+						role, target, latency.CompactDurationString(w), st.String())
+				}
+			}
+		}
+	}
+}
+
+// Run updates the metadata and size of the caches every period until ctx is
+// done.
+func (c *Collector) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.update()
+		}
+	}
+}
+
+func (c *Collector) update() {
+	defer c.mu.RUnlock()
+	c.mu.RLock()
+	for _, oc := range c.caches {
+		oc.UpdateSize()
+		oc.UpdateMetadata()
+	}
+}
@@ -0,0 +1,52 @@
+package cachemetrics
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yndd/ndd-yang/pkg/occache"
+)
+
+func TestCollector(t *testing.T) {
+	target := "dev1"
+	oc := occache.New([]string{target})
+	oc.Connect(target)
+	n := &pb.Notification{
+		Timestamp: 1,
+		Prefix:    &pb.Path{Target: target},
+		Update: []*pb.Update{{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: "a"}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "a"}},
+		}},
+	}
+	if err := oc.GnmiUpdate(n); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+
+	c := NewCollector()
+	c.Add(RoleState, oc)
+	c.update()
+
+	exp := `
+# HELP ndd_cache_connected Whether updates are received for the target.
+# TYPE ndd_cache_connected gauge
+ndd_cache_connected{role="state",target="dev1"} 1
+# HELP ndd_cache_leaves Number of leaves in the cache.
+# TYPE ndd_cache_leaves gauge
+ndd_cache_leaves{role="state",target="dev1"} 1
+# HELP ndd_cache_leaves_added_total Total number of leaves added.
+# TYPE ndd_cache_leaves_added_total counter
+ndd_cache_leaves_added_total{role="state",target="dev1"} 1
+`
+	names := []string{"ndd_cache_connected", "ndd_cache_leaves", "ndd_cache_leaves_added_total"}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), names...); err != nil {
+		t.Error(err)
+	}
+
+	c.Remove(RoleState)
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Errorf("got %d metrics after Remove, want 0", n)
+	}
+}
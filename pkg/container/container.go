@@ -37,6 +37,9 @@ type Container struct {
 	Prev             *Container         `json:"prev,omitempty"`
 	ResourceBoundary bool               `json:"resourceBoundry,omitempty"`
 	LeafRefs         []*leafref.LeafRef `json:"leafRefs,omitempty"`
+	Presence         bool               `json:"presence,omitempty"`
+	// Choices holds the members of the cases per choice, indexed by choice and case name
+	Choices map[string]map[string][]string `json:"choices,omitempty"`
 }
 
 type ContainerOption func(c *Container)
@@ -53,15 +56,54 @@ func NewContainer(e *yang.Entry, namespace, moduleName string, readOnly, resourc
 		Prev:             prev,
 		Children:         make([]*Container, 0),
 		ResourceBoundary: resourceBoundary,
+		Presence:         IsPresence(e),
 	}
 
 	for _, o := range opts {
 		o(c)
 	}
 
+	if prev != nil {
+		for choice, cs := range GetChoiceCases(e) {
+			prev.AddChoiceMember(choice, cs, e.Name)
+		}
+	}
+
 	return c
 }
 
+// IsPresence returns true if the yang entry is a presence container
+func IsPresence(e *yang.Entry) bool {
+	c, ok := e.Node.(*yang.Container)
+	return ok && c.Presence != nil
+}
+
+// GetChoiceCases returns the case per choice of which the yang entry is a
+// member, indexed by choice name. A choice nested in a case makes the entry a
+// member of the case of the nested choice and of all enclosing cases. The
+// result is empty if the entry is not part of a choice.
+func GetChoiceCases(e *yang.Entry) map[string]string {
+	cases := make(map[string]string)
+	cs, child := "", e.Name
+	for p := e.Parent; p != nil; p = p.Parent {
+		switch {
+		case p.IsCase():
+			cs = p.Name
+		case p.IsChoice():
+			if cs == "" {
+				// shorthand case
+				cs = child
+			}
+			cases[p.Name] = cs
+			cs = ""
+		default:
+			return cases
+		}
+		child = p.Name
+	}
+	return cases
+}
+
 func (c *Container) GetName() string {
 	return c.Name
 }
@@ -89,10 +131,102 @@ func (c *Container) GetEntries() []*Entry {
 	return c.Entries
 }
 
+func (c *Container) GetPresence() bool {
+	return c.Presence
+}
+
+func (c *Container) GetChoices() map[string]map[string][]string {
+	return c.Choices
+}
+
+// AddChoiceMember adds the leaf or container name to the case of the choice
+func (c *Container) AddChoiceMember(choice, cs, name string) {
+	if c.Choices == nil {
+		c.Choices = make(map[string]map[string][]string)
+	}
+	if _, ok := c.Choices[choice]; !ok {
+		c.Choices[choice] = make(map[string][]string)
+	}
+	for _, n := range c.Choices[choice][cs] {
+		if n == name {
+			return
+		}
+	}
+	c.Choices[choice][cs] = append(c.Choices[choice][cs], name)
+}
+
 func (c *Container) GetDefaults() map[string]string {
 	return c.Defaults
 }
 
+// GetLeafTypes returns the type of the leafs indexed by name
+func (c *Container) GetLeafTypes() map[string]string {
+	t := make(map[string]string)
+	for _, e := range c.GetEntries() {
+		if e.Next == nil {
+			t[e.Name] = e.Type
+		}
+	}
+	return t
+}
+
+// GetOrderedByUser returns the names of the lists and leaf-lists which are
+// ordered by user
+func (c *Container) GetOrderedByUser() map[string]bool {
+	o := make(map[string]bool)
+	for _, e := range c.GetEntries() {
+		if la := e.GetListAttr(); la != nil && la.OrderedBy != nil && la.OrderedBy.Name == "user" {
+			o[e.Name] = true
+		}
+	}
+	return o
+}
+
+// GetEnums returns the values of the enumeration leafs indexed by name
+func (c *Container) GetEnums() map[string][]string {
+	enums := make(map[string][]string)
+	for _, e := range c.GetEntries() {
+		if e.Next == nil && len(e.GetEnum()) != 0 {
+			enums[e.Name] = e.GetEnum()
+		}
+	}
+	return enums
+}
+
+// GetIdentityRefs returns the identities derived from the base of the
+// identityref leafs indexed by name
+func (c *Container) GetIdentityRefs() map[string][]string {
+	ids := make(map[string][]string)
+	for _, e := range c.GetEntries() {
+		if e.Next == nil && e.GetIdentityBase() != "" {
+			ids[e.Name] = e.GetIdentities()
+		}
+	}
+	return ids
+}
+
+// GetUnionTypes returns the member types of the union leafs indexed by name
+func (c *Container) GetUnionTypes() map[string][]*UnionType {
+	ts := make(map[string][]*UnionType)
+	for _, e := range c.GetEntries() {
+		if e.Next == nil && len(e.GetUnionTypes()) != 0 {
+			ts[e.Name] = e.GetUnionTypes()
+		}
+	}
+	return ts
+}
+
+// GetReadOnlyLeafs returns the names of the config false leafs
+func (c *Container) GetReadOnlyLeafs() map[string]bool {
+	ro := make(map[string]bool)
+	for _, e := range c.GetEntries() {
+		if e.Next == nil && e.GetReadOnly() {
+			ro[e.Name] = true
+		}
+	}
+	return ro
+}
+
 func (c *Container) GetKeyType(name string) string {
 	if c.Entries != nil {
 		for _, e := range c.GetEntries() {
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+func TestGetChoiceCases(t *testing.T) {
+	// container action { choice result { case accept { leaf accept; }
+	//   case reject { choice reason { leaf code; case text { leaf text; } } } } }
+	action := &yang.Entry{Name: "action", Kind: yang.DirectoryEntry}
+	result := &yang.Entry{Name: "result", Kind: yang.ChoiceEntry, Parent: action}
+	accept := &yang.Entry{Name: "accept", Kind: yang.CaseEntry, Parent: result}
+	reject := &yang.Entry{Name: "reject", Kind: yang.CaseEntry, Parent: result}
+	reason := &yang.Entry{Name: "reason", Kind: yang.ChoiceEntry, Parent: reject}
+	text := &yang.Entry{Name: "text", Kind: yang.CaseEntry, Parent: reason}
+
+	tests := []struct {
+		name string
+		e    *yang.Entry
+		exp  map[string]string
+	}{
+		{name: "no choice", e: &yang.Entry{Name: "log", Parent: action}, exp: map[string]string{}},
+		{name: "case", e: &yang.Entry{Name: "accept", Parent: accept}, exp: map[string]string{"result": "accept"}},
+		{name: "nested case", e: &yang.Entry{Name: "text", Parent: text}, exp: map[string]string{"result": "reject", "reason": "text"}},
+		{name: "nested shorthand case", e: &yang.Entry{Name: "code", Parent: reason}, exp: map[string]string{"result": "reject", "reason": "code"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetChoiceCases(tt.e); !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("GetChoiceCases: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
@@ -45,6 +45,20 @@ type Entry struct {
 	ListAttr      *yang.ListAttr `json:"listAttr,omitempty"`
 	LeafRef       bool           `json:"leafref,omitempty"`
 	RemotePath    *gnmi.Path     `json:"remote-path,omitempty"`
+	Kind          string         `json:"kind,omitempty"`
+	IdentityBase  string         `json:"identityBase,omitempty"`
+	Identities    []string       `json:"identities,omitempty"`
+	UnionTypes    []*UnionType   `json:"unionTypes,omitempty"`
+}
+
+// UnionType is a member type of a union with its restrictions
+type UnionType struct {
+	Kind       string   `json:"kind,omitempty"`
+	Pattern    []string `json:"pattern,omitempty"`
+	Range      []int    `json:"range,omitempty"`
+	Length     []int    `json:"length,omitempty"`
+	Enum       []string `json:"enum,omitempty"`
+	Identities []string `json:"identities,omitempty"`
 }
 
 // Option can be used to manipulate Options.
@@ -68,6 +82,21 @@ func WithEnum(s []string) EntryOption {
 	}
 }
 
+func WithKind(s string) EntryOption {
+	return func(c *Entry) {
+		c.Kind = s
+	}
+}
+
+// WithIdentities sets the identity base of an identityref and the identities
+// derived from it
+func WithIdentities(base string, ids []string) EntryOption {
+	return func(c *Entry) {
+		c.IdentityBase = base
+		c.Identities = ids
+	}
+}
+
 func WithRange(s []int) EntryOption {
 	return func(c *Entry) {
 		c.Range = s
@@ -92,6 +121,12 @@ func WithUnion(b bool) EntryOption {
 	}
 }
 
+func WithUnionTypes(ts []*UnionType) EntryOption {
+	return func(c *Entry) {
+		c.UnionTypes = ts
+	}
+}
+
 func WithMandatory(b bool) EntryOption {
 	return func(c *Entry) {
 		c.Mandatory = b
@@ -167,6 +202,18 @@ func (e *Entry) GetEnumString() string {
 	return e.EnumString
 }
 
+func (e *Entry) GetKind() string {
+	return e.Kind
+}
+
+func (e *Entry) GetIdentityBase() string {
+	return e.IdentityBase
+}
+
+func (e *Entry) GetIdentities() []string {
+	return e.Identities
+}
+
 func (e *Entry) GetRange() []int {
 	return e.Range
 }
@@ -187,6 +234,10 @@ func (e *Entry) GetUnion() bool {
 	return e.Union
 }
 
+func (e *Entry) GetUnionTypes() []*UnionType {
+	return e.UnionTypes
+}
+
 func (e *Entry) GetMandatory() bool {
 	return e.Mandatory
 }
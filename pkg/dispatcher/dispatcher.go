@@ -11,12 +11,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-type HandleConfigEventFunc func(log logging.Logger, cc, sc, tc *cache.Cache, c client.Client, prefix *gnmi.Path, p []*gnmi.PathElem, d interface{}) Handler
+// HandleConfigEventFunc returns the Handler for a config event on the subtree p,
+// od is the value of the subtree before the event was applied and nd the value after
+type HandleConfigEventFunc func(log logging.Logger, cc, sc, tc *cache.Cache, c client.Client, prefix *gnmi.Path, p []*gnmi.PathElem, od, nd interface{}) Handler
 
 type Dispatcher interface {
 	Init(resources []*gnmi.Path)
 	GetTree() *dtree.Tree
 	GetPathElem(p *gnmi.Path) []*gnmi.PathElem
+	Resolve(p *gnmi.Path) *ResourceRef
+	Group(ns []*gnmi.Notification) []*ResourceNotifications
 	ShowTree()
 }
 
@@ -2,10 +2,15 @@ package dispatcher
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/openconfig/gnmi/path"
 	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/tracing"
+	"github.com/yndd/ndd-yang/pkg/yentry"
 	"github.com/yndd/ndd-yang/pkg/yparser"
 )
 
@@ -137,3 +142,177 @@ func getPath2Process(p *gnmi.Path, pe []*gnmi.PathElem) (string, *gnmi.Path) {
 	}
 	return key, newPath
 }
+
+func TestGetConfigEvent(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	pe := []*gnmi.PathElem{
+		{Name: "ipam"},
+		{Name: "tenant", Key: map[string]string{"name": "default"}},
+	}
+	rs := &yentry.Entry{
+		Children: map[string]*yentry.Entry{
+			"ipam": {
+				Name: "ipam",
+				Children: map[string]*yentry.Entry{
+					"tenant": {Name: "tenant", Key: []string{"name"}},
+				},
+			},
+		},
+	}
+
+	cc := cache.New([]string{target})
+
+	o, od, err := GetConfigEvent(cc, rs, prefix, pe, false)
+	if err != nil {
+		t.Fatalf("GetConfigEvent: %v", err)
+	}
+	if o != OperationCreate || od != nil {
+		t.Errorf("GetConfigEvent: got %s %v, want %s <nil>", o, od, OperationCreate)
+	}
+
+	n := &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    prefix,
+		Update: []*gnmi.Update{
+			{
+				Path: &gnmi.Path{Elem: append(pe, &gnmi.PathElem{Name: "admin-state"})},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "enable"}},
+			},
+		},
+	}
+	if err := cc.GnmiUpdate(target, n); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+
+	o, od, err = GetConfigEvent(cc, rs, prefix, pe, false)
+	if err != nil {
+		t.Fatalf("GetConfigEvent: %v", err)
+	}
+	exp := map[string]interface{}{"admin-state": "enable"}
+	if o != OperationUpdate || !reflect.DeepEqual(od, exp) {
+		t.Errorf("GetConfigEvent: got %s %v, want %s %v", o, od, OperationUpdate, exp)
+	}
+
+	o, od, err = GetConfigEvent(cc, rs, prefix, pe, true)
+	if err != nil {
+		t.Fatalf("GetConfigEvent: %v", err)
+	}
+	if o != OperationDelete || !reflect.DeepEqual(od, exp) {
+		t.Errorf("GetConfigEvent: got %s %v, want %s %v", o, od, OperationDelete, exp)
+	}
+}
+
+func TestGetConfigEventTrace(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	pe := []*gnmi.PathElem{{Name: "ipam"}}
+	r := tracing.NewRecorder()
+	cc := cache.New([]string{target}, cache.WithTracer(r))
+
+	if _, _, err := GetConfigEvent(cc, nil, prefix, pe, false); err != nil {
+		t.Fatalf("GetConfigEvent: %v", err)
+	}
+	events := r.SpansByName("dispatcher.GetConfigEvent")
+	if len(events) != 1 {
+		t.Fatalf("got %d event spans, want 1", len(events))
+	}
+	if v, _ := events[0].Attribute(tracing.AttrOperation); v != string(OperationCreate) {
+		t.Errorf("got operation %v, want %s", v, OperationCreate)
+	}
+	gets := r.SpansByName("cache.GetJson")
+	if len(gets) != 1 || gets[0].Parent != events[0] {
+		t.Errorf("cache.GetJson is not a child span of the event")
+	}
+}
+
+// eventHandler records the config events it handles.
+type eventHandler struct {
+	Handler
+	o      Operation
+	od, nd interface{}
+}
+
+func (h *eventHandler) HandleConfigEvent(o Operation, prefix *gnmi.Path, pe []*gnmi.PathElem, od, nd interface{}) (Handler, error) {
+	h.o, h.od, h.nd = o, od, nd
+	return h, nil
+}
+
+func TestDispatchConfigEvent(t *testing.T) {
+	target := "dev1"
+	pe := []*gnmi.PathElem{
+		{Name: "ipam"},
+		{Name: "tenant", Key: map[string]string{"name": "default"}},
+	}
+	rs := &yentry.Entry{
+		Children: map[string]*yentry.Entry{
+			"ipam": {
+				Name: "ipam",
+				Children: map[string]*yentry.Entry{
+					"tenant": {Name: "tenant", Key: []string{"name"}},
+				},
+			},
+		},
+	}
+	// the notifications are relative to the ipam prefix
+	prefix := &gnmi.Path{Target: target, Elem: []*gnmi.PathElem{{Name: "ipam"}}}
+	update := func(v string) *gnmi.Notification {
+		return &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    prefix,
+			Update: []*gnmi.Update{{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{pe[1], {Name: "admin-state"}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}},
+			}},
+		}
+	}
+	tests := []struct {
+		name string
+		n    *gnmi.Notification
+		o    Operation
+		od   interface{}
+		nd   interface{}
+	}{
+		{
+			name: "create",
+			n:    update("enable"),
+			o:    OperationCreate,
+			nd:   map[string]interface{}{"admin-state": "enable"},
+		},
+		{
+			name: "update",
+			n:    update("disable"),
+			o:    OperationUpdate,
+			od:   map[string]interface{}{"admin-state": "enable"},
+			nd:   map[string]interface{}{"admin-state": "disable"},
+		},
+		{
+			name: "delete",
+			n: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Prefix:    prefix,
+				Delete:    []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "tenant", Key: map[string]string{"name": "*"}}}}},
+			},
+			o:  OperationDelete,
+			od: map[string]interface{}{"admin-state": "disable"},
+		},
+	}
+	cc := cache.New([]string{target})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &eventHandler{}
+			if _, err := DispatchConfigEvent(h, cc, rs, pe, tt.n); err != nil {
+				t.Fatalf("DispatchConfigEvent: %v", err)
+			}
+			if h.o != tt.o {
+				t.Errorf("DispatchConfigEvent: got operation %s, want %s", h.o, tt.o)
+			}
+			if !reflect.DeepEqual(h.od, tt.od) {
+				t.Errorf("DispatchConfigEvent: got old data %v, want %v", h.od, tt.od)
+			}
+			if !reflect.DeepEqual(h.nd, tt.nd) {
+				t.Errorf("DispatchConfigEvent: got new data %v, want %v", h.nd, tt.nd)
+			}
+		})
+	}
+}
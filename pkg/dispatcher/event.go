@@ -0,0 +1,134 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/tracing"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// GetConfigEvent returns the operation of a config event for the subtree pe
+// together with the previous value of the subtree. The previous value is read
+// from the config cache, so it needs to be called before the update or delete
+// is applied to the cache.
+// A set on a subtree which is not yet present in the cache is a create, a set
+// on an existing subtree is an update.
+func GetConfigEvent(cc *cache.Cache, rs *yentry.Entry, prefix *gnmi.Path, pe []*gnmi.PathElem, del bool) (Operation, interface{}, error) {
+	ctx, span := cc.Tracer().Start(cc.Context(), "dispatcher.GetConfigEvent",
+		tracing.String(tracing.AttrTarget, prefix.GetTarget()),
+		tracing.Path(tracing.AttrPath, &gnmi.Path{Elem: pe}))
+	defer span.End()
+	od, err := cc.WithContext(ctx).GetJson(prefix.GetTarget(), prefix, &gnmi.Path{Elem: pe}, rs)
+	if err != nil {
+		span.RecordError(err)
+		return "", nil, err
+	}
+	o := OperationUpdate
+	switch {
+	case del:
+		o = OperationDelete
+	case od == nil:
+		o = OperationCreate
+	}
+	span.SetAttributes(tracing.String(tracing.AttrOperation, o.String()))
+	return o, od, nil
+}
+
+// DispatchConfigEvent applies the config notification n for the resource
+// subtree pe, as grouped by Group, to the config cache and invokes the
+// HandleConfigEvent of the handler h. The operation and the previous value of
+// the subtree are read with GetConfigEvent before n is applied, the new value
+// after. A delete of pe or of one of its parents is a delete event.
+func DispatchConfigEvent(h Handler, cc *cache.Cache, rs *yentry.Entry, pe []*gnmi.PathElem, n *gnmi.Notification) (Handler, error) {
+	// pe is the complete path of the resource, the prefix elements of n are
+	// folded into its paths so the data is read back with the same paths
+	prefix := &gnmi.Path{Origin: n.GetPrefix().GetOrigin(), Target: n.GetPrefix().GetTarget()}
+	n = withPrefix(n, prefix)
+	o, od, err := GetConfigEvent(cc, rs, prefix, pe, deletesSubtree(n, pe))
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.GnmiUpdate(prefix.GetTarget(), n); err != nil {
+		return nil, err
+	}
+	var nd interface{}
+	if o != OperationDelete {
+		if nd, err = cc.GetJson(prefix.GetTarget(), prefix, &gnmi.Path{Elem: pe}, rs); err != nil {
+			return nil, err
+		}
+	}
+	return h.HandleConfigEvent(o, prefix, pe, od, nd)
+}
+
+// withPrefix returns n with the prefix elements moved into the paths of the
+// updates and deletes and the prefix replaced by prefix.
+func withPrefix(n *gnmi.Notification, prefix *gnmi.Path) *gnmi.Notification {
+	if len(n.GetPrefix().GetElem()) == 0 {
+		return n
+	}
+	complete := func(p *gnmi.Path) *gnmi.Path {
+		return &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), p.GetElem()...)}
+	}
+	cn := &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    prefix,
+		Alias:     n.GetAlias(),
+		Atomic:    n.GetAtomic(),
+	}
+	for _, u := range n.GetUpdate() {
+		cn.Update = append(cn.Update, &gnmi.Update{Path: complete(u.GetPath()), Val: u.GetVal(), Duplicates: u.GetDuplicates()})
+	}
+	for _, d := range n.GetDelete() {
+		cn.Delete = append(cn.Delete, complete(d))
+	}
+	return cn
+}
+
+// deletesSubtree returns true if one of the deletes of n removes the subtree
+// pe.
+func deletesSubtree(n *gnmi.Notification, pe []*gnmi.PathElem) bool {
+	for _, d := range n.GetDelete() {
+		elems := d.GetElem()
+		if len(elems) > len(pe) {
+			continue
+		}
+		match := true
+		for i, e := range elems {
+			if e.GetName() != pe[i].GetName() || !matchKeys(e.GetKey(), pe[i].GetKey()) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKeys returns true if the keys k of a delete path select the keys pk,
+// a wildcard key selects any value.
+func matchKeys(k, pk map[string]string) bool {
+	for n, v := range k {
+		if v != "*" && v != pk[n] {
+			return false
+		}
+	}
+	return true
+}
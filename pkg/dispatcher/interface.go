@@ -10,7 +10,9 @@ import (
 )
 
 type Handler interface {
-	HandleConfigEvent(o Operation, prefix *gnmi.Path, pe []*gnmi.PathElem, d interface{}) (Handler, error)
+	// HandleConfigEvent handles a config event for the subtree pe, od is the value
+	// of the subtree before the event was applied and nd the value after
+	HandleConfigEvent(o Operation, prefix *gnmi.Path, pe []*gnmi.PathElem, od, nd interface{}) (Handler, error)
 	SetParent(interface{}) error
 	SetRootSchema(rs *yentry.Entry)
 	GetChildren() map[string]string
@@ -105,7 +107,7 @@ type Operation string
 // Operations Kinds.
 const (
 	// create
-	//OperationCreate Operation = "Create"
+	OperationCreate Operation = "Create"
 	// update
 	OperationUpdate Operation = "Update"
 	// delete
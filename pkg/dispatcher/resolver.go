@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"github.com/openconfig/gnmi/path"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+)
+
+// ResourceRef is the resource owning a path.
+type ResourceRef struct {
+	// Template is the registered resource path, with wildcard keys
+	Template []*gnmi.PathElem
+	// PathElem is the resource path with the keys bound to the owned path
+	PathElem []*gnmi.PathElem
+}
+
+// Key returns the xpath of the resource which is unique per resource instance.
+func (r *ResourceRef) Key() string {
+	return yparser.GnmiPath2XPath(&gnmi.Path{Elem: r.PathElem}, true)
+}
+
+// Keys returns the key bindings of the resource.
+func (r *ResourceRef) Keys() map[string]string {
+	keys := make(map[string]string)
+	for _, pe := range r.PathElem {
+		for k, v := range pe.GetKey() {
+			keys[k] = v
+		}
+	}
+	return keys
+}
+
+// ResourceNotifications are the notifications of a target for paths owned by
+// a single resource.
+type ResourceNotifications struct {
+	Target        string
+	Resource      *ResourceRef
+	Notifications []*gnmi.Notification
+}
+
+// Resolve returns the resource owning the concrete path p, nil if p is not
+// owned by any of the registered resources. The resources are typically
+// the Resources collected in the root schema by yentry.Entry.Register.
+func (r *dispatcher) Resolve(p *gnmi.Path) *ResourceRef {
+	o, ok := r.GetTree().GetLpmValue(path.ToStrings(p, false)).(dispatcherConfig)
+	if !ok || len(o.PathElem) > len(p.GetElem()) {
+		return nil
+	}
+	rr := &ResourceRef{
+		Template: o.PathElem,
+		PathElem: make([]*gnmi.PathElem, 0, len(o.PathElem)),
+	}
+	for i, tpe := range o.PathElem {
+		pe := &gnmi.PathElem{Name: tpe.GetName()}
+		if len(tpe.GetKey()) != 0 {
+			pe.Key = make(map[string]string)
+			for k := range tpe.GetKey() {
+				pe.Key[k] = p.GetElem()[i].GetKey()[k]
+			}
+		}
+		rr.PathElem = append(rr.PathElem, pe)
+	}
+	return rr
+}
+
+// Group splits the notifications per target and owning resource. Each
+// resulting notification keeps the prefix and timestamp of the original one
+// and holds the updates and deletes owned by the resource. Paths which are not
+// owned by a resource are dropped. The groups are returned in the order the
+// resources were first seen.
+func (r *dispatcher) Group(ns []*gnmi.Notification) []*ResourceNotifications {
+	groups := make([]*ResourceNotifications, 0)
+	idx := make(map[string]*ResourceNotifications)
+
+	for _, n := range ns {
+		// the per resource notification derived from n
+		rns := make(map[*ResourceNotifications]*gnmi.Notification)
+		get := func(p *gnmi.Path) *gnmi.Notification {
+			rr := r.Resolve(&gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), p.GetElem()...)})
+			if rr == nil {
+				return nil
+			}
+			target := n.GetPrefix().GetTarget()
+			key := target + rr.Key()
+			g, ok := idx[key]
+			if !ok {
+				g = &ResourceNotifications{Target: target, Resource: rr}
+				idx[key] = g
+				groups = append(groups, g)
+			}
+			rn, ok := rns[g]
+			if !ok {
+				rn = &gnmi.Notification{
+					Timestamp: n.GetTimestamp(),
+					Prefix:    n.GetPrefix(),
+					Alias:     n.GetAlias(),
+					Atomic:    n.GetAtomic(),
+				}
+				rns[g] = rn
+				g.Notifications = append(g.Notifications, rn)
+			}
+			return rn
+		}
+		for _, u := range n.GetUpdate() {
+			if rn := get(u.GetPath()); rn != nil {
+				rn.Update = append(rn.Update, u)
+			}
+		}
+		for _, d := range n.GetDelete() {
+			if rn := get(d); rn != nil {
+				rn.Delete = append(rn.Delete, d)
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,111 @@
+package dispatcher
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+)
+
+func resolverSchema() *yentry.Entry {
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs, ResourceBoundary: true}
+	subitfce := &yentry.Entry{Name: "subinterface", Key: []string{"index"}, Parent: itfce, ResourceBoundary: true}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	itfce.Children = map[string]*yentry.Entry{"subinterface": subitfce}
+	itfce.Register(&gnmi.Path{})
+	subitfce.Register(&gnmi.Path{})
+	return rs
+}
+
+func TestResolve(t *testing.T) {
+	d := New()
+	d.Init(resolverSchema().Resources)
+
+	tests := []struct {
+		path string
+		exp  string
+		keys map[string]string
+	}{
+		{path: "/interface[name=e1-1]/subinterface[index=0]/ipv4/address[ip-prefix=10.0.0.1/24]", exp: "/interface[name=e1-1]/subinterface[index=0]", keys: map[string]string{"name": "e1-1", "index": "0"}},
+		{path: "/interface[name=e1-1]/subinterface[index=0]", exp: "/interface[name=e1-1]/subinterface[index=0]", keys: map[string]string{"name": "e1-1", "index": "0"}},
+		{path: "/interface[name=e1-1]/admin-state", exp: "/interface[name=e1-1]", keys: map[string]string{"name": "e1-1"}},
+		{path: "/network-instance[name=default]", exp: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := d.Resolve(yparser.Xpath2GnmiPath(tt.path, 0))
+			if tt.exp == "" {
+				if rr != nil {
+					t.Errorf("Resolve: got %s, want <nil>", rr.Key())
+				}
+				return
+			}
+			if rr == nil {
+				t.Fatalf("Resolve: got <nil>, want %s", tt.exp)
+			}
+			if rr.Key() != tt.exp {
+				t.Errorf("Resolve: got %s, want %s", rr.Key(), tt.exp)
+			}
+			for k, v := range tt.keys {
+				if rr.Keys()[k] != v {
+					t.Errorf("Resolve: got key %s=%s, want %s", k, rr.Keys()[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	d := New()
+	d.Init(resolverSchema().Resources)
+
+	val := &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "x"}}
+	ns := []*gnmi.Notification{
+		{
+			Timestamp: 1,
+			Prefix:    &gnmi.Path{Target: "dev1", Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1-1"}}}},
+			Update: []*gnmi.Update{
+				{Path: yparser.Xpath2GnmiPath("/admin-state", 0), Val: val},
+				{Path: yparser.Xpath2GnmiPath("/subinterface[index=0]/admin-state", 0), Val: val},
+				{Path: yparser.Xpath2GnmiPath("/subinterface[index=1]/admin-state", 0), Val: val},
+			},
+		},
+		{
+			Timestamp: 2,
+			Prefix:    &gnmi.Path{Target: "dev1"},
+			Update:    []*gnmi.Update{{Path: yparser.Xpath2GnmiPath("/system/name", 0), Val: val}},
+			Delete:    []*gnmi.Path{yparser.Xpath2GnmiPath("/interface[name=e1-1]/subinterface[index=0]/description", 0)},
+		},
+	}
+
+	exp := []struct {
+		key     string
+		updates []int
+		deletes []int
+	}{
+		{key: "/interface[name=e1-1]", updates: []int{1}, deletes: []int{0}},
+		{key: "/interface[name=e1-1]/subinterface[index=0]", updates: []int{1, 0}, deletes: []int{0, 1}},
+		{key: "/interface[name=e1-1]/subinterface[index=1]", updates: []int{1}, deletes: []int{0}},
+	}
+	groups := d.Group(ns)
+	if len(groups) != len(exp) {
+		t.Fatalf("Group: got %d groups, want %d", len(groups), len(exp))
+	}
+	for i, g := range groups {
+		if g.Target != "dev1" || g.Resource.Key() != exp[i].key {
+			t.Errorf("Group: got %s %s, want dev1 %s", g.Target, g.Resource.Key(), exp[i].key)
+		}
+		if len(g.Notifications) != len(exp[i].updates) {
+			t.Errorf("Group %s: got %d notifications, want %d", g.Resource.Key(), len(g.Notifications), len(exp[i].updates))
+			continue
+		}
+		for j, n := range g.Notifications {
+			if len(n.GetUpdate()) != exp[i].updates[j] || len(n.GetDelete()) != exp[i].deletes[j] {
+				t.Errorf("Group %s notification %d: got %d updates %d deletes, want %d %d", g.Resource.Key(), j,
+					len(n.GetUpdate()), len(n.GetDelete()), exp[i].updates[j], exp[i].deletes[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package drift compares the intended config in the config cache with the
+// config observed in the target cache per resource.
+package drift
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pkg/errors"
+	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/dispatcher"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+)
+
+const (
+	// errors
+	errGetIntended = "cannot get the intended config"
+	errGetObserved = "cannot get the observed config"
+	errFlatten     = "cannot get the leafs"
+)
+
+// Detector detects the drift between the config cache and the target cache.
+type Detector interface {
+	// Detect returns a report for every resource of the target which drifted.
+	Detect(target string, prefix *gnmi.Path) ([]*Report, error)
+	// DetectResource returns the report of the resource at path p, leafs
+	// owned by nested resources are not part of the report.
+	DetectResource(target string, prefix, p *gnmi.Path) (*Report, error)
+}
+
+// Option can be used to manipulate Detector config.
+type Option func(*detector)
+
+// WithLogging specifies how the Detector should log messages.
+func WithLogging(log logging.Logger) Option {
+	return func(d *detector) {
+		d.log = log
+	}
+}
+
+type detector struct {
+	log logging.Logger
+	cc  *cache.Cache
+	tc  *cache.Cache
+	rs  *yentry.Entry
+	d   dispatcher.Dispatcher
+}
+
+// New returns a Detector comparing the intended config in cc with the observed
+// config in tc. The resources are the Resources registered in the root schema
+// rs.
+func New(cc, tc *cache.Cache, rs *yentry.Entry, opts ...Option) Detector {
+	d := &detector{
+		log: logging.NewNopLogger(),
+		cc:  cc,
+		tc:  tc,
+		rs:  rs,
+		d:   dispatcher.New(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.d.Init(rs.Resources)
+	return d
+}
+
+// Change is a leaf with a different intended and observed value.
+type Change struct {
+	Path     *gnmi.Path
+	Intended *gnmi.TypedValue
+	Observed *gnmi.TypedValue
+}
+
+// Report is the drift of a resource.
+type Report struct {
+	Target   string
+	Resource *gnmi.Path
+	// Missing are the intended leafs which are not observed
+	Missing []*gnmi.Update
+	// Extra are the observed leafs which are not intended
+	Extra []*gnmi.Path
+	// Changed are the leafs with a different intended and observed value
+	Changed []*Change
+	// Reordered are the leaf-lists ordered by user whose values are observed
+	// in another order, the update holds the intended values
+	Reordered []*gnmi.Update
+	// intended is true if any leaf of the resource is intended
+	intended bool
+	// deletes are the extra leafs collapsed into the outermost list entries
+	// and containers which are not intended at all
+	deletes []*gnmi.Path
+}
+
+// HasDrift returns true if the intended and observed config differ.
+func (r *Report) HasDrift() bool {
+	return len(r.Missing) != 0 || len(r.Extra) != 0 || len(r.Changed) != 0 || len(r.Reordered) != 0
+}
+
+// Repair returns the deletes and updates of a gNMI Set which brings the
+// observed config back to the intended config. A resource which is not
+// intended at all is deleted as a whole. The reordered leaf-lists are set as a
+// whole, a setrequest Builder with the schema replaces them.
+func (r *Report) Repair() ([]*gnmi.Path, []*gnmi.Update) {
+	if !r.intended && len(r.Extra) != 0 {
+		return []*gnmi.Path{r.Resource}, nil
+	}
+	updates := make([]*gnmi.Update, 0, len(r.Missing)+len(r.Changed)+len(r.Reordered))
+	updates = append(updates, r.Reordered...)
+	updates = append(updates, r.Missing...)
+	for _, c := range r.Changed {
+		updates = append(updates, &gnmi.Update{Path: c.Path, Val: c.Intended})
+	}
+	return r.deletes, updates
+}
+
+func (d *detector) Detect(target string, prefix *gnmi.Path) ([]*Report, error) {
+	intended, xi, err := d.leafs(d.cc, target, prefix, &gnmi.Path{})
+	if err != nil {
+		return nil, errors.Wrap(err, errGetIntended)
+	}
+	observed, xo, err := d.leafs(d.tc, target, prefix, &gnmi.Path{})
+	if err != nil {
+		return nil, errors.Wrap(err, errGetObserved)
+	}
+	reorders, err := d.reorders(&gnmi.Path{}, xi, xo)
+	if err != nil {
+		return nil, err
+	}
+	ri := d.group(intended)
+	ro := d.group(observed)
+
+	reports := make([]*Report, 0)
+	for _, k := range sortedKeys(ri, ro) {
+		rsc := ri[k].resource
+		if rsc == nil {
+			rsc = ro[k].resource
+		}
+		r := d.compare(target, rsc, ri[k].leafs, ro[k].leafs, reorders[k])
+		if r.HasDrift() {
+			reports = append(reports, r)
+		}
+	}
+	return reports, nil
+}
+
+func (d *detector) DetectResource(target string, prefix, p *gnmi.Path) (*Report, error) {
+	intended, xi, err := d.leafs(d.cc, target, prefix, p)
+	if err != nil {
+		return nil, errors.Wrap(err, errGetIntended)
+	}
+	observed, xo, err := d.leafs(d.tc, target, prefix, p)
+	if err != nil {
+		return nil, errors.Wrap(err, errGetObserved)
+	}
+	reorders, err := d.reorders(p, xi, xo)
+	if err != nil {
+		return nil, err
+	}
+	key := yparser.GnmiPath2XPath(p, true)
+	if rr := d.d.Resolve(p); rr != nil {
+		key = rr.Key()
+	}
+	return d.compare(target, p, d.group(intended)[key].leafs, d.group(observed)[key].leafs, reorders[key]), nil
+}
+
+// leafs returns the leafs of the subtree p in c indexed by xpath and the data
+// of the subtree, leafs with the schema default value are equal to absent
+// leafs and are left out.
+func (d *detector) leafs(c *cache.Cache, target string, prefix, p *gnmi.Path) (map[string]*gnmi.Update, interface{}, error) {
+	x, err := c.GetJson(target, prefix, p, d.rs, yparser.WithNormalizedValues(), yparser.WithoutDefaults())
+	if err != nil {
+		return nil, nil, err
+	}
+	leafs := make(map[string]*gnmi.Update)
+	if x == nil {
+		return leafs, nil, nil
+	}
+	upds, err := yparser.GetGranularUpdatesFromJSON(p, x, d.rs)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, errFlatten)
+	}
+	for _, u := range upds {
+		leafs[yparser.GnmiPath2XPath(u.GetPath(), true)] = u
+	}
+	return leafs, x, nil
+}
+
+// reorders returns the reorder updates of the intended data xi and the
+// observed data xo of path p per owning resource. The caches do not keep the
+// order of list entries, only the leaf-lists are reordered.
+func (d *detector) reorders(p *gnmi.Path, xi, xo interface{}) (map[string][]*gnmi.Update, error) {
+	upds, err := yparser.GetReorderUpdates(d.rs, p, xi, xo)
+	if err != nil {
+		return nil, err
+	}
+	reorders := make(map[string][]*gnmi.Update)
+	for _, u := range upds {
+		if !isLeafList(u.GetVal()) {
+			continue
+		}
+		if rr := d.d.Resolve(u.GetPath()); rr != nil {
+			reorders[rr.Key()] = append(reorders[rr.Key()], u)
+		}
+	}
+	return reorders, nil
+}
+
+// isLeafList returns true if the value holds leaf-list values and not list
+// entries.
+func isLeafList(v *gnmi.TypedValue) bool {
+	x, err := yparser.GetValue(v)
+	if err != nil {
+		return false
+	}
+	l, ok := x.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, lv := range l {
+		if _, ok := lv.(map[string]interface{}); ok {
+			return false
+		}
+	}
+	return true
+}
+
+type resourceLeafs struct {
+	resource *gnmi.Path
+	leafs    map[string]*gnmi.Update
+}
+
+// group groups the leafs per owning resource, leafs which are not owned by a
+// resource are ignored.
+func (d *detector) group(leafs map[string]*gnmi.Update) map[string]resourceLeafs {
+	g := make(map[string]resourceLeafs)
+	for k, u := range leafs {
+		rr := d.d.Resolve(u.GetPath())
+		if rr == nil {
+			continue
+		}
+		rl, ok := g[rr.Key()]
+		if !ok {
+			rl = resourceLeafs{
+				resource: &gnmi.Path{Elem: rr.PathElem},
+				leafs:    make(map[string]*gnmi.Update),
+			}
+			g[rr.Key()] = rl
+		}
+		rl.leafs[k] = u
+	}
+	return g
+}
+
+func (d *detector) compare(target string, rsc *gnmi.Path, intended, observed map[string]*gnmi.Update, reorders []*gnmi.Update) *Report {
+	r := &Report{
+		Target:    target,
+		Resource:  rsc,
+		Reordered: reorders,
+		intended:  len(intended) != 0,
+	}
+	// the xpaths of the intended leafs and all their ancestors
+	present := make(map[string]bool)
+	for _, u := range intended {
+		pe := u.GetPath().GetElem()
+		for i := 1; i <= len(pe); i++ {
+			present[yparser.GnmiPath2XPath(&gnmi.Path{Elem: pe[:i]}, true)] = true
+		}
+	}
+	deleted := make(map[string]bool)
+	reordered := make(map[string]bool, len(reorders))
+	for _, u := range reorders {
+		reordered[yparser.GnmiPath2XPath(u.GetPath(), true)] = true
+	}
+	for _, k := range sortedKeys(intended, observed) {
+		iu, iok := intended[k]
+		ou, ook := observed[k]
+		switch {
+		case iok && ook:
+			// a reordered leaf-list is not a changed leaf
+			if !reordered[k] && !equal(iu.GetVal(), ou.GetVal()) {
+				r.Changed = append(r.Changed, &Change{Path: iu.GetPath(), Intended: iu.GetVal(), Observed: ou.GetVal()})
+			}
+		case iok:
+			r.Missing = append(r.Missing, iu)
+		case ook:
+			r.Extra = append(r.Extra, ou.GetPath())
+			if p := extraDelete(ou.GetPath(), present); p != nil {
+				k := yparser.GnmiPath2XPath(p, true)
+				if !deleted[k] {
+					deleted[k] = true
+					r.deletes = append(r.deletes, p)
+				}
+			}
+		}
+	}
+	d.log.Debug("drift", "target", target, "resource", yparser.GnmiPath2XPath(rsc, true),
+		"missing", len(r.Missing), "extra", len(r.Extra), "changed", len(r.Changed), "reordered", len(r.Reordered))
+	return r
+}
+
+// extraDelete returns the path to delete for the extra leaf p, which is the
+// outermost list entry or container of p without any intended leaf. A key leaf
+// cannot be deleted on its own, nil is returned for it.
+func extraDelete(p *gnmi.Path, present map[string]bool) *gnmi.Path {
+	pe := p.GetElem()
+	for i := 1; i <= len(pe); i++ {
+		if !present[yparser.GnmiPath2XPath(&gnmi.Path{Elem: pe[:i]}, true)] {
+			if i == len(pe) && i > 1 {
+				if _, ok := pe[i-2].GetKey()[pe[i-1].GetName()]; ok {
+					return nil
+				}
+			}
+			return &gnmi.Path{Elem: pe[:i]}
+		}
+	}
+	return nil
+}
+
+func equal(a, b *gnmi.TypedValue) bool {
+	av, aerr := yparser.GetValue(a)
+	bv, berr := yparser.GetValue(b)
+	if aerr != nil || berr != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return reflect.DeepEqual(av, bv) || fmt.Sprint(av) == fmt.Sprint(bv)
+}
+
+func sortedKeys(ms ...interface{}) []string {
+	keys := make(map[string]struct{})
+	for _, m := range ms {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			keys[k.String()] = struct{}{}
+		}
+	}
+	s := make([]string, 0, len(keys))
+	for k := range keys {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,224 @@
+package drift
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+)
+
+func testSchema() *yentry.Entry {
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs, ResourceBoundary: true,
+		Defaults: map[string]string{"admin-state": "enable"}}
+	subitfce := &yentry.Entry{Name: "subinterface", Key: []string{"index"}, Parent: itfce, ResourceBoundary: true}
+	rs.Children = map[string]*yentry.Entry{"interface": itfce}
+	itfce.Children = map[string]*yentry.Entry{"subinterface": subitfce}
+	itfce.Register(&gnmi.Path{})
+	subitfce.Register(&gnmi.Path{})
+	return rs
+}
+
+func update(t *testing.T, c *cache.Cache, target string, leafs map[string]string) {
+	n := &gnmi.Notification{Prefix: &gnmi.Path{Target: target}}
+	for p, v := range leafs {
+		n.Update = append(n.Update, &gnmi.Update{
+			Path: yparser.Xpath2GnmiPath(p, 0),
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}},
+		})
+	}
+	if err := c.GnmiUpdate(target, n); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+}
+
+func xpaths(ps []*gnmi.Path) []string {
+	s := make([]string, 0, len(ps))
+	for _, p := range ps {
+		s = append(s, yparser.GnmiPath2XPath(p, true))
+	}
+	return s
+}
+
+func TestDetect(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := testSchema()
+	cc := cache.New([]string{target})
+	tc := cache.New([]string{target})
+
+	update(t, cc, target, map[string]string{
+		"/interface[name=e1]/description":                   "uplink",
+		"/interface[name=e1]/mtu":                           "9000",
+		"/interface[name=e1]/subinterface[index=0]/vlan-id": "10",
+		"/interface[name=e2]/description":                   "intended",
+	})
+	update(t, tc, target, map[string]string{
+		"/interface[name=e1]/description": "downlink",
+		// the default value is not a drift
+		"/interface[name=e1]/admin-state":                   "enable",
+		"/interface[name=e1]/subinterface[index=0]/vlan-id": "10",
+		"/interface[name=e2]/description":                   "intended",
+		"/interface[name=e3]/description":                   "unmanaged",
+	})
+
+	reports, err := New(cc, tc, rs).Detect(target, prefix)
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	exp := []string{"/interface[name=e1]", "/interface[name=e3]"}
+	if len(reports) != len(exp) {
+		t.Fatalf("Detect: got %d reports, want %d", len(reports), len(exp))
+	}
+	for i, r := range reports {
+		if got := yparser.GnmiPath2XPath(r.Resource, true); got != exp[i] {
+			t.Errorf("Detect: got resource %s, want %s", got, exp[i])
+		}
+	}
+
+	e1 := reports[0]
+	if len(e1.Missing) != 1 || yparser.GnmiPath2XPath(e1.Missing[0].GetPath(), true) != "/interface[name=e1]/mtu" {
+		t.Errorf("missing: got %v", e1.Missing)
+	}
+	if len(e1.Changed) != 1 || yparser.GnmiPath2XPath(e1.Changed[0].Path, true) != "/interface[name=e1]/description" {
+		t.Errorf("changed: got %v", e1.Changed)
+	}
+	if len(e1.Extra) != 0 {
+		t.Errorf("extra: got %v", xpaths(e1.Extra))
+	}
+	deletes, updates := e1.Repair()
+	if len(deletes) != 0 || len(updates) != 2 {
+		t.Errorf("Repair: got %d deletes %d updates, want 0 2", len(deletes), len(updates))
+	}
+
+	deletes, updates = reports[1].Repair()
+	if got := xpaths(deletes); len(got) != 1 || got[0] != "/interface[name=e3]" || len(updates) != 0 {
+		t.Errorf("Repair: got deletes %v updates %v, want [/interface[name=e3]] []", got, updates)
+	}
+}
+
+func TestDetectResource(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := testSchema()
+	cc := cache.New([]string{target})
+	tc := cache.New([]string{target})
+
+	update(t, cc, target, map[string]string{
+		"/interface[name=e1]/description":                   "uplink",
+		"/interface[name=e1]/subinterface[index=0]/vlan-id": "10",
+	})
+	update(t, tc, target, map[string]string{
+		"/interface[name=e1]/description":                   "uplink",
+		"/interface[name=e1]/subinterface[index=0]/vlan-id": "20",
+	})
+
+	d := New(cc, tc, rs)
+	// the subinterface is a nested resource
+	r, err := d.DetectResource(target, prefix, yparser.Xpath2GnmiPath("/interface[name=e1]", 0))
+	if err != nil {
+		t.Fatalf("DetectResource: %v", err)
+	}
+	if r.HasDrift() {
+		t.Errorf("DetectResource: unexpected drift %v %v %v", r.Missing, xpaths(r.Extra), r.Changed)
+	}
+	r, err = d.DetectResource(target, prefix, yparser.Xpath2GnmiPath("/interface[name=e1]/subinterface[index=0]", 0))
+	if err != nil {
+		t.Fatalf("DetectResource: %v", err)
+	}
+	if len(r.Changed) != 1 {
+		t.Errorf("DetectResource: got %d changes, want 1", len(r.Changed))
+	}
+}
+
+func TestDetectReordered(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := testSchema()
+	rs.Children["interface"].OrderedByUser = map[string]bool{"dns": true, "tags": false}
+	cc := cache.New([]string{target})
+	tc := cache.New([]string{target})
+
+	leafList := func(c *cache.Cache, dns, tags string) {
+		n := &gnmi.Notification{Prefix: &gnmi.Path{Target: target}, Update: []*gnmi.Update{
+			{
+				Path: yparser.Xpath2GnmiPath("/interface[name=e1]/dns", 0),
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(dns)}},
+			},
+			{
+				Path: yparser.Xpath2GnmiPath("/interface[name=e1]/tags", 0),
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(tags)}},
+			},
+		}}
+		if err := c.GnmiUpdate(target, n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+	leafList(cc, `["10.0.0.2","10.0.0.1"]`, `["a","b"]`)
+	leafList(tc, `["10.0.0.1","10.0.0.2"]`, `["a","b"]`)
+
+	r, err := New(cc, tc, rs).DetectResource(target, prefix, yparser.Xpath2GnmiPath("/interface[name=e1]", 0))
+	if err != nil {
+		t.Fatalf("DetectResource: %v", err)
+	}
+	if len(r.Reordered) != 1 || yparser.GnmiPath2XPath(r.Reordered[0].GetPath(), true) != "/interface[name=e1]/dns" {
+		t.Fatalf("reordered: got %v", r.Reordered)
+	}
+	// the reordered leaf-list is not reported as changed
+	if len(r.Changed) != 0 {
+		t.Errorf("changed: got %v", r.Changed)
+	}
+	if _, updates := r.Repair(); len(updates) != 1 || string(updates[0].GetVal().GetJsonIetfVal()) != `["10.0.0.2","10.0.0.1"]` {
+		t.Errorf("Repair: got %v, want the intended dns servers", updates)
+	}
+}
+
+func TestRepairExtraListEntry(t *testing.T) {
+	target := "dev1"
+	prefix := &gnmi.Path{Target: target}
+	rs := testSchema()
+	itfce := rs.Children["interface"]
+	address := &yentry.Entry{Name: "address", Key: []string{"ip"}, Parent: itfce}
+	itfce.Children["address"] = address
+	cc := cache.New([]string{target})
+	tc := cache.New([]string{target})
+
+	update(t, cc, target, map[string]string{
+		"/interface[name=e1]/description":                 "uplink",
+		"/interface[name=e1]/address[ip=1.1.1.1]/ip":      "1.1.1.1",
+		"/interface[name=e1]/address[ip=1.1.1.1]/primary": "true",
+	})
+	update(t, tc, target, map[string]string{
+		"/interface[name=e1]/description":                 "uplink",
+		"/interface[name=e1]/address[ip=1.1.1.1]/ip":      "1.1.1.1",
+		"/interface[name=e1]/address[ip=1.1.1.1]/primary": "true",
+		"/interface[name=e1]/address[ip=1.1.1.1]/label":   "extra",
+		"/interface[name=e1]/address[ip=2.2.2.2]/ip":      "2.2.2.2",
+		"/interface[name=e1]/address[ip=2.2.2.2]/primary": "false",
+		"/interface[name=e1]/address[ip=2.2.2.2]/label":   "extra",
+	})
+
+	r, err := New(cc, tc, rs).DetectResource(target, prefix, yparser.Xpath2GnmiPath("/interface[name=e1]", 0))
+	if err != nil {
+		t.Fatalf("DetectResource: %v", err)
+	}
+	if len(r.Extra) != 4 {
+		t.Errorf("extra: got %v, want 4 leafs", xpaths(r.Extra))
+	}
+	deletes, updates := r.Repair()
+	exp := []string{
+		"/interface[name=e1]/address[ip=1.1.1.1]/label",
+		"/interface[name=e1]/address[ip=2.2.2.2]",
+	}
+	got := xpaths(deletes)
+	if len(got) != len(exp) || len(updates) != 0 {
+		t.Fatalf("Repair: got deletes %v updates %v, want %v []", got, updates, exp)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("Repair: got delete %s, want %s", got[i], exp[i])
+		}
+	}
+}
@@ -158,3 +158,29 @@ func (t *Tree) getlpm(path []string) *Branch {
 	// not found
 	return nil
 }
+
+// GetLpmValue returns the value of the longest prefix of path which holds a
+// value, nil if no prefix holds one. Specific entries are preferred over
+// wildcard (*) entries, the wildcard entry is tried when the specific entry
+// does not lead to a value.
+func (t *Tree) GetLpmValue(path []string) interface{} {
+	if t == nil || len(path) == 0 {
+		return nil
+	}
+	defer t.mu.RUnlock()
+	t.mu.RLock()
+
+	for _, k := range []string{path[0], "*"} {
+		b := t.branch[k]
+		if b == nil {
+			continue
+		}
+		if v := b.t.GetLpmValue(path[1:]); v != nil {
+			return v
+		}
+		if v := b.Value(); v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -147,3 +147,37 @@ func TestTreeGetLpm(t *testing.T) {
 	}
 	printTree(tr, 0)
 }
+
+func TestTreeGetLpmValue(t *testing.T) {
+	tr := &Tree{}
+	for _, tt := range []struct {
+		path  []string
+		value string
+	}{
+		{[]string{"a", "*"}, "a*"},
+		{[]string{"a", "*", "b", "*", "c"}, "a*b*c"},
+		{[]string{"a", "x", "d"}, "axd"},
+	} {
+		if err := tr.Add(tt.path, tt.value); err != nil {
+			t.Error(err)
+		}
+	}
+	for x, tt := range []struct {
+		path  []string
+		value interface{}
+	}{
+		{[]string{"z"}, nil},
+		{[]string{"a"}, nil},
+		{[]string{"a", "y"}, "a*"},
+		// b is an intermediate branch without a value
+		{[]string{"a", "y", "b", "1", "d"}, "a*"},
+		{[]string{"a", "y", "b", "1", "c", "e"}, "a*b*c"},
+		// the specific entry x does not hold a value for b, fall back to *
+		{[]string{"a", "x", "b", "1", "c"}, "a*b*c"},
+		{[]string{"a", "x", "d", "e"}, "axd"},
+	} {
+		if value := tr.GetLpmValue(tt.path); tt.value != value {
+			t.Errorf("#%d: got %v, expected %v", x, value, tt.value)
+		}
+	}
+}
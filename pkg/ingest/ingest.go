@@ -0,0 +1,174 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ingest feeds the notifications of a gNMI subscription into a cache
+// target.
+package ingest
+
+import (
+	"context"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pkg/errors"
+	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/cache"
+)
+
+const (
+	// DefaultMinBackoff is the default delay before the first retry
+	DefaultMinBackoff = 1 * time.Second
+	// DefaultMaxBackoff is the default max delay between retries
+	DefaultMaxBackoff = 1 * time.Minute
+
+	// errors
+	errSubscribe     = "cannot subscribe"
+	errSendRequest   = "cannot send the subscribe request"
+	errReceive       = "cannot receive the subscribe response"
+	errUpdate        = "cannot update the cache"
+	errSubscribeResp = "subscribe error response"
+)
+
+// Ingester feeds a gNMI subscription into a cache target.
+type Ingester interface {
+	// Run subscribes and feeds the notifications into the cache until the
+	// context is cancelled. A broken subscription clears the connected and
+	// sync state of the target, is recorded as the connect error of the
+	// target and retried with backoff, the target is reset when the
+	// subscription is re-established.
+	Run(ctx context.Context) error
+}
+
+// Option can be used to manipulate Ingester config.
+type Option func(*ingester)
+
+// WithLogging specifies how the Ingester should log messages.
+func WithLogging(log logging.Logger) Option {
+	return func(i *ingester) {
+		i.log = log
+	}
+}
+
+// WithBackoff specifies the delay before the first retry, the delay doubles
+// with every retry up to max. The delay is reset once a subscription
+// delivered a response.
+func WithBackoff(min, max time.Duration) Option {
+	return func(i *ingester) {
+		i.minBackoff = min
+		i.maxBackoff = max
+	}
+}
+
+type ingester struct {
+	log        logging.Logger
+	c          *cache.Cache
+	target     string
+	client     gnmi.GNMIClient
+	req        *gnmi.SubscribeRequest
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// New returns an Ingester feeding the responses of the subscribe request req
+// on client into the target of the cache c.
+func New(c *cache.Cache, target string, client gnmi.GNMIClient, req *gnmi.SubscribeRequest, opts ...Option) Ingester {
+	i := &ingester{
+		log:        logging.NewNopLogger(),
+		c:          c,
+		target:     target,
+		client:     client,
+		req:        req,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+func (i *ingester) Run(ctx context.Context) error {
+	if i.c.GetCache().GetTarget(i.target) == nil {
+		i.c.GetCache().Add(i.target)
+	}
+	backoff := i.minBackoff
+	connected := false
+	for {
+		received, err := i.subscribe(ctx, connected)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			connected = true
+			backoff = i.minBackoff
+		}
+		i.log.Debug("subscription broken", "target", i.target, "error", err, "retry", backoff)
+		i.c.GetCache().Disconnect(i.target)
+		i.c.GetCache().ConnectError(i.target, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > i.maxBackoff {
+			backoff = i.maxBackoff
+		}
+	}
+}
+
+// subscribe feeds the responses of a single subscription into the cache until
+// the subscription breaks, it returns true if a response was received. The
+// target is reset when the first response of a reconnect is received, the
+// data of the previous subscription is stale.
+func (i *ingester) subscribe(ctx context.Context, reconnect bool) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sc, err := i.client.Subscribe(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, errSubscribe)
+	}
+	if err := sc.Send(i.req); err != nil {
+		return false, errors.Wrap(err, errSendRequest)
+	}
+	received := false
+	for {
+		rsp, err := sc.Recv()
+		if err != nil {
+			return received, errors.Wrap(err, errReceive)
+		}
+		if !received {
+			received = true
+			if reconnect {
+				i.c.GetCache().Reset(i.target)
+			}
+			i.c.GetCache().Connect(i.target)
+		}
+		switch r := rsp.GetResponse().(type) {
+		case *gnmi.SubscribeResponse_Update:
+			// a notification the cache rejects does not break the subscription
+			if err := i.c.GnmiUpdate(i.target, r.Update); err != nil {
+				i.log.Debug(errUpdate, "target", i.target, "error", err)
+			}
+		case *gnmi.SubscribeResponse_SyncResponse:
+			if r.SyncResponse {
+				i.c.GetCache().Sync(i.target)
+			}
+		case *gnmi.SubscribeResponse_Error:
+			return received, errors.Errorf("%s: %s", errSubscribeResp, r.Error.GetMessage())
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeServer answers every subscription with the next script, a script that
+// ends closes the stream and the last script blocks until the client leaves.
+type fakeServer struct {
+	gnmi.UnimplementedGNMIServer
+	mu      sync.Mutex
+	scripts [][]*gnmi.SubscribeResponse
+	calls   int
+}
+
+func (s *fakeServer) Subscribe(stream gnmi.GNMI_SubscribeServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	call := s.calls
+	s.calls++
+	s.mu.Unlock()
+	if call >= len(s.scripts) {
+		<-stream.Context().Done()
+		return nil
+	}
+	for _, rsp := range s.scripts[call] {
+		if err := stream.Send(rsp); err != nil {
+			return err
+		}
+	}
+	if call == len(s.scripts)-1 {
+		<-stream.Context().Done()
+		return nil
+	}
+	return errors.New("connection lost")
+}
+
+func update(xpath, v string) *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix:    &gnmi.Path{Target: "dev1"},
+		Update: []*gnmi.Update{{
+			Path: yparser.Xpath2GnmiPath(xpath, 0),
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}},
+		}},
+	}}}
+}
+
+var syncResponse = &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}}
+
+func dial(t *testing.T, srv gnmi.GNMIServer) gnmi.GNMIClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	gnmi.RegisterGNMIServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return gnmi.NewGNMIClient(conn)
+}
+
+func value(c *cache.Cache, p []string) interface{} {
+	var v interface{}
+	c.GetCache().Query("dev1", p, func(_ []string, _ *octree.Leaf, n interface{}) error {
+		if n, ok := n.(*gnmi.Notification); ok && len(n.GetUpdate()) != 0 {
+			v, _ = yparser.GetValue(n.GetUpdate()[0].GetVal())
+		}
+		return nil
+	})
+	return v
+}
+
+func eventually(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", desc)
+}
+
+func TestRun(t *testing.T) {
+	srv := &fakeServer{scripts: [][]*gnmi.SubscribeResponse{
+		{update("/system/name", "old"), update("/system/location", "lab"), syncResponse},
+		{update("/system/name", "new"), syncResponse},
+	}}
+	c := cache.New([]string{"dev1"})
+	req := &gnmi.SubscribeRequest{Request: &gnmi.SubscribeRequest_Subscribe{Subscribe: &gnmi.SubscriptionList{
+		Prefix: &gnmi.Path{Target: "dev1"},
+		Mode:   gnmi.SubscriptionList_STREAM,
+	}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- New(c, "dev1", dial(t, srv), req, WithBackoff(10*time.Millisecond, 20*time.Millisecond)).Run(ctx)
+	}()
+
+	eventually(t, "the second subscription", func() bool {
+		return value(c, []string{"system", "name"}) == "new" && value(c, metadata.Path(metadata.Sync)) == true
+	})
+	// the data of the first subscription is reset on reconnect
+	if v := value(c, []string{"system", "location"}); v != nil {
+		t.Errorf("location: got %v, want reset", v)
+	}
+	if v := value(c, metadata.Path(metadata.Connected)); v != true {
+		t.Errorf("connected: got %v, want true", v)
+	}
+	if v := value(c, metadata.Path(metadata.ConnectError)); v != nil {
+		t.Errorf("connect error: got %v, want cleared", v)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run: got %v, want context canceled", err)
+	}
+}
+
+func TestRunConnectError(t *testing.T) {
+	srv := &fakeServer{scripts: [][]*gnmi.SubscribeResponse{
+		{update("/system/name", "dev1"), syncResponse},
+		{},
+	}}
+	c := cache.New([]string{"dev1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go New(c, "dev1", dial(t, srv), &gnmi.SubscribeRequest{}, WithBackoff(time.Hour, time.Hour)).Run(ctx)
+
+	// the retry is pending so the connect error of the broken subscription stays
+	eventually(t, "the connect error", func() bool {
+		v, ok := value(c, metadata.Path(metadata.ConnectError)).(string)
+		return ok && v != ""
+	})
+	if v := value(c, []string{"system", "name"}); v != "dev1" {
+		t.Errorf("name: got %v, want dev1", v)
+	}
+	// the broken subscription is no longer connected nor in sync
+	if v := value(c, metadata.Path(metadata.Connected)); v != false {
+		t.Errorf("connected: got %v, want false", v)
+	}
+	if v := value(c, metadata.Path(metadata.Sync)); v != false {
+		t.Errorf("sync: got %v, want false", v)
+	}
+}
@@ -0,0 +1,490 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jtree implements an editor for JSON trees addressed by gnmi paths.
+// Containers are represented as map[string]interface{}, lists as []interface{}
+// of map[string]interface{} entries which hold their key leaves.
+package jtree
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pkg/errors"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+const (
+	// errors
+	errNotContainer = "path element %s is not a container"
+	errNotList      = "path element %s is not a list"
+	errEmptyPath    = "empty path"
+)
+
+// WalkFunc is called by Walk for every leaf and leaf-list with its path.
+type WalkFunc func(p *gnmi.Path, v interface{}) error
+
+// Tree is an editor for a JSON tree.
+type Tree interface {
+	// Data returns the JSON tree.
+	Data() interface{}
+	// Get returns the value at path p and whether it was found.
+	Get(p *gnmi.Path) (interface{}, bool, error)
+	// Merge merges v with the value at path p, creating the path if needed.
+	// Containers are merged recursively, list entries are merged by key.
+	Merge(p *gnmi.Path, v interface{}) error
+	// Replace replaces the value at path p with v, creating the path if needed.
+	Replace(p *gnmi.Path, v interface{}) error
+	// Delete deletes the value at path p and returns whether it was found.
+	// Parent containers and lists which become empty are deleted as well.
+	Delete(p *gnmi.Path) (bool, error)
+	// Walk calls fn for every leaf and leaf-list at or below path p, in
+	// order of name and key.
+	Walk(p *gnmi.Path, fn WalkFunc) error
+}
+
+// Option can be used to manipulate Tree config.
+type Option func(*tree)
+
+// WithSchema initializes the schema used to determine the lists and their
+// keys. Without schema lists are derived from the keys in the paths.
+func WithSchema(rs *yentry.Entry) Option {
+	return func(t *tree) {
+		t.schema = rs
+	}
+}
+
+type tree struct {
+	data   map[string]interface{}
+	schema *yentry.Entry
+}
+
+// New returns a Tree editing d, a new tree is started when d is nil.
+func New(d map[string]interface{}, opts ...Option) Tree {
+	if d == nil {
+		d = map[string]interface{}{}
+	}
+	t := &tree{
+		data: d,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *tree) Data() interface{} {
+	return t.data
+}
+
+func (t *tree) Get(p *gnmi.Path) (interface{}, bool, error) {
+	var x interface{} = t.data
+	for _, pe := range p.GetElem() {
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return nil, false, errors.Errorf(errNotContainer, pe.GetName())
+		}
+		x, ok = m[pe.GetName()]
+		if !ok {
+			return nil, false, nil
+		}
+		if len(pe.GetKey()) == 0 {
+			continue
+		}
+		l, ok := x.([]interface{})
+		if !ok {
+			return nil, false, errors.Errorf(errNotList, pe.GetName())
+		}
+		entry, _ := findEntry(l, pe.GetKey())
+		if entry == nil {
+			return nil, false, nil
+		}
+		x = entry
+	}
+	return x, true, nil
+}
+
+func (t *tree) Merge(p *gnmi.Path, v interface{}) error {
+	return t.set(p, deepCopy(v), true)
+}
+
+func (t *tree) Replace(p *gnmi.Path, v interface{}) error {
+	return t.set(p, deepCopy(v), false)
+}
+
+// set writes v at path p, the intermediate containers and list entries are
+// created as needed.
+func (t *tree) set(p *gnmi.Path, v interface{}, merge bool) error {
+	pes := p.GetElem()
+	if len(pes) == 0 {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.New(errEmptyPath)
+		}
+		if !merge {
+			for k := range t.data {
+				delete(t.data, k)
+			}
+		}
+		mergeContainer(t.data, m, t.schema)
+		return nil
+	}
+	m := t.data
+	e := t.schema
+	for i, pe := range pes {
+		e = child(e, pe.GetName())
+		last := i == len(pes)-1
+		if len(pe.GetKey()) == 0 {
+			if last {
+				if merge {
+					m[pe.GetName()] = mergeValue(m[pe.GetName()], v, e)
+				} else {
+					m[pe.GetName()] = v
+				}
+				return nil
+			}
+			x, ok := m[pe.GetName()]
+			if !ok {
+				x = map[string]interface{}{}
+				m[pe.GetName()] = x
+			}
+			if m, ok = x.(map[string]interface{}); !ok {
+				return errors.Errorf(errNotContainer, pe.GetName())
+			}
+			continue
+		}
+		var l []interface{}
+		if x, ok := m[pe.GetName()]; ok {
+			if l, ok = x.([]interface{}); !ok {
+				return errors.Errorf(errNotList, pe.GetName())
+			}
+		}
+		entry, idx := findEntry(l, pe.GetKey())
+		if last {
+			nv, ok := v.(map[string]interface{})
+			if !ok {
+				return errors.Errorf(errNotContainer, pe.GetName())
+			}
+			if merge && entry != nil {
+				mergeContainer(entry, nv, e)
+				return nil
+			}
+			entry = nv
+			for k, kv := range pe.GetKey() {
+				entry[k] = keyValue(e, k, kv)
+			}
+			if idx < 0 {
+				l = append(l, entry)
+			} else {
+				l[idx] = entry
+			}
+			m[pe.GetName()] = l
+			return nil
+		}
+		if entry == nil {
+			entry = map[string]interface{}{}
+			for k, kv := range pe.GetKey() {
+				entry[k] = keyValue(e, k, kv)
+			}
+			m[pe.GetName()] = append(l, entry)
+		}
+		m = entry
+	}
+	return nil
+}
+
+func (t *tree) Delete(p *gnmi.Path) (bool, error) {
+	pes := p.GetElem()
+	if len(pes) == 0 {
+		found := len(t.data) != 0
+		for k := range t.data {
+			delete(t.data, k)
+		}
+		return found, nil
+	}
+	return deletePath(t.data, pes)
+}
+
+// deletePath deletes the value at pes from m and removes the containers and
+// lists on the path which become empty. List entries are only removed when
+// they are addressed by the path.
+func deletePath(m map[string]interface{}, pes []*gnmi.PathElem) (bool, error) {
+	pe := pes[0]
+	x, ok := m[pe.GetName()]
+	if !ok {
+		return false, nil
+	}
+	if len(pe.GetKey()) == 0 {
+		if len(pes) == 1 {
+			delete(m, pe.GetName())
+			return true, nil
+		}
+		cm, ok := x.(map[string]interface{})
+		if !ok {
+			return false, errors.Errorf(errNotContainer, pe.GetName())
+		}
+		found, err := deletePath(cm, pes[1:])
+		if err != nil {
+			return false, err
+		}
+		if len(cm) == 0 {
+			delete(m, pe.GetName())
+		}
+		return found, nil
+	}
+	l, ok := x.([]interface{})
+	if !ok {
+		return false, errors.Errorf(errNotList, pe.GetName())
+	}
+	entry, idx := findEntry(l, pe.GetKey())
+	if entry == nil {
+		return false, nil
+	}
+	if len(pes) > 1 {
+		return deletePath(entry, pes[1:])
+	}
+	l = append(l[:idx], l[idx+1:]...)
+	if len(l) == 0 {
+		delete(m, pe.GetName())
+	} else {
+		m[pe.GetName()] = l
+	}
+	return true, nil
+}
+
+func (t *tree) Walk(p *gnmi.Path, fn WalkFunc) error {
+	x, found, err := t.Get(p)
+	if err != nil || !found {
+		return err
+	}
+	e := t.schema
+	for _, pe := range p.GetElem() {
+		e = child(e, pe.GetName())
+	}
+	elems := make([]*gnmi.PathElem, len(p.GetElem()))
+	copy(elems, p.GetElem())
+	// a path to a list without keys walks all entries of the list
+	if l, ok := x.([]interface{}); ok && len(elems) != 0 && len(elems[len(elems)-1].GetKey()) == 0 && isList(l, e) {
+		return walkList(elems[:len(elems)-1], elems[len(elems)-1].GetName(), l, e, fn)
+	}
+	return walk(elems, x, e, fn)
+}
+
+func walk(elems []*gnmi.PathElem, x interface{}, e *yentry.Entry, fn WalkFunc) error {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(x))
+		for n := range x {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			ce := child(e, n)
+			if l, ok := x[n].([]interface{}); ok && isList(l, ce) {
+				if err := walkList(elems, n, l, ce, fn); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := walk(appendElem(elems, &gnmi.PathElem{Name: n}), x[n], ce, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fn(&gnmi.Path{Elem: elems}, x)
+	}
+}
+
+func walkList(elems []*gnmi.PathElem, name string, l []interface{}, e *yentry.Entry, fn WalkFunc) error {
+	keyNames := e.GetKey()
+	type keyed struct {
+		key   map[string]string
+		id    string
+		entry map[string]interface{}
+	}
+	entries := make([]keyed, 0, len(l))
+	for _, x := range l {
+		entry := x.(map[string]interface{})
+		names := keyNames
+		if len(names) == 0 {
+			// without schema all leaves of the entry are considered keys
+			for n, v := range entry {
+				if _, ok := v.(map[string]interface{}); !ok {
+					names = append(names, n)
+				}
+			}
+			sort.Strings(names)
+		}
+		k := make(map[string]string, len(names))
+		var id string
+		for _, n := range names {
+			k[n] = keyString(entry[n])
+			id += k[n] + "\x00"
+		}
+		entries = append(entries, keyed{key: k, id: id, entry: entry})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+	for _, ke := range entries {
+		if err := walk(appendElem(elems, &gnmi.PathElem{Name: name, Key: ke.key}), ke.entry, e, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// child returns the schema entry of the child with name, nil without schema.
+func child(e *yentry.Entry, name string) *yentry.Entry {
+	if e == nil {
+		return nil
+	}
+	return e.GetChildren()[name]
+}
+
+// isList returns true when l is a list of entries, as opposed to a leaf-list.
+func isList(l []interface{}, e *yentry.Entry) bool {
+	if len(e.GetKey()) != 0 {
+		return true
+	}
+	for _, x := range l {
+		if _, ok := x.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return len(l) != 0
+}
+
+// findEntry returns the list entry matching all keys and its index, nil and -1
+// when not found.
+func findEntry(l []interface{}, keys map[string]string) (map[string]interface{}, int) {
+	for i, x := range l {
+		entry, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if matchKeys(entry, keys) {
+			return entry, i
+		}
+	}
+	return nil, -1
+}
+
+func matchKeys(entry map[string]interface{}, keys map[string]string) bool {
+	for k, kv := range keys {
+		v, ok := entry[k]
+		if !ok || keyString(v) != kv {
+			return false
+		}
+	}
+	return true
+}
+
+// keyValue returns the value of the key k of the list e as stored in a list
+// entry, the numbers and booleans are typed from the schema the way
+// encoding/json decodes them. The 64-bit numbers are strings in JSON.
+func keyValue(e *yentry.Entry, k, kv string) interface{} {
+	switch e.GetLeafType(k) {
+	case "int8", "int16", "int32", "uint8", "uint16", "uint32":
+		if f, err := strconv.ParseFloat(kv, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(kv); err == nil {
+			return b
+		}
+	}
+	return kv
+}
+
+// keyString returns the string of the key value v as used in a path.
+func keyString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// mergeValue merges nv into ov, containers are merged recursively and lists
+// with keys by entry, all other values are replaced.
+func mergeValue(ov, nv interface{}, e *yentry.Entry) interface{} {
+	switch nv := nv.(type) {
+	case map[string]interface{}:
+		om, ok := ov.(map[string]interface{})
+		if !ok {
+			return nv
+		}
+		mergeContainer(om, nv, e)
+		return om
+	case []interface{}:
+		ol, ok := ov.([]interface{})
+		if !ok || len(e.GetKey()) == 0 {
+			return nv
+		}
+		for _, x := range nv {
+			entry, ok := x.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			keys := make(map[string]string, len(e.GetKey()))
+			for _, k := range e.GetKey() {
+				keys[k] = keyString(entry[k])
+			}
+			if oe, _ := findEntry(ol, keys); oe != nil {
+				mergeContainer(oe, entry, e)
+				continue
+			}
+			ol = append(ol, entry)
+		}
+		return ol
+	default:
+		return nv
+	}
+}
+
+func mergeContainer(om, nm map[string]interface{}, e *yentry.Entry) {
+	for k, v := range nm {
+		om[k] = mergeValue(om[k], v, child(e, k))
+	}
+}
+
+// deepCopy copies the containers and lists of v, the tree never shares them
+// with the caller.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			c[k] = deepCopy(x)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, x := range v {
+			c[i] = deepCopy(x)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func appendElem(elems []*gnmi.PathElem, pe *gnmi.PathElem) []*gnmi.PathElem {
+	n := make([]*gnmi.PathElem, len(elems), len(elems)+1)
+	copy(n, elems)
+	return append(n, pe)
+}
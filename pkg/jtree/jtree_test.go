@@ -0,0 +1,420 @@
+package jtree
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+const testData = `{
+	"interface": [
+		{
+			"name": "ethernet-1/1",
+			"admin-state": "enable",
+			"subinterface": [
+				{"index": 0, "vlan": {"id": 10}},
+				{"index": 1}
+			]
+		},
+		{
+			"name": "ethernet-1/2",
+			"description": "uplink"
+		}
+	],
+	"system": {
+		"name": {"host-name": "leaf1"},
+		"dns": {"server": ["1.1.1.1", "8.8.8.8"]}
+	}
+}`
+
+func testTree(t *testing.T, opts ...Option) Tree {
+	var d map[string]interface{}
+	if err := json.Unmarshal([]byte(testData), &d); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return New(d, opts...)
+}
+
+func testSchema() *yentry.Entry {
+	return &yentry.Entry{
+		Children: map[string]*yentry.Entry{
+			"interface": {
+				Name: "interface",
+				Key:  []string{"name"},
+				Children: map[string]*yentry.Entry{
+					"subinterface": {Name: "subinterface", Key: []string{"index"},
+						LeafTypes: map[string]string{"index": "uint32"},
+					},
+				},
+			},
+			"system": {Name: "system"},
+		},
+	}
+}
+
+func path(elems ...*gnmi.PathElem) *gnmi.Path {
+	return &gnmi.Path{Elem: elems}
+}
+
+func elem(name string, kv ...string) *gnmi.PathElem {
+	pe := &gnmi.PathElem{Name: name}
+	if len(kv) > 0 {
+		pe.Key = map[string]string{}
+		for i := 0; i+1 < len(kv); i += 2 {
+			pe.Key[kv[i]] = kv[i+1]
+		}
+	}
+	return pe
+}
+
+func unmarshal(t *testing.T, s string) interface{} {
+	var x interface{}
+	if err := json.Unmarshal([]byte(s), &x); err != nil {
+		t.Fatalf("unmarshal %s: %v", s, err)
+	}
+	return x
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		inp    *gnmi.Path
+		exp    string
+		found  bool
+		expErr bool
+	}{
+		{name: "leaf in container", inp: path(elem("system"), elem("name"), elem("host-name")), exp: `"leaf1"`, found: true},
+		{name: "container", inp: path(elem("system"), elem("name")), exp: `{"host-name":"leaf1"}`, found: true},
+		{name: "leaf-list", inp: path(elem("system"), elem("dns"), elem("server")), exp: `["1.1.1.1","8.8.8.8"]`, found: true},
+		{name: "list entry", inp: path(elem("interface", "name", "ethernet-1/2")), exp: `{"name":"ethernet-1/2","description":"uplink"}`, found: true},
+		{name: "leaf in list entry", inp: path(elem("interface", "name", "ethernet-1/1"), elem("admin-state")), exp: `"enable"`, found: true},
+		{name: "numeric key", inp: path(elem("interface", "name", "ethernet-1/1"), elem("subinterface", "index", "0"), elem("vlan"), elem("id")), exp: `10`, found: true},
+		{name: "list without key", inp: path(elem("interface", "name", "ethernet-1/1"), elem("subinterface")), exp: `[{"index":0,"vlan":{"id":10}},{"index":1}]`, found: true},
+		{name: "root", inp: path(), exp: testData, found: true},
+		{name: "unknown leaf", inp: path(elem("system"), elem("ntp"))},
+		{name: "unknown list entry", inp: path(elem("interface", "name", "ethernet-1/3"), elem("admin-state"))},
+		{name: "unknown list entry at end of path", inp: path(elem("interface", "name", "ethernet-1/3"))},
+		{name: "key on container", inp: path(elem("system", "name", "x")), expErr: true},
+		{name: "through leaf", inp: path(elem("system"), elem("name"), elem("host-name"), elem("x")), expErr: true},
+	}
+	tr := testTree(t)
+	for _, tt := range tests {
+		got, found, err := tr.Get(tt.inp)
+		if (err != nil) != tt.expErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.expErr)
+			continue
+		}
+		if found != tt.found {
+			t.Errorf("%s: got found %t, want %t", tt.name, found, tt.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if exp := unmarshal(t, tt.exp); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, exp)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   *gnmi.Path
+		inp    string
+		get    *gnmi.Path
+		exp    string
+		expErr bool
+	}{
+		{
+			name: "leaf",
+			path: path(elem("system"), elem("name"), elem("host-name")),
+			inp:  `"leaf2"`,
+			get:  path(elem("system"), elem("name")),
+			exp:  `{"host-name":"leaf2"}`,
+		},
+		{
+			name: "container",
+			path: path(elem("system"), elem("name")),
+			inp:  `{"domain-name":"example.com"}`,
+			get:  path(elem("system"), elem("name")),
+			exp:  `{"host-name":"leaf1","domain-name":"example.com"}`,
+		},
+		{
+			name: "new containers",
+			path: path(elem("system"), elem("ntp"), elem("server")),
+			inp:  `{"address":"10.0.0.1"}`,
+			get:  path(elem("system"), elem("ntp")),
+			exp:  `{"server":{"address":"10.0.0.1"}}`,
+		},
+		{
+			name: "existing list entry",
+			path: path(elem("interface", "name", "ethernet-1/2")),
+			inp:  `{"admin-state":"disable"}`,
+			get:  path(elem("interface", "name", "ethernet-1/2")),
+			exp:  `{"name":"ethernet-1/2","description":"uplink","admin-state":"disable"}`,
+		},
+		{
+			name: "new list entry",
+			path: path(elem("interface", "name", "ethernet-1/3"), elem("subinterface", "index", "5")),
+			inp:  `{"admin-state":"enable"}`,
+			get:  path(elem("interface", "name", "ethernet-1/3")),
+			exp:  `{"name":"ethernet-1/3","subinterface":[{"index":5,"admin-state":"enable"}]}`,
+		},
+		{
+			name: "new list entry in existing list",
+			path: path(elem("interface", "name", "ethernet-1/1"), elem("subinterface", "index", "100000000"), elem("admin-state")),
+			inp:  `"enable"`,
+			get:  path(elem("interface", "name", "ethernet-1/1"), elem("subinterface", "index", "100000000")),
+			exp:  `{"index":100000000,"admin-state":"enable"}`,
+		},
+		{
+			name: "list by key",
+			path: path(elem("interface", "name", "ethernet-1/1"), elem("subinterface")),
+			inp:  `[{"index":1,"admin-state":"enable"},{"index":2}]`,
+			get:  path(elem("interface", "name", "ethernet-1/1"), elem("subinterface")),
+			exp:  `[{"index":0,"vlan":{"id":10}},{"index":1,"admin-state":"enable"},{"index":2}]`,
+		},
+		{
+			name: "root",
+			path: path(),
+			inp:  `{"system":{"name":{"domain-name":"example.com"}}}`,
+			get:  path(elem("system"), elem("name")),
+			exp:  `{"host-name":"leaf1","domain-name":"example.com"}`,
+		},
+		{
+			name:   "list entry with leaf",
+			path:   path(elem("interface", "name", "ethernet-1/2")),
+			inp:    `"x"`,
+			expErr: true,
+		},
+		{
+			name:   "key on container",
+			path:   path(elem("system", "name", "x"), elem("y")),
+			inp:    `"x"`,
+			expErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tr := testTree(t, WithSchema(testSchema()))
+		err := tr.Merge(tt.path, unmarshal(t, tt.inp))
+		if (err != nil) != tt.expErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.expErr)
+			continue
+		}
+		if tt.expErr {
+			continue
+		}
+		got, _, _ := tr.Get(tt.get)
+		if exp := unmarshal(t, tt.exp); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, exp)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		name string
+		path *gnmi.Path
+		inp  string
+		get  *gnmi.Path
+		exp  string
+	}{
+		{
+			name: "container",
+			path: path(elem("system"), elem("name")),
+			inp:  `{"domain-name":"example.com"}`,
+			get:  path(elem("system"), elem("name")),
+			exp:  `{"domain-name":"example.com"}`,
+		},
+		{
+			name: "list entry keeps its keys",
+			path: path(elem("interface", "name", "ethernet-1/2")),
+			inp:  `{"admin-state":"disable"}`,
+			get:  path(elem("interface")),
+			exp: `[{"name":"ethernet-1/1","admin-state":"enable","subinterface":[{"index":0,"vlan":{"id":10}},{"index":1}]},
+				{"name":"ethernet-1/2","admin-state":"disable"}]`,
+		},
+		{
+			name: "list",
+			path: path(elem("interface", "name", "ethernet-1/1"), elem("subinterface")),
+			inp:  `[{"index":2}]`,
+			get:  path(elem("interface", "name", "ethernet-1/1"), elem("subinterface")),
+			exp:  `[{"index":2}]`,
+		},
+		{
+			name: "leaf-list",
+			path: path(elem("system"), elem("dns"), elem("server")),
+			inp:  `["9.9.9.9"]`,
+			get:  path(elem("system"), elem("dns")),
+			exp:  `{"server":["9.9.9.9"]}`,
+		},
+		{
+			name: "root",
+			path: path(),
+			inp:  `{"system":{}}`,
+			get:  path(),
+			exp:  `{"system":{}}`,
+		},
+	}
+	for _, tt := range tests {
+		tr := testTree(t, WithSchema(testSchema()))
+		if err := tr.Replace(tt.path, unmarshal(t, tt.inp)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, _, _ := tr.Get(tt.get)
+		if exp := unmarshal(t, tt.exp); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, exp)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		inp    *gnmi.Path
+		found  bool
+		expErr bool
+		exp    string
+	}{
+		{
+			name:  "leaf, empty parent containers are removed",
+			inp:   path(elem("system"), elem("name"), elem("host-name")),
+			found: true,
+			exp: `{"interface":[{"name":"ethernet-1/1","admin-state":"enable","subinterface":[{"index":0,"vlan":{"id":10}},{"index":1}]},{"name":"ethernet-1/2","description":"uplink"}],
+				"system":{"dns":{"server":["1.1.1.1","8.8.8.8"]}}}`,
+		},
+		{
+			name:  "leaf in list entry keeps the entry",
+			inp:   path(elem("interface", "name", "ethernet-1/2"), elem("description")),
+			found: true,
+			exp: `{"interface":[{"name":"ethernet-1/1","admin-state":"enable","subinterface":[{"index":0,"vlan":{"id":10}},{"index":1}]},{"name":"ethernet-1/2"}],
+				"system":{"name":{"host-name":"leaf1"},"dns":{"server":["1.1.1.1","8.8.8.8"]}}}`,
+		},
+		{
+			name:  "last list entry removes the list",
+			inp:   path(elem("interface", "name", "ethernet-1/1"), elem("subinterface", "index", "1")),
+			found: true,
+			exp: `{"interface":[{"name":"ethernet-1/1","admin-state":"enable","subinterface":[{"index":0,"vlan":{"id":10}}]},{"name":"ethernet-1/2","description":"uplink"}],
+				"system":{"name":{"host-name":"leaf1"},"dns":{"server":["1.1.1.1","8.8.8.8"]}}}`,
+		},
+		{
+			name:  "list",
+			inp:   path(elem("interface")),
+			found: true,
+			exp:   `{"system":{"name":{"host-name":"leaf1"},"dns":{"server":["1.1.1.1","8.8.8.8"]}}}`,
+		},
+		{
+			name:  "root",
+			inp:   path(),
+			found: true,
+			exp:   `{}`,
+		},
+		{
+			name: "unknown list entry",
+			inp:  path(elem("interface", "name", "ethernet-1/3")),
+			exp:  testData,
+		},
+		{
+			name:   "key on container",
+			inp:    path(elem("system", "name", "x")),
+			expErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tr := testTree(t)
+		found, err := tr.Delete(tt.inp)
+		if (err != nil) != tt.expErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.expErr)
+			continue
+		}
+		if tt.expErr {
+			continue
+		}
+		if found != tt.found {
+			t.Errorf("%s: got found %t, want %t", tt.name, found, tt.found)
+		}
+		if exp := unmarshal(t, tt.exp); !reflect.DeepEqual(tr.Data(), exp) {
+			t.Errorf("%s: got %v, want %v", tt.name, tr.Data(), exp)
+		}
+	}
+
+	// deleting the last subinterface removes the list, not the interface
+	tr := testTree(t)
+	for _, idx := range []string{"0", "1"} {
+		if _, err := tr.Delete(path(elem("interface", "name", "ethernet-1/1"), elem("subinterface", "index", idx))); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+	}
+	got, _, _ := tr.Get(path(elem("interface", "name", "ethernet-1/1")))
+	if exp := unmarshal(t, `{"name":"ethernet-1/1","admin-state":"enable"}`); !reflect.DeepEqual(got, exp) {
+		t.Errorf("delete last entry: got %v, want %v", got, exp)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		inp  *gnmi.Path
+		exp  []string
+	}{
+		{
+			name: "root with schema",
+			opts: []Option{WithSchema(testSchema())},
+			inp:  path(),
+			exp: []string{
+				"/interface[name=ethernet-1/1]/admin-state",
+				"/interface[name=ethernet-1/1]/name",
+				"/interface[name=ethernet-1/1]/subinterface[index=0]/index",
+				"/interface[name=ethernet-1/1]/subinterface[index=0]/vlan/id",
+				"/interface[name=ethernet-1/1]/subinterface[index=1]/index",
+				"/interface[name=ethernet-1/2]/description",
+				"/interface[name=ethernet-1/2]/name",
+				"/system/dns/server",
+				"/system/name/host-name",
+			},
+		},
+		{
+			name: "list entry",
+			opts: []Option{WithSchema(testSchema())},
+			inp:  path(elem("interface", "name", "ethernet-1/2")),
+			exp: []string{
+				"/interface[name=ethernet-1/2]/description",
+				"/interface[name=ethernet-1/2]/name",
+			},
+		},
+		{
+			name: "list without schema",
+			inp:  path(elem("interface", "name", "ethernet-1/1"), elem("subinterface")),
+			exp: []string{
+				"/interface[name=ethernet-1/1]/subinterface[index=0]/index",
+				"/interface[name=ethernet-1/1]/subinterface[index=0]/vlan/id",
+				"/interface[name=ethernet-1/1]/subinterface[index=1]/index",
+			},
+		},
+		{
+			name: "unknown path",
+			inp:  path(elem("system"), elem("ntp")),
+			exp:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		tr := testTree(t, tt.opts...)
+		got := []string{}
+		if err := tr.Walk(tt.inp, func(p *gnmi.Path, _ interface{}) error {
+			got = append(got, yentry.GnmiPath2XPath(p, true))
+			return nil
+		}); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.exp)
+		}
+	}
+}
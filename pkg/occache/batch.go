@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package occache
+
+import (
+	"strings"
+
+	"github.com/openconfig/gnmi/errlist"
+	"github.com/openconfig/gnmi/metadata"
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// counts accumulates the metadata counters of a batch, they are added to the
+// metadata once per batch. Nil counts are added to the metadata right away.
+type counts map[string]int64
+
+func (t *Target) addInt(c counts, name string, v int64) {
+	if c == nil {
+		t.meta.AddInt(name, v)
+		return
+	}
+	c[name] += v
+}
+
+func (t *Target) addCounts(c counts) {
+	for name, v := range c {
+		if v != 0 {
+			t.meta.AddInt(name, v)
+		}
+	}
+}
+
+// GnmiUpdateBatch sends the notifications into the target cache. It has the
+// same semantics as calling GnmiUpdate for every notification, stale and
+// suppressed updates included, but the latest timestamp and the metadata
+// counters are updated once for the batch. The consecutive updates adding new
+// leaves are inserted in the tree under a single acquisition of the tree lock,
+// a new leaf is only added after the updates and deletes preceding it in the
+// batch. The updates of a notification are stored as separate leaves without
+// cloning the notification, the leaves share the prefix of the notification
+// so the notifications must not be modified after the call. The errors of
+// individual updates are returned together, the other updates are applied.
+func (t *Target) GnmiUpdateBatch(ns []*pb.Notification) error {
+	c := make(counts)
+	defer t.addCounts(c)
+	errs := &errlist.List{}
+	a := &adds{}
+	var latest int64
+	for _, n := range ns {
+		if n.GetTimestamp() > latest {
+			latest = n.GetTimestamp()
+		}
+		switch {
+		case n.Atomic:
+			l := len(n.GetUpdate()) + len(n.GetDelete())
+			if l == 0 {
+				c[metadata.EmptyCount]++
+				continue
+			}
+			t.flushAdds(a, c, errs)
+			nd, err := t.gnmiAtomic(n, c)
+			if err != nil {
+				errs.Add(err)
+				continue
+			}
+			if nd != nil {
+				c[metadata.UpdateCount] += int64(l)
+				t.client(nd)
+			}
+		case len(n.GetUpdate())+len(n.GetDelete()) == 0:
+			c[metadata.EmptyCount]++
+		default:
+			single := len(n.GetUpdate())+len(n.GetDelete()) == 1
+			for _, u := range n.GetUpdate() {
+				noti := n
+				if !single {
+					noti = &pb.Notification{Timestamp: n.GetTimestamp(), Prefix: n.GetPrefix(), Alias: n.GetAlias(), Update: []*pb.Update{u}}
+				}
+				path := joinPrefixAndPath(noti.GetPrefix(), u.GetPath())
+				if path[0] != metadata.Root && !a.has(path) && t.t.GetLeaf(path) == nil {
+					// a new leaf is added with the next batch insert
+					if err := t.reserve(path, 1, t.size(noti)); err != nil {
+						errs.Add(err)
+						continue
+					}
+					a.add(path, noti)
+					continue
+				}
+				t.flushAdds(a, c, errs)
+				nd, err := t.gnmiUpdate(noti, c)
+				if err != nil {
+					errs.Add(err)
+					continue
+				}
+				if nd != nil {
+					c[metadata.UpdateCount]++
+					t.client(nd)
+				}
+			}
+			if len(n.GetDelete()) != 0 {
+				t.flushAdds(a, c, errs)
+			}
+			for _, d := range n.GetDelete() {
+				noti := n
+				if !single {
+					noti = &pb.Notification{Timestamp: n.GetTimestamp(), Prefix: n.GetPrefix(), Alias: n.GetAlias(), Delete: []*pb.Path{d}}
+				}
+				c[metadata.UpdateCount]++
+				for _, nd := range t.gnmiRemove(noti, c) {
+					t.client(nd)
+				}
+			}
+		}
+	}
+	t.flushAdds(a, c, errs)
+	t.checkTimestamp(T(latest))
+	return errs.Err()
+}
+
+// adds holds the new leaves of a batch which are inserted together, the quota
+// of the leaves is reserved.
+type adds struct {
+	paths  [][]string
+	values []interface{}
+	keys   map[string]bool
+}
+
+func (a *adds) add(path []string, n *pb.Notification) {
+	if a.keys == nil {
+		a.keys = make(map[string]bool)
+	}
+	a.paths = append(a.paths, path)
+	a.values = append(a.values, n)
+	a.keys[strings.Join(path, "\x00")] = true
+}
+
+func (a *adds) has(path []string) bool {
+	return a.keys[strings.Join(path, "\x00")]
+}
+
+// flushAdds inserts the new leaves of the batch in the tree, it has the same
+// effect as gnmiUpdate for every new leaf.
+func (t *Target) flushAdds(a *adds, c counts, errs *errlist.List) {
+	if len(a.paths) == 0 {
+		return
+	}
+	for i, err := range t.t.AddBatch(a.paths, a.values) {
+		n := a.values[i].(*pb.Notification)
+		if err != nil {
+			t.release(1, t.size(n))
+			errs.Add(err)
+			continue
+		}
+		t.track(a.paths[i], n)
+		t.journalAppend(n)
+		c[metadata.LeafCount]++
+		c[metadata.AddCount]++
+		if t.sync {
+			t.lat.Compute(T(n.GetTimestamp()))
+		}
+		if nd := t.t.GetLeaf(a.paths[i]); nd != nil {
+			c[metadata.UpdateCount]++
+			t.client(nd)
+		}
+	}
+	*a = adds{}
+}
@@ -0,0 +1,165 @@
+package occache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/openconfig/gnmi/metadata"
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"google.golang.org/protobuf/proto"
+)
+
+func batchNoti(target string, ts int64, leafs int, val string) *pb.Notification {
+	n := &pb.Notification{
+		Timestamp: ts,
+		Prefix:    &pb.Path{Target: target, Elem: []*pb.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+	}
+	for i := 0; i < leafs; i++ {
+		n.Update = append(n.Update, &pb.Update{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: fmt.Sprintf("leaf%d", i)}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: val}},
+		})
+	}
+	return n
+}
+
+func batchNotis(target string) []*pb.Notification {
+	del := &pb.Notification{
+		Timestamp: 5,
+		Prefix:    &pb.Path{Target: target, Elem: []*pb.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+		Delete:    []*pb.Path{{Elem: []*pb.PathElem{{Name: "leaf0"}}}, {Elem: []*pb.PathElem{{Name: "leaf1"}}}},
+	}
+	return []*pb.Notification{
+		batchNoti(target, 2, 3, "a"),
+		// stale
+		batchNoti(target, 1, 3, "b"),
+		// suppressed
+		batchNoti(target, 3, 3, "a"),
+		batchNoti(target, 4, 1, "c"),
+		del,
+		{Timestamp: 6, Prefix: &pb.Path{Target: target}},
+	}
+}
+
+func leafs(tg *Target) map[string]string {
+	m := make(map[string]string)
+	tg.t.Query([]string{"*"}, func(p []string, _ *octree.Leaf, v interface{}) error {
+		if n, ok := v.(*pb.Notification); ok && p[0] != metadata.Root {
+			m[fmt.Sprint(p)] = n.GetUpdate()[0].GetVal().GetStringVal()
+		}
+		return nil
+	})
+	return m
+}
+
+func TestGnmiUpdateBatch(t *testing.T) {
+	target := "dev1"
+	c := New([]string{target})
+	single := c.Add("single")
+	batch := c.GetTarget(target)
+
+	var singleErrs int
+	for _, n := range batchNotis("single") {
+		if err := single.GnmiUpdate(n); err != nil {
+			singleErrs++
+		}
+	}
+	ns := batchNotis(target)
+	orig := make([]*pb.Notification, 0, len(ns))
+	for _, n := range ns {
+		orig = append(orig, proto.Clone(n).(*pb.Notification))
+	}
+	if err := batch.GnmiUpdateBatch(ns); (err != nil) != (singleErrs != 0) {
+		t.Errorf("GnmiUpdateBatch: got error %v, want %d errors", err, singleErrs)
+	}
+	for i := range ns {
+		if !proto.Equal(ns[i], orig[i]) {
+			t.Errorf("GnmiUpdateBatch modified notification %d", i)
+		}
+	}
+
+	exp := map[string]string{"[interface e1 leaf2]": "a"}
+	if got := leafs(single); fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Errorf("GnmiUpdate: got %v, want %v", got, exp)
+	}
+	if got := leafs(batch); fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Errorf("GnmiUpdateBatch: got %v, want %v", got, exp)
+	}
+	for _, m := range []string{metadata.UpdateCount, metadata.EmptyCount, metadata.StaleCount, metadata.SuppressedCount,
+		metadata.LeafCount, metadata.AddCount, metadata.DelCount} {
+		sv, _ := single.meta.GetInt(m)
+		bv, _ := batch.meta.GetInt(m)
+		if sv != bv {
+			t.Errorf("%s: got %d, want %d", m, bv, sv)
+		}
+	}
+	if batch.ts != single.ts {
+		t.Errorf("latest timestamp: got %v, want %v", batch.ts, single.ts)
+	}
+}
+
+func TestGnmiUpdateBatchClient(t *testing.T) {
+	updates := func(batch bool) []string {
+		c := New([]string{"dev1"})
+		var got []string
+		c.SetClient(func(l *octree.Leaf) {
+			if n, ok := l.Value().(*pb.Notification); ok {
+				for _, u := range n.GetUpdate() {
+					got = append(got, fmt.Sprintf("%s=%s", u.GetPath().GetElem()[0].GetName(), u.GetVal().GetStringVal()))
+				}
+				for _, d := range n.GetDelete() {
+					got = append(got, "-"+d.GetElem()[0].GetName())
+				}
+			}
+		})
+		tg := c.GetTarget("dev1")
+		ns := batchNotis("dev1")
+		// the new leaf is added after the delete preceding it
+		ns = append(ns, batchNoti("dev1", 7, 1, "d"))
+		if batch {
+			tg.GnmiUpdateBatch(ns)
+			return got
+		}
+		for _, n := range ns {
+			tg.GnmiUpdate(n)
+		}
+		return got
+	}
+	single, batch := updates(false), updates(true)
+	if fmt.Sprint(batch) != fmt.Sprint(single) {
+		t.Errorf("GnmiUpdateBatch: got client updates %v, want %v", batch, single)
+	}
+}
+
+func benchmarkNotis(target string, n, leafs int) []*pb.Notification {
+	ns := make([]*pb.Notification, 0, n)
+	for i := 0; i < n; i++ {
+		noti := batchNoti(target, int64(i+1), leafs, "v")
+		noti.Prefix.Elem[0].Key["name"] = fmt.Sprintf("e%d", i)
+		ns = append(ns, noti)
+	}
+	return ns
+}
+
+func BenchmarkGnmiUpdate(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tg := New([]string{"dev1"}).GetTarget("dev1")
+		ns := benchmarkNotis("dev1", 100, 50)
+		b.StartTimer()
+		for _, n := range ns {
+			tg.GnmiUpdate(n)
+		}
+	}
+}
+
+func BenchmarkGnmiUpdateBatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tg := New([]string{"dev1"}).GetTarget("dev1")
+		ns := benchmarkNotis("dev1", 100, 50)
+		b.StartTimer()
+		tg.GnmiUpdateBatch(ns)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -42,14 +44,20 @@ func T(n int64) time.Time { return time.Unix(0, n) }
 
 // A Target hosts an indexed cache of state for a single target.
 type Target struct {
-	name   string             // name of the target
-	t      *octree.Tree       // actual cache of target data
-	client func(*octree.Leaf) // Function to pass all cache updates to.
-	sync   bool               // denotes whether this cache is in sync with target
-	meta   *metadata.Metadata // metadata associated with target
-	lat    *latency.Latency   // latency measurements
-	tsmu   sync.Mutex         // protects latest timestamp
-	ts     time.Time          // latest timestamp for an update
+	name    string             // name of the target
+	t       *octree.Tree       // actual cache of target data
+	client  func(*octree.Leaf) // Function to pass all cache updates to.
+	sync    bool               // denotes whether this cache is in sync with target
+	meta    *metadata.Metadata // metadata associated with target
+	lat     *latency.Latency   // latency measurements
+	tsmu    sync.Mutex         // protects latest timestamp
+	ts      time.Time          // latest timestamp for an update
+	jmu     sync.RWMutex       // protects journal
+	journal *Journal           // optional journal of the accepted notifications
+	hist    historyPolicy      // bounds of the per leaf history
+	quota   Quota              // bounds of the target data
+	config  bool               // target data is configuration, never evicted
+	usage   quotaUsage         // usage accounted against the quota
 }
 
 // Name returns the name of the target.
@@ -63,6 +71,16 @@ type options struct {
 	// calculated and exported as metadata.
 	latencyWindows      []time.Duration
 	avgLatencyPrecision time.Duration
+	// journalDir is the directory in which a journal per target is kept,
+	// journaling is disabled when empty.
+	journalDir     string
+	journalSegSize int64
+	// history bounds the values kept per leaf, history is disabled when both
+	// bounds are 0.
+	history historyPolicy
+	// quota bounds the data of every target, config disables eviction.
+	quota  Quota
+	config bool
 }
 
 // Option defines the function prototype to set options for creating a Cache.
@@ -93,6 +111,41 @@ func WithAvgLatencyPrecision(avgLatencyPrecision time.Duration) Option {
 	}
 }
 
+// WithJournal returns an Option to keep a journal of all accepted notifications
+// for each target in Cache. The journal of a target is stored in a sub directory
+// of dir with the name of the target, segSize is the size of a journal segment.
+func WithJournal(dir string, segSize int64) Option {
+	return func(o *options) {
+		o.journalDir = dir
+		o.journalSegSize = segSize
+	}
+}
+
+// WithHistory returns an Option to keep the previous values of every leaf of a
+// target. At most depth values are kept per leaf and values are dropped once
+// they were replaced more than age ago, a bound of 0 is unlimited.
+func WithHistory(depth int, age time.Duration) Option {
+	return func(o *options) {
+		o.history = historyPolicy{depth: depth, age: age}
+	}
+}
+
+// WithQuota returns an Option to bound the number of leaves and bytes of every
+// target in Cache.
+func WithQuota(q Quota) Option {
+	return func(o *options) {
+		o.quota = q
+	}
+}
+
+// WithConfig returns an Option to mark the data in Cache as configuration.
+// Configuration is never evicted, updates exceeding a quota are rejected.
+func WithConfig() Option {
+	return func(o *options) {
+		o.config = true
+	}
+}
+
 // Cache is a structure holding state information for multiple targets.
 type Cache struct {
 	opts    options
@@ -182,6 +235,18 @@ func (c *Cache) GetTarget(target string) *Target {
 	return c.targets[target]
 }
 
+// GetTargets returns the names of the targets in the cache in sorted order.
+func (c *Cache) GetTargets() []string {
+	defer c.mu.RUnlock()
+	c.mu.RLock()
+	names := make([]string, 0, len(c.targets))
+	for name := range c.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // HasTarget reports whether the specified target exists in the cache or a glob
 // (*) is passed which will match any target (even if no targets yet exist).
 func (c *Cache) HasTarget(target string) bool {
@@ -222,6 +287,45 @@ func (c *Cache) Query(target string, query []string, fn octree.VisitFunc) error
 	return nil
 }
 
+// QueryFiltered calls fn for the leaves of the target matching the query like
+// Query, the nodes for which keep returns false are not walked.
+func (c *Cache) QueryFiltered(target string, query []string, keep octree.KeepFunc, fn octree.VisitFunc) error {
+	switch {
+	case target == "":
+		return errors.New("no target specified in query")
+	case target == "*":
+		defer c.mu.RUnlock()
+		c.mu.RLock()
+		for _, target := range c.targets {
+			if err := target.t.QueryFiltered(query, keep, fn); err != nil {
+				return err
+			}
+		}
+	default:
+		dc := c.GetTarget(target)
+		if dc == nil {
+			return fmt.Errorf("target %q not found in cache", target)
+		}
+		return dc.t.QueryFiltered(query, keep, fn)
+	}
+	return nil
+}
+
+// GetLeafValue returns the value of the leaf at path of the target, nil for a
+// branch, and true if a leaf or branch exists at path. All elements of path
+// must be fully specified with no globbing (*).
+func (c *Cache) GetLeafValue(target string, path []string) (interface{}, bool) {
+	dc := c.GetTarget(target)
+	if dc == nil {
+		return nil, false
+	}
+	node := dc.t.Get(path)
+	if node == nil {
+		return nil, false
+	}
+	return node.Value(), true
+}
+
 // Add reserves space in c to receive updates for the specified target.
 func (c *Cache) Add(target string) *Target {
 	defer c.mu.Unlock()
@@ -237,6 +341,17 @@ func (c *Cache) Add(target string) *Target {
 		client: c.client,
 		lat:    latency.New(c.opts.latencyWindows, latOpts),
 	}
+	if c.opts.journalDir != "" {
+		j, err := OpenJournal(filepath.Join(c.opts.journalDir, target), c.opts.journalSegSize)
+		if err != nil {
+			log.Errorf("target %q got error opening journal, %v", target, err)
+		} else {
+			t.journal = j
+		}
+	}
+	t.hist = c.opts.history
+	t.quota = c.opts.quota
+	t.config = c.opts.config
 	c.targets[target] = t
 	return t
 }
@@ -255,6 +370,13 @@ func (c *Cache) Reset(target string) {
 func (c *Cache) Remove(target string) {
 	defer c.mu.Unlock()
 	c.mu.Lock()
+	if t := c.targets[target]; t != nil {
+		if j := t.Journal(); j != nil {
+			if err := j.Close(); err != nil {
+				log.Errorf("target %q got error closing journal, %v", target, err)
+			}
+		}
+	}
 	delete(c.targets, target)
 	// Notify clients that the target is removed.
 	c.client(octree.DetachedLeaf(deleteNoti(target, "", []string{"*"})))
@@ -296,6 +418,25 @@ func (t *Target) Connect() {
 	}
 }
 
+// Disconnect creates internal gnmi.Notifications for the metadata/connected
+// and metadata/sync paths to set the state to false for the specified target.
+func (c *Cache) Disconnect(name string) {
+	if target := c.GetTarget(name); target != nil {
+		target.Disconnect()
+	}
+}
+
+// Disconnect creates internal gnmi.Notifications for the metadata/connected
+// and metadata/sync paths to set the state to false for the specified target,
+// the data of the target is kept until the connection is resumed.
+func (t *Target) Disconnect() {
+	for _, m := range []string{metadata.Connected, metadata.Sync} {
+		if err := t.GnmiUpdate(metaNotiBool(t.name, m, false)); err != nil {
+			log.Errorf("target %q got error during meta %s update, %v", t.name, m, err)
+		}
+	}
+}
+
 // GnmiUpdate sends a pb.Notification into the cache.
 // If the notification has multiple Updates/Deletes,
 // each individual Update/Delete is sent to cache as
@@ -323,15 +464,12 @@ func (t *Target) GnmiUpdate(n *pb.Notification) error {
 	switch {
 	// Store atomic notifications as a single leaf in the tree.
 	case n.Atomic:
-		if len(n.GetDelete()) > 0 {
-			return errors.New("atomic deletes unsupported")
-		}
-		l := len(n.GetUpdate())
+		l := len(n.GetUpdate()) + len(n.GetDelete())
 		if l == 0 {
 			t.meta.AddInt(metadata.EmptyCount, 1)
 			return nil
 		}
-		nd, err := t.gnmiUpdate(n)
+		nd, err := t.gnmiAtomic(n, nil)
 		if err != nil {
 			return err
 		}
@@ -354,7 +492,7 @@ func (t *Target) GnmiUpdate(n *pb.Notification) error {
 		for _, u := range updates {
 			noti := proto.Clone(n).(*pb.Notification)
 			noti.Update = []*pb.Update{u}
-			nd, err := t.gnmiUpdate(noti)
+			nd, err := t.gnmiUpdate(noti, nil)
 			if err != nil {
 				errs.Add(err)
 				continue
@@ -369,7 +507,7 @@ func (t *Target) GnmiUpdate(n *pb.Notification) error {
 			noti := proto.Clone(n).(*pb.Notification)
 			noti.Delete = []*pb.Path{d}
 			t.meta.AddInt(metadata.UpdateCount, 1)
-			for _, nd := range t.gnmiRemove(noti) {
+			for _, nd := range t.gnmiRemove(noti, nil) {
 				t.client(nd)
 			}
 		}
@@ -378,7 +516,7 @@ func (t *Target) GnmiUpdate(n *pb.Notification) error {
 	// Single update notification could be handled by the above code but is
 	// handled separately to avoid the unnecessary proto.Clone call.
 	case len(n.GetUpdate()) == 1:
-		nd, err := t.gnmiUpdate(n)
+		nd, err := t.gnmiUpdate(n, nil)
 		if err != nil {
 			return err
 		}
@@ -390,7 +528,7 @@ func (t *Target) GnmiUpdate(n *pb.Notification) error {
 	// Single delete notification also avoids proto.Clone above.
 	case len(n.GetDelete()) == 1:
 		t.meta.AddInt(metadata.UpdateCount, 1)
-		for _, nd := range t.gnmiRemove(n) {
+		for _, nd := range t.gnmiRemove(n, nil) {
 			t.client(nd)
 		}
 
@@ -412,7 +550,7 @@ func (t *Target) checkTimestamp(ts time.Time) {
 	}
 }
 
-func (t *Target) gnmiUpdate(n *pb.Notification) (*octree.Leaf, error) {
+func (t *Target) gnmiUpdate(n *pb.Notification, c counts) (*octree.Leaf, error) {
 	realData := true
 	suffix := n.Update[0].Path
 	// If the notification is an atomic group of updates, store them under the prefix only.
@@ -460,7 +598,7 @@ func (t *Target) gnmiUpdate(n *pb.Notification) (*octree.Leaf, error) {
 		switch {
 		case n.GetTimestamp() < old.GetTimestamp():
 			// Update rejected. Timestamp < previous recorded timestamp.
-			t.meta.AddInt(metadata.StaleCount, 1)
+			t.addInt(c, metadata.StaleCount, 1)
 			return nil, errors.New("update is stale")
 		case n.GetTimestamp() == old.GetTimestamp():
 			if !proto.Equal(old, n) {
@@ -469,14 +607,27 @@ func (t *Target) gnmiUpdate(n *pb.Notification) (*octree.Leaf, error) {
 				}
 				// Allow to continue to update the cache taking the last supplied value for this timestamp.
 			} else {
-				t.meta.AddInt(metadata.StaleCount, 1)
+				t.addInt(c, metadata.StaleCount, 1)
 				return nil, errors.New("update is stale")
 			}
 		}
-		oldval.Update(n)
+		if realData {
+			if err := t.reserve(path, 0, t.size(n)-t.size(old)); err != nil {
+				return nil, err
+			}
+		}
+		if realData && t.hist.enabled() {
+			oldval.UpdateWithHistory(n, t.hist.depth, t.hist.expired)
+		} else {
+			oldval.Update(n)
+		}
+		if realData {
+			t.track(path, n)
+			t.journalAppend(n)
+		}
 		// Simulate event-driven for all non-atomic updates.
 		if !n.Atomic && value.Equal(old.Update[0].Val, n.Update[0].Val) {
-			t.meta.AddInt(metadata.SuppressedCount, 1)
+			t.addInt(c, metadata.SuppressedCount, 1)
 			return nil, nil
 		}
 		// Compute latency for updated leaves.
@@ -487,12 +638,22 @@ func (t *Target) gnmiUpdate(n *pb.Notification) (*octree.Leaf, error) {
 		return oldval, nil
 	}
 	// Add a new leaf.
+	if realData {
+		if err := t.reserve(path, 1, t.size(n)); err != nil {
+			return nil, err
+		}
+	}
 	if err := t.t.Add(path, n); err != nil {
+		if realData {
+			t.release(1, t.size(n))
+		}
 		return nil, err
 	}
 	if realData {
-		t.meta.AddInt(metadata.LeafCount, 1)
-		t.meta.AddInt(metadata.AddCount, 1)
+		t.track(path, n)
+		t.journalAppend(n)
+		t.addInt(c, metadata.LeafCount, 1)
+		t.addInt(c, metadata.AddCount, 1)
 		// Compute latency for new leaves.
 		if t.sync {
 			// Record latency for post-sync target updates.  Exclude metadata updates.
@@ -502,30 +663,164 @@ func (t *Target) gnmiUpdate(n *pb.Notification) (*octree.Leaf, error) {
 	return t.t.GetLeaf(path), nil
 }
 
-func (t *Target) gnmiRemove(n *pb.Notification) []*octree.Leaf {
+// gnmiAtomic stores the atomic notification as a single leaf under its prefix,
+// the notification replaces the atomic group stored under the prefix as a
+// whole. An atomic notification without updates removes the group. The
+// returned leaf holds the notification intact.
+func (t *Target) gnmiAtomic(n *pb.Notification, c counts) (*octree.Leaf, error) {
+	if len(n.GetUpdate()) > 0 {
+		return t.gnmiUpdate(n, c)
+	}
+	path := joinPrefixAndPath(n.Prefix, nil)
+	old, ok := t.t.GetLeafValue(path).(*pb.Notification)
+	if !ok || !old.GetAtomic() {
+		return nil, nil
+	}
+	if n.GetTimestamp() < old.GetTimestamp() {
+		t.addInt(c, metadata.StaleCount, 1)
+		return nil, errors.New("update is stale")
+	}
+	var size int64
+	leaves := t.t.DeleteConditional(path, func(v interface{}) bool {
+		size += t.size(v)
+		return true
+	})
+	if len(leaves) == 0 {
+		return nil, nil
+	}
+	t.journalAppend(n)
+	t.release(int64(len(leaves)), size)
+	t.addInt(c, metadata.LeafCount, -int64(len(leaves)))
+	t.addInt(c, metadata.DelCount, int64(len(leaves)))
+	return octree.DetachedLeaf(n), nil
+}
+
+func (t *Target) gnmiRemove(n *pb.Notification, c counts) []*octree.Leaf {
 	path := joinPrefixAndPath(n.Prefix, n.Delete[0])
 	if path[0] == metadata.Root {
 		t.meta.ResetEntry(path[1])
 	}
-	leaves := t.t.DeleteConditional(path, func(v interface{}) bool { return v.(*pb.Notification).GetTimestamp() < n.GetTimestamp() })
+	root := path
+	var size int64
+	var deleted []*pb.Path
+	condition := func(v interface{}) bool {
+		if v.(*pb.Notification).GetTimestamp() < n.GetTimestamp() {
+			size += t.size(v)
+			return true
+		}
+		return false
+	}
+	if path[0] != metadata.Root && len(n.Delete[0].GetElem()) != 0 {
+		// match the leaves per key, the wildcard and missing keys match all
+		// entries of a list
+		d := append(append([]*pb.PathElem{}, n.GetPrefix().GetElem()...), n.Delete[0].GetElem()...)
+		root = t.deleteRoot(n.GetPrefix(), d)
+		condition = func(v interface{}) bool {
+			nv, ok := v.(*pb.Notification)
+			if !ok || nv.GetTimestamp() >= n.GetTimestamp() {
+				return false
+			}
+			l := leafElems(nv)
+			if !matchDelete(d, l) {
+				return false
+			}
+			size += t.size(v)
+			deleted = append(deleted, &pb.Path{Elem: l})
+			return true
+		}
+	}
+	leaves := t.t.DeleteConditional(root, condition)
 	if len(leaves) == 0 {
 		return nil
 	}
-	deleted := int64(len(leaves))
-	t.meta.AddInt(metadata.LeafCount, -deleted)
-	t.meta.AddInt(metadata.DelCount, deleted)
+	count := int64(len(leaves))
+	if path[0] != metadata.Root {
+		t.journalAppend(n)
+		t.release(count, size)
+	}
+	t.addInt(c, metadata.LeafCount, -count)
+	t.addInt(c, metadata.DelCount, count)
+	if deleted == nil {
+		for _, l := range leaves {
+			deleted = append(deleted, &pb.Path{Element: l})
+		}
+	}
+	// report the exact path of every removed leaf
 	var ls []*octree.Leaf
-	for _, l := range leaves {
+	for _, d := range deleted {
 		noti := &pb.Notification{
 			Timestamp: n.GetTimestamp(),
-			Prefix:    &pb.Path{Target: n.GetPrefix().GetTarget()},
-			Delete:    []*pb.Path{{Element: l}},
+			Prefix:    &pb.Path{Target: n.GetPrefix().GetTarget(), Origin: n.GetPrefix().GetOrigin()},
+			Delete:    []*pb.Path{d},
 		}
 		ls = append(ls, octree.DetachedLeaf(noti))
 	}
 	return ls
 }
 
+// deleteRoot returns the tree path the leaves matching the delete path d are
+// stored below. The tree path ends before the first element with a wildcard
+// and before the first element which is not in the tree, e.g. a list element
+// without keys.
+func (t *Target) deleteRoot(pr *pb.Path, d []*pb.PathElem) []string {
+	root := path.ToStrings(&pb.Path{Origin: pr.GetOrigin()}, true)
+	for _, e := range d {
+		if e.GetName() == "*" {
+			break
+		}
+		wildcard := false
+		for _, v := range e.GetKey() {
+			if v == "*" {
+				wildcard = true
+			}
+		}
+		if wildcard {
+			break
+		}
+		next := append(root[:len(root):len(root)], path.ToStrings(&pb.Path{Elem: []*pb.PathElem{e}}, false)...)
+		if t.t.Get(next) == nil {
+			break
+		}
+		root = next
+	}
+	return root
+}
+
+// leafElems returns the path elements of the leaf holding the notification,
+// an atomic group is stored under its prefix.
+func leafElems(n *pb.Notification) []*pb.PathElem {
+	l := append([]*pb.PathElem{}, n.GetPrefix().GetElem()...)
+	if !n.GetAtomic() && len(n.GetUpdate()) != 0 {
+		l = append(l, n.GetUpdate()[0].GetPath().GetElem()...)
+	}
+	return l
+}
+
+// matchDelete returns true if the leaf path l is at or below the delete path
+// d. The keys of d are matched one by one, a wildcard or a key which is not
+// in d matches any value.
+func matchDelete(d, l []*pb.PathElem) bool {
+	if len(l) < len(d) {
+		return false
+	}
+	// the metadata is only deleted explicitly
+	if len(l) != 0 && l[0].GetName() == metadata.Root && d[0].GetName() != metadata.Root {
+		return false
+	}
+	for i, e := range d {
+		if e.GetName() != "*" && e.GetName() != l[i].GetName() {
+			return false
+		}
+		for k, v := range e.GetKey() {
+			lv, ok := l[i].GetKey()[k]
+			if !ok || (v != "*" && v != lv) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // updateCache calls fn for each Target.
 func (c *Cache) updateCache(fn func(*Target, func(*octree.Leaf))) {
 	defer c.mu.RUnlock()
@@ -571,7 +866,7 @@ func (t *Target) updateMeta(clients func(*octree.Leaf)) {
 		prev := t.t.GetLeafValue(path)
 		if prev == nil || prev.(*pb.Notification).Update[0].Val.Value.(*pb.TypedValue_BoolVal).BoolVal != v {
 			noti := metaNotiBool(t.name, value, v)
-			if n, _ := t.gnmiUpdate(noti); n != nil {
+			if n, _ := t.gnmiUpdate(noti, nil); n != nil {
 				if clients != nil {
 					clients(n)
 				}
@@ -588,7 +883,7 @@ func (t *Target) updateMeta(clients func(*octree.Leaf)) {
 		prev := t.t.GetLeafValue(path)
 		if prev == nil || prev.(*pb.Notification).Update[0].Val.Value.(*pb.TypedValue_IntVal).IntVal != v {
 			noti := metaNotiInt(t.name, value, v)
-			if n, _ := t.gnmiUpdate(noti); n != nil {
+			if n, _ := t.gnmiUpdate(noti, nil); n != nil {
 				if clients != nil {
 					clients(n)
 				}
@@ -605,7 +900,7 @@ func (t *Target) updateMeta(clients func(*octree.Leaf)) {
 		prev := t.t.GetLeafValue(path)
 		if prev == nil || prev.(*pb.Notification).Update[0].Val.Value.(*pb.TypedValue_StringVal).StringVal != v {
 			noti := metaNotiStr(t.name, value, v)
-			if n, _ := t.gnmiUpdate(noti); n != nil {
+			if n, _ := t.gnmiUpdate(noti, nil); n != nil {
 				if clients != nil {
 					clients(n)
 				}
@@ -619,12 +914,18 @@ func (t *Target) updateMeta(clients func(*octree.Leaf)) {
 func (t *Target) Reset() {
 	// Reset metadata to zero values (e.g. connected = false) and notify clients.
 	t.meta.Clear()
+	t.resetUsage()
 	t.updateMeta(t.client)
 	for root := range t.t.Children() {
 		if root == metadata.Root {
 			continue
 		}
 		t.t.Delete([]string{root})
+		t.journalAppend(&pb.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    &pb.Path{Target: t.name},
+			Delete:    []*pb.Path{{Elem: []*pb.PathElem{{Name: root}}}},
+		})
 		t.client(octree.DetachedLeaf(deleteNoti(t.name, root, []string{"*"})))
 	}
 }
@@ -670,13 +971,13 @@ func metaNoti(t, m string, v *pb.TypedValue) *pb.Notification {
 }
 
 func metaNotiBool(t, m string, v bool) *pb.Notification {
-	return metaNoti(t, m, &pb.TypedValue{Value: &pb.TypedValue_BoolVal{v}})
+	return metaNoti(t, m, &pb.TypedValue{Value: &pb.TypedValue_BoolVal{BoolVal: v}})
 }
 
 func metaNotiInt(t, m string, v int64) *pb.Notification {
-	return metaNoti(t, m, &pb.TypedValue{Value: &pb.TypedValue_IntVal{v}})
+	return metaNoti(t, m, &pb.TypedValue{Value: &pb.TypedValue_IntVal{IntVal: v}})
 }
 
 func metaNotiStr(t, m string, v string) *pb.Notification {
-	return metaNoti(t, m, &pb.TypedValue{Value: &pb.TypedValue_StringVal{v}})
+	return metaNoti(t, m, &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: v}})
 }
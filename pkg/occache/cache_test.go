@@ -0,0 +1,197 @@
+package occache
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/metadata"
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+	"google.golang.org/protobuf/proto"
+)
+
+func atomicNoti(target string, ts int64, vals ...string) *pb.Notification {
+	n := &pb.Notification{
+		Timestamp: ts,
+		Prefix:    &pb.Path{Target: target, Elem: []*pb.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+		Atomic:    true,
+	}
+	for i := 0; i+1 < len(vals); i += 2 {
+		n.Update = append(n.Update, &pb.Update{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: vals[i]}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: vals[i+1]}},
+		})
+	}
+	return n
+}
+
+func atomicDelete(target string, ts int64, leaf string) *pb.Notification {
+	n := atomicNoti(target, ts)
+	n.Delete = []*pb.Path{{Elem: []*pb.PathElem{{Name: leaf}}}}
+	return n
+}
+
+func TestGnmiAtomic(t *testing.T) {
+	target := "dev1"
+	c := New([]string{target}, WithJournal(t.TempDir(), 1))
+	var got []*pb.Notification
+	c.SetClient(func(l *octree.Leaf) {
+		if n, ok := l.Value().(*pb.Notification); ok && n.GetAtomic() {
+			got = append(got, n)
+		}
+	})
+	tg := c.GetTarget(target)
+
+	tests := []struct {
+		n       *pb.Notification
+		wantErr bool
+		// group is the notification stored under the prefix afterwards
+		group *pb.Notification
+	}{
+		{n: atomicNoti(target, 1, "admin-state", "enable", "mtu", "1500"), group: atomicNoti(target, 1, "admin-state", "enable", "mtu", "1500")},
+		// the group is replaced as a whole
+		{n: atomicNoti(target, 2, "description", "uplink"), group: atomicNoti(target, 2, "description", "uplink")},
+		{n: atomicDelete(target, 1, "description"), wantErr: true, group: atomicNoti(target, 2, "description", "uplink")},
+		{n: atomicDelete(target, 3, "description")},
+	}
+	for i, tt := range tests {
+		got = nil
+		err := tg.GnmiUpdate(tt.n)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("GnmiUpdate %d: got error %v, want error %t", i, err, tt.wantErr)
+		}
+		var group *pb.Notification
+		if v := tg.t.GetLeafValue([]string{"interface", "e1"}); v != nil {
+			group = v.(*pb.Notification)
+		}
+		if !proto.Equal(group, tt.group) {
+			t.Errorf("GnmiUpdate %d: group got %v, want %v", i, group, tt.group)
+		}
+		if tt.wantErr {
+			continue
+		}
+		// the subscribers receive the notification intact
+		if len(got) != 1 || !proto.Equal(got[0], tt.n) {
+			t.Errorf("GnmiUpdate %d: client got %v, want %v", i, got, tt.n)
+		}
+	}
+	if v, _ := tg.meta.GetInt(metadata.LeafCount); v != 0 {
+		t.Errorf("leaf count: got %d, want 0", v)
+	}
+
+	rt, err := tg.Replay(time.Now())
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if v := rt.t.GetLeafValue([]string{"interface", "e1"}); v != nil {
+		t.Errorf("Replay: got group %v, want none", v)
+	}
+}
+
+func TestGnmiRemove(t *testing.T) {
+	target := "dev1"
+	leaves := []string{
+		"/interface[name=e1]/subinterface[index=0]/admin-state",
+		"/interface[name=e1]/subinterface[index=1]/admin-state",
+		"/interface[name=e2]/subinterface[index=0]/admin-state",
+		"/ip-range[start=10.0.0.1,end=10.0.0.9]/description",
+		"/ip-range[start=10.0.0.1,end=10.0.0.5]/description",
+		"/ip-range[start=10.0.0.2,end=10.0.0.9]/description",
+	}
+	tests := []struct {
+		inp string
+		exp []string
+	}{
+		{inp: "/interface[name=*]/subinterface[index=0]", exp: []string{
+			"/interface[name=e1]/subinterface[index=0]/admin-state",
+			"/interface[name=e2]/subinterface[index=0]/admin-state",
+		}},
+		{inp: "/interface/subinterface[index=1]", exp: []string{
+			"/interface[name=e1]/subinterface[index=1]/admin-state",
+		}},
+		{inp: "/interface[name=e1]/subinterface[index=0]/admin-state", exp: []string{
+			"/interface[name=e1]/subinterface[index=0]/admin-state",
+		}},
+		{inp: "/ip-range[start=10.0.0.1,end=*]", exp: []string{
+			"/ip-range[start=10.0.0.1,end=10.0.0.5]/description",
+			"/ip-range[start=10.0.0.1,end=10.0.0.9]/description",
+		}},
+		{inp: "/ip-range[end=10.0.0.9]", exp: []string{
+			"/ip-range[start=10.0.0.1,end=10.0.0.9]/description",
+			"/ip-range[start=10.0.0.2,end=10.0.0.9]/description",
+		}},
+		{inp: "/ip-range[start=10.0.0.3,end=10.0.0.9]"},
+		{inp: "/*/subinterface[index=1]", exp: []string{
+			"/interface[name=e1]/subinterface[index=1]/admin-state",
+		}},
+	}
+	for _, tt := range tests {
+		c := New([]string{target})
+		tg := c.GetTarget(target)
+		for _, l := range leaves {
+			if err := tg.GnmiUpdate(&pb.Notification{
+				Timestamp: 1,
+				Prefix:    &pb.Path{Target: target},
+				Update:    []*pb.Update{{Path: yparser.Xpath2GnmiPath(l, 0), Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "x"}}}},
+			}); err != nil {
+				t.Fatalf("GnmiUpdate: %v", err)
+			}
+		}
+		var got []string
+		c.SetClient(func(l *octree.Leaf) {
+			if n, ok := l.Value().(*pb.Notification); ok {
+				for _, d := range n.GetDelete() {
+					got = append(got, yparser.GnmiPath2XPath(d, true))
+				}
+			}
+		})
+		if err := tg.GnmiUpdate(&pb.Notification{
+			Timestamp: 2,
+			Prefix:    &pb.Path{Target: target},
+			Delete:    []*pb.Path{yparser.Xpath2GnmiPath(tt.inp, 0)},
+		}); err != nil {
+			t.Fatalf("GnmiUpdate %s: %v", tt.inp, err)
+		}
+		sort.Strings(got)
+		if len(got) != len(tt.exp) {
+			t.Errorf("delete %s: got %v, want %v", tt.inp, got, tt.exp)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.exp[i] {
+				t.Errorf("delete %s: got %v, want %v", tt.inp, got, tt.exp)
+				break
+			}
+		}
+		if v, _ := tg.meta.GetInt(metadata.LeafCount); v != int64(len(leaves)-len(tt.exp)) {
+			t.Errorf("delete %s: leaf count got %d, want %d", tt.inp, v, len(leaves)-len(tt.exp))
+		}
+	}
+}
+
+func TestGetLeafValue(t *testing.T) {
+	target := "dev1"
+	c := New([]string{target})
+	if err := c.GetTarget(target).GnmiUpdate(atomicNoti(target, 1, "mtu", "1500")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	tests := []struct {
+		target string
+		path   []string
+		exists bool
+		leaf   bool
+	}{
+		{target: target, path: []string{"interface", "e1"}, exists: true, leaf: true},
+		{target: target, path: []string{"interface"}, exists: true},
+		{target: target, path: []string{"interface", "e2"}},
+		{target: "dev2", path: []string{"interface", "e1"}},
+	}
+	for _, tt := range tests {
+		v, ok := c.GetLeafValue(tt.target, tt.path)
+		if ok != tt.exists || (v != nil) != tt.leaf {
+			t.Errorf("GetLeafValue %s %v: got %v %t, want leaf %t exists %t", tt.target, tt.path, v, ok, tt.leaf, tt.exists)
+		}
+	}
+}
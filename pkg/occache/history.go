@@ -0,0 +1,79 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package occache
+
+import (
+	"time"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// historyPolicy bounds the history kept per leaf by number of values and age.
+type historyPolicy struct {
+	depth int
+	age   time.Duration
+}
+
+func (p historyPolicy) enabled() bool {
+	return p.depth > 0 || p.age > 0
+}
+
+// expired returns true when the notification is older than the age bound.
+func (p historyPolicy) expired(v interface{}) bool {
+	if p.age <= 0 {
+		return false
+	}
+	n, ok := v.(*pb.Notification)
+	if !ok {
+		return true
+	}
+	return T(n.GetTimestamp()).Before(time.Now().Add(-p.age))
+}
+
+// History returns the retained notifications of the leaf at path, oldest first
+// and ending with the current value. It returns nil when path is not a leaf.
+func (t *Target) History(path []string) []*pb.Notification {
+	l := t.t.GetLeaf(path)
+	if l == nil {
+		return nil
+	}
+	cur, ok := l.Value().(*pb.Notification)
+	if !ok {
+		return nil
+	}
+	h := l.History()
+	ns := make([]*pb.Notification, 0, len(h)+1)
+	for _, v := range h {
+		if n, ok := v.(*pb.Notification); ok {
+			ns = append(ns, n)
+		}
+	}
+	return append(ns, cur)
+}
+
+// ValueAt returns the notification of the leaf at path which was current at
+// ts, based on the timestamps of the notifications. It returns nil when the
+// leaf did not exist at ts or when its value at ts is no longer retained.
+func (t *Target) ValueAt(path []string, ts time.Time) *pb.Notification {
+	h := t.History(path)
+	for i := len(h) - 1; i >= 0; i-- {
+		if !T(h[i].GetTimestamp()).After(ts) {
+			return h[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package occache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/gnmi/latency"
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+const (
+	segmentSuffix  = ".seg"
+	snapshotFile   = "snapshot"
+	recordHdrLen   = 12 // 8 byte timestamp + 4 byte length
+	defaultSegSize = 64 * 1024 * 1024
+)
+
+// A Journal is an append-only log of the notifications accepted by a target
+// cache. The log is split in segments, old segments can be compacted into a
+// snapshot. Every record holds the time it was journaled and the notification.
+type Journal struct {
+	dir     string
+	segSize int64
+
+	mu       sync.Mutex
+	seg      *os.File
+	segIdx   int
+	segLen   int64
+	snapshot time.Time // time of the last record folded into the snapshot
+}
+
+// OpenJournal opens the journal stored in dir or creates a new one. A new
+// segment is started on every open, a segment is rotated once it exceeds
+// segSize bytes.
+func OpenJournal(dir string, segSize int64) (*Journal, error) {
+	if segSize <= 0 {
+		segSize = defaultSegSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir:     dir,
+		segSize: segSize,
+	}
+	segs, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) > 0 {
+		j.segIdx = segs[len(segs)-1]
+		if err := j.truncateTorn(j.segIdx); err != nil {
+			return nil, err
+		}
+	}
+	if j.snapshot, err = j.readSnapshotTime(); err != nil {
+		return nil, err
+	}
+	if err := j.rotate(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Close closes the active segment of the journal.
+func (j *Journal) Close() error {
+	defer j.mu.Unlock()
+	j.mu.Lock()
+	if j.seg == nil {
+		return nil
+	}
+	err := j.seg.Close()
+	j.seg = nil
+	return err
+}
+
+// Append writes a notification to the journal with the given time.
+func (j *Journal) Append(ts time.Time, n *pb.Notification) error {
+	b, err := proto.Marshal(n)
+	if err != nil {
+		return err
+	}
+	defer j.mu.Unlock()
+	j.mu.Lock()
+	if j.seg == nil {
+		return errors.New("journal is closed")
+	}
+	if j.segLen >= j.segSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	l, err := writeRecord(j.seg, ts, b)
+	j.segLen += l
+	return err
+}
+
+// Compact folds all segments, except the active one, into the snapshot.
+func (j *Journal) Compact() error {
+	defer j.mu.Unlock()
+	j.mu.Lock()
+	segs, err := j.segments()
+	if err != nil {
+		return err
+	}
+	// the active segment is not compacted
+	segs = segs[:len(segs)-1]
+	if len(segs) == 0 {
+		return nil
+	}
+	t := newReplayTarget("")
+	last := j.snapshot
+	apply := func(ts time.Time, n *pb.Notification) error {
+		last = ts
+		return t.apply(n)
+	}
+	if err := j.readSnapshot(apply); err != nil {
+		return err
+	}
+	for _, idx := range segs {
+		if _, err := readFile(j.segmentPath(idx), apply); err != nil {
+			return err
+		}
+	}
+
+	tmp := filepath.Join(j.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := binary.Write(w, binary.BigEndian, last.UnixNano()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := t.t.WalkSorted(func(_ []string, _ *octree.Leaf, v interface{}) error {
+		b, err := proto.Marshal(v.(*pb.Notification))
+		if err != nil {
+			return err
+		}
+		_, err = writeRecord(w, last, b)
+		return err
+	}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(j.dir, snapshotFile)); err != nil {
+		return err
+	}
+	for _, idx := range segs {
+		if err := os.Remove(j.segmentPath(idx)); err != nil {
+			return err
+		}
+	}
+	j.snapshot = last
+	return nil
+}
+
+// replay calls fn for the snapshot and all journaled notifications up to and
+// including until.
+func (j *Journal) replay(until time.Time, fn func(time.Time, *pb.Notification) error) error {
+	defer j.mu.Unlock()
+	j.mu.Lock()
+	if until.Before(j.snapshot) {
+		return fmt.Errorf("journal is compacted up to %s", j.snapshot)
+	}
+	stop := errors.New("stop")
+	apply := func(ts time.Time, n *pb.Notification) error {
+		if ts.After(until) {
+			return stop
+		}
+		return fn(ts, n)
+	}
+	if err := j.readSnapshot(apply); err != nil {
+		return err
+	}
+	segs, err := j.segments()
+	if err != nil {
+		return err
+	}
+	for i, idx := range segs {
+		if _, err := readFile(j.segmentPath(idx), apply); err != nil {
+			if err == stop {
+				return nil
+			}
+			// a torn record at the end of the last segment is the end of
+			// the log
+			if err == io.ErrUnexpectedEOF && i == len(segs)-1 {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateTorn truncates the segment idx after its last complete record. A
+// torn record is left at the end of the segment when the process stopped in
+// the middle of an append.
+func (j *Journal) truncateTorn(idx int) error {
+	name := j.segmentPath(idx)
+	l, err := readFile(name, func(time.Time, *pb.Notification) error { return nil })
+	if err != io.ErrUnexpectedEOF {
+		return err
+	}
+	log.Warningf("journal %q truncated torn record at offset %d of segment %d", j.dir, l, idx)
+	return os.Truncate(name, l)
+}
+
+// rotate closes the active segment and starts a new one, the caller should
+// hold the lock.
+func (j *Journal) rotate() error {
+	if j.seg != nil {
+		if err := j.seg.Close(); err != nil {
+			return err
+		}
+	}
+	j.segIdx++
+	f, err := os.OpenFile(j.segmentPath(j.segIdx), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.seg = f
+	j.segLen = 0
+	return nil
+}
+
+func (j *Journal) segmentPath(idx int) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%020d%s", idx, segmentSuffix))
+}
+
+// segments returns the sorted indexes of the segments in the journal
+func (j *Journal) segments() ([]int, error) {
+	files, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	segs := make([]int, 0)
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		idx, err := strconv.Atoi(strings.TrimSuffix(f.Name(), segmentSuffix))
+		if err != nil {
+			continue
+		}
+		segs = append(segs, idx)
+	}
+	sort.Ints(segs)
+	return segs, nil
+}
+
+func (j *Journal) readSnapshotTime() (time.Time, error) {
+	f, err := os.Open(filepath.Join(j.dir, snapshotFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	defer f.Close()
+	var ts int64
+	if err := binary.Read(f, binary.BigEndian, &ts); err != nil {
+		return time.Time{}, err
+	}
+	return T(ts), nil
+}
+
+func (j *Journal) readSnapshot(fn func(time.Time, *pb.Notification) error) error {
+	f, err := os.Open(filepath.Join(j.dir, snapshotFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var ts int64
+	if err := binary.Read(r, binary.BigEndian, &ts); err != nil {
+		return err
+	}
+	_, err = readRecords(r, fn)
+	return err
+}
+
+func readFile(name string, fn func(time.Time, *pb.Notification) error) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return readRecords(bufio.NewReader(f), fn)
+}
+
+// readRecords calls fn for every record in r and returns the length of the
+// complete records read. A record which is cut short returns
+// io.ErrUnexpectedEOF.
+func readRecords(r io.Reader, fn func(time.Time, *pb.Notification) error) (int64, error) {
+	hdr := make([]byte, recordHdrLen)
+	var l int64
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return l, nil
+			}
+			return l, err
+		}
+		b := make([]byte, binary.BigEndian.Uint32(hdr[8:]))
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return l, err
+		}
+		n := &pb.Notification{}
+		if err := proto.Unmarshal(b, n); err != nil {
+			return l, err
+		}
+		if err := fn(T(int64(binary.BigEndian.Uint64(hdr[:8]))), n); err != nil {
+			return l, err
+		}
+		l += int64(recordHdrLen + len(b))
+	}
+}
+
+func writeRecord(w io.Writer, ts time.Time, b []byte) (int64, error) {
+	rec := make([]byte, recordHdrLen+len(b))
+	binary.BigEndian.PutUint64(rec[:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(rec[8:recordHdrLen], uint32(len(b)))
+	copy(rec[recordHdrLen:], b)
+	l, err := w.Write(rec)
+	return int64(l), err
+}
+
+// newReplayTarget returns a target which is not attached to a cache
+func newReplayTarget(name string) *Target {
+	return &Target{
+		t:      &octree.Tree{},
+		name:   name,
+		meta:   metadata.New(),
+		client: func(*octree.Leaf) {},
+		lat:    latency.New(nil, nil),
+	}
+}
+
+// apply applies a single journaled notification to the target
+func (t *Target) apply(n *pb.Notification) error {
+	if n.GetAtomic() {
+		_, err := t.gnmiAtomic(n, nil)
+		return err
+	}
+	if len(n.GetDelete()) > 0 {
+		t.gnmiRemove(n, nil)
+		return nil
+	}
+	_, err := t.gnmiUpdate(n, nil)
+	return err
+}
+
+// SetJournal sets the journal the accepted notifications of the target are
+// written to.
+func (t *Target) SetJournal(j *Journal) {
+	defer t.jmu.Unlock()
+	t.jmu.Lock()
+	t.journal = j
+}
+
+// Journal returns the journal of the target, nil if the target has no journal.
+func (t *Target) Journal() *Journal {
+	defer t.jmu.RUnlock()
+	t.jmu.RLock()
+	return t.journal
+}
+
+// Replay rebuilds the target from its journal with all notifications journaled
+// up to and including until. The returned target is not attached to a cache.
+func (t *Target) Replay(until time.Time) (*Target, error) {
+	j := t.Journal()
+	if j == nil {
+		return nil, fmt.Errorf("target %q has no journal", t.name)
+	}
+	rt := newReplayTarget(t.name)
+	if err := j.replay(until, func(_ time.Time, n *pb.Notification) error {
+		return rt.apply(n)
+	}); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// journalAppend writes n to the journal of the target if it has one.
+func (t *Target) journalAppend(n *pb.Notification) {
+	j := t.Journal()
+	if j == nil {
+		return
+	}
+	if err := j.Append(time.Now(), n); err != nil {
+		log.Errorf("target %q got error during journal append, %v", t.name, err)
+	}
+}
@@ -0,0 +1,167 @@
+package occache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func journalNoti(target string, ts int64, leaf, val string) *pb.Notification {
+	return &pb.Notification{
+		Timestamp: ts,
+		Prefix:    &pb.Path{Target: target},
+		Update: []*pb.Update{{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}, {Name: leaf}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: val}},
+		}},
+	}
+}
+
+func leafValue(t *Target, leaf string) string {
+	v := t.t.GetLeafValue([]string{"interface", "e1", leaf})
+	if v == nil {
+		return ""
+	}
+	return v.(*pb.Notification).GetUpdate()[0].GetVal().GetStringVal()
+}
+
+func TestJournalReplay(t *testing.T) {
+	target := "dev1"
+	c := New([]string{target}, WithJournal(t.TempDir(), 1))
+	tg := c.GetTarget(target)
+
+	if err := tg.GnmiUpdate(journalNoti(target, 1, "admin-state", "enable")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	t1 := time.Now()
+	time.Sleep(time.Millisecond)
+	if err := tg.GnmiUpdate(journalNoti(target, 2, "admin-state", "disable")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	if err := tg.GnmiUpdate(journalNoti(target, 3, "description", "uplink")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	// stale updates are not journaled
+	if err := tg.GnmiUpdate(journalNoti(target, 1, "admin-state", "enable")); err == nil {
+		t.Fatalf("GnmiUpdate: expected stale error")
+	}
+	t2 := time.Now()
+	time.Sleep(time.Millisecond)
+	if err := tg.GnmiUpdate(&pb.Notification{
+		Timestamp: 4,
+		Prefix:    &pb.Path{Target: target},
+		Delete:    []*pb.Path{{Elem: []*pb.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}, {Name: "description"}}}},
+	}); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+
+	tests := []struct {
+		until       time.Time
+		adminState  string
+		description string
+	}{
+		{until: t1, adminState: "enable"},
+		{until: t2, adminState: "disable", description: "uplink"},
+		{until: time.Now(), adminState: "disable"},
+	}
+	for i, tt := range tests {
+		rt, err := tg.Replay(tt.until)
+		if err != nil {
+			t.Fatalf("Replay %d: %v", i, err)
+		}
+		if got := leafValue(rt, "admin-state"); got != tt.adminState {
+			t.Errorf("Replay %d: admin-state got %q, want %q", i, got, tt.adminState)
+		}
+		if got := leafValue(rt, "description"); got != tt.description {
+			t.Errorf("Replay %d: description got %q, want %q", i, got, tt.description)
+		}
+	}
+
+	// the segment size of 1 byte rotates the segment on every append
+	if err := tg.Journal().Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if _, err := tg.Replay(t1); err == nil {
+		t.Errorf("Replay: expected error before the snapshot time")
+	}
+	rt, err := tg.Replay(time.Now())
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if got := leafValue(rt, "admin-state"); got != "disable" {
+		t.Errorf("Replay after Compact: admin-state got %q, want %q", got, "disable")
+	}
+	if got := leafValue(rt, "description"); got != "" {
+		t.Errorf("Replay after Compact: description got %q, want deleted", got)
+	}
+	c.Remove(target)
+}
+
+func TestJournalTornRecord(t *testing.T) {
+	target := "dev1"
+	dir := t.TempDir()
+	j, err := OpenJournal(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	tg := newReplayTarget(target)
+	tg.SetJournal(j)
+	if err := tg.GnmiUpdate(journalNoti(target, 1, "admin-state", "enable")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	if err := tg.GnmiUpdate(journalNoti(target, 2, "description", "uplink")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	seg := j.segmentPath(j.segIdx)
+	fi, err := os.Stat(seg)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	complete := fi.Size()
+
+	// a record of which only the header and part of the body are written
+	if err := tg.GnmiUpdate(journalNoti(target, 3, "admin-state", "disable")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	if err := os.Truncate(seg, complete+recordHdrLen+2); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	rt, err := tg.Replay(time.Now())
+	if err != nil {
+		t.Fatalf("Replay with torn record: %v", err)
+	}
+	if got := leafValue(rt, "admin-state"); got != "enable" {
+		t.Errorf("Replay: admin-state got %q, want %q", got, "enable")
+	}
+	if got := leafValue(rt, "description"); got != "uplink" {
+		t.Errorf("Replay: description got %q, want %q", got, "uplink")
+	}
+
+	// reopening the journal truncates the torn record
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if j, err = OpenJournal(dir, 0); err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	defer j.Close()
+	if fi, err = os.Stat(seg); err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Size() != complete {
+		t.Errorf("OpenJournal: segment size got %d, want %d", fi.Size(), complete)
+	}
+	tg.SetJournal(j)
+	if err := tg.GnmiUpdate(journalNoti(target, 4, "admin-state", "disable")); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	if rt, err = tg.Replay(time.Now()); err != nil {
+		t.Fatalf("Replay after reopen: %v", err)
+	}
+	if got := leafValue(rt, "admin-state"); got != "disable" {
+		t.Errorf("Replay after reopen: admin-state got %q, want %q", got, "disable")
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package occache
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+const (
+	// EvictCount is the total number of leaves evicted to keep the target
+	// within its quota.
+	EvictCount = "targetLeavesEvicted"
+	// RejectCount is the total number of leaf updates rejected because they
+	// exceed the quota of the target.
+	RejectCount = "targetLeavesRejected"
+	// QuotaSize is the number of bytes accounted against the quota of the
+	// target, the size of a leaf is the size of its marshaled notification.
+	QuotaSize = "targetQuotaSize"
+)
+
+func init() {
+	for _, v := range []string{EvictCount, RejectCount, QuotaSize} {
+		metadata.RegisterIntValue(v, &metadata.IntValue{Path: []string{metadata.Root, v}, InitZero: true})
+	}
+}
+
+// ErrQuotaExceeded is returned for updates which exceed the quota of a target.
+var ErrQuotaExceeded = errors.New("target quota exceeded")
+
+// QuotaPolicy defines what happens with updates exceeding the quota.
+type QuotaPolicy int
+
+const (
+	// QuotaReject rejects the updates exceeding the quota.
+	QuotaReject QuotaPolicy = iota
+	// QuotaEvictOldest evicts the leaves with the oldest timestamp to make
+	// room for the update, updates are rejected when nothing can be evicted.
+	QuotaEvictOldest
+)
+
+// Quota bounds the number of leaves and bytes of a target, a bound of 0 is
+// unlimited.
+type Quota struct {
+	MaxLeaves int64
+	MaxBytes  int64
+	Policy    QuotaPolicy
+	// Evictable are the subtrees from which leaves are evicted, all leaves of
+	// the target are evictable when empty.
+	Evictable [][]string
+}
+
+func (q Quota) enabled() bool {
+	return q.MaxLeaves > 0 || q.MaxBytes > 0
+}
+
+// quotaUsage is the usage of a target accounted against its quota.
+type quotaUsage struct {
+	mu     sync.Mutex
+	leaves int64
+	bytes  int64
+	// age orders the evictable leaves from old to new
+	age ageIndex
+	seq uint64
+}
+
+// size returns the number of bytes accounted for a leaf value, 0 when the
+// quota does not limit bytes.
+func (t *Target) size(v interface{}) int64 {
+	if t.quota.MaxBytes <= 0 {
+		return 0
+	}
+	n, ok := v.(*pb.Notification)
+	if !ok {
+		return 0
+	}
+	return int64(proto.Size(n))
+}
+
+func (t *Target) exceeds(dl, db int64) bool {
+	q := t.quota
+	return (q.MaxLeaves > 0 && dl > 0 && t.usage.leaves+dl > q.MaxLeaves) ||
+		(q.MaxBytes > 0 && db > 0 && t.usage.bytes+db > q.MaxBytes)
+}
+
+// reserve accounts dl leaves and db bytes which are about to be written at
+// path, evicting other leaves if the policy allows it. The clients are
+// notified of the evicted leaves after the usage lock is released.
+func (t *Target) reserve(path []string, dl, db int64) error {
+	if !t.quota.enabled() {
+		return nil
+	}
+	evicted, err := t.reserveLocked(path, dl, db)
+	for _, l := range evicted {
+		t.client(l)
+	}
+	return err
+}
+
+func (t *Target) reserveLocked(path []string, dl, db int64) ([]*octree.Leaf, error) {
+	defer t.usage.mu.Unlock()
+	t.usage.mu.Lock()
+	var evicted []*octree.Leaf
+	if t.exceeds(dl, db) {
+		// configuration is never evicted
+		if !t.config && t.quota.Policy == QuotaEvictOldest {
+			evicted = t.evict(path, dl, db)
+		}
+		if t.exceeds(dl, db) {
+			t.meta.AddInt(RejectCount, 1)
+			return evicted, ErrQuotaExceeded
+		}
+	}
+	t.usage.leaves += dl
+	t.usage.bytes += db
+	t.meta.SetInt(QuotaSize, t.usage.bytes)
+	return evicted, nil
+}
+
+// release returns dl leaves and db bytes to the quota.
+func (t *Target) release(dl, db int64) {
+	if !t.quota.enabled() {
+		return
+	}
+	defer t.usage.mu.Unlock()
+	t.usage.mu.Lock()
+	t.usage.leaves -= dl
+	t.usage.bytes -= db
+	t.meta.SetInt(QuotaSize, t.usage.bytes)
+}
+
+// resetUsage clears the usage after the target data is cleared.
+func (t *Target) resetUsage() {
+	defer t.usage.mu.Unlock()
+	t.usage.mu.Lock()
+	t.usage.leaves = 0
+	t.usage.bytes = 0
+	t.usage.age = nil
+}
+
+type evictCandidate struct {
+	path []string
+	n    *pb.Notification
+	seq  uint64
+}
+
+// ageIndex is a heap of the evictable leaves ordered by timestamp and, for
+// equal timestamps, by the order in which they were written. Leaves are not
+// removed from the index when they are updated or deleted, such a candidate
+// no longer holds the value of the leaf and is skipped when it is evicted.
+type ageIndex []*evictCandidate
+
+func (a ageIndex) Len() int { return len(a) }
+
+func (a ageIndex) Less(i, j int) bool {
+	if a[i].n.GetTimestamp() != a[j].n.GetTimestamp() {
+		return a[i].n.GetTimestamp() < a[j].n.GetTimestamp()
+	}
+	return a[i].seq < a[j].seq
+}
+
+func (a ageIndex) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a *ageIndex) Push(x interface{}) { *a = append(*a, x.(*evictCandidate)) }
+
+func (a *ageIndex) Pop() interface{} {
+	old := *a
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*a = old[:len(old)-1]
+	return c
+}
+
+// evictable returns true if the leaf at path can be evicted by the quota.
+func (t *Target) evictable(path []string) bool {
+	if !t.quota.enabled() || t.quota.Policy != QuotaEvictOldest || t.config || len(path) == 0 || path[0] == metadata.Root {
+		return false
+	}
+	if len(t.quota.Evictable) == 0 {
+		return true
+	}
+	for _, st := range t.quota.Evictable {
+		if matchSubtree(st, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSubtree returns true if path is in the subtree st, a "*" element of st
+// matches any element.
+func matchSubtree(st, path []string) bool {
+	if len(path) < len(st) {
+		return false
+	}
+	for i, e := range st {
+		if e != "*" && e != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// track adds the value n written at path to the age index of the evictable
+// leaves.
+func (t *Target) track(path []string, n *pb.Notification) {
+	if !t.evictable(path) {
+		return
+	}
+	defer t.usage.mu.Unlock()
+	t.usage.mu.Lock()
+	t.usage.seq++
+	heap.Push(&t.usage.age, &evictCandidate{path: append([]string{}, path...), n: n, seq: t.usage.seq})
+	// drop the stale candidates once they outnumber the leaves, this keeps
+	// the index linear in the number of leaves at an amortized constant cost
+	if int64(len(t.usage.age)) > 2*t.usage.leaves+64 {
+		t.compactAge()
+	}
+}
+
+// compactAge removes the candidates which no longer hold the value of their
+// leaf from the age index. The caller should hold the usage lock.
+func (t *Target) compactAge() {
+	age := t.usage.age[:0]
+	for _, c := range t.usage.age {
+		if t.t.GetLeafValue(c.path) == c.n {
+			age = append(age, c)
+		}
+	}
+	for i := len(age); i < len(t.usage.age); i++ {
+		t.usage.age[i] = nil
+	}
+	t.usage.age = age
+	heap.Init(&t.usage.age)
+}
+
+// evict deletes the oldest evictable leaves, except the one at path, until dl
+// leaves and db bytes fit in the quota, and returns the delete notifications of
+// the evicted leaves. The caller should hold the usage lock.
+func (t *Target) evict(path []string, dl, db int64) []*octree.Leaf {
+	var skipped []*evictCandidate
+	var evicted []*octree.Leaf
+	for t.exceeds(dl, db) && len(t.usage.age) != 0 {
+		c := heap.Pop(&t.usage.age).(*evictCandidate)
+		if equalPath(c.path, path) {
+			skipped = append(skipped, c)
+			continue
+		}
+		// only delete the leaf if it was not updated in the meantime
+		if len(t.t.DeleteConditional(c.path, func(v interface{}) bool { return v == c.n })) == 0 {
+			continue
+		}
+		t.usage.leaves--
+		t.usage.bytes -= t.size(c.n)
+		t.meta.AddInt(metadata.LeafCount, -1)
+		t.meta.AddInt(EvictCount, 1)
+		// the delete is later than the leaf, a replay of the journal only
+		// removes leaves older than the delete
+		d := &pb.Path{Elem: leafElems(c.n)}
+		if len(d.Elem) == 0 {
+			d = &pb.Path{Element: c.path}
+		}
+		noti := &pb.Notification{
+			Timestamp: c.n.GetTimestamp() + 1,
+			Prefix:    &pb.Path{Target: t.name, Origin: c.n.GetPrefix().GetOrigin()},
+			Delete:    []*pb.Path{d},
+		}
+		t.journalAppend(noti)
+		evicted = append(evicted, octree.DetachedLeaf(noti))
+	}
+	for _, c := range skipped {
+		heap.Push(&t.usage.age, c)
+	}
+	return evicted
+}
+
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package occache
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/octree"
+)
+
+func quotaNoti(target string, ts int64, root, name string) *pb.Notification {
+	return &pb.Notification{
+		Timestamp: ts,
+		Prefix:    &pb.Path{Target: target},
+		Update: []*pb.Update{{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: root, Key: map[string]string{"name": name}}, {Name: "value"}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}},
+	}
+}
+
+func TestQuota(t *testing.T) {
+	target := "dev1"
+	tests := []struct {
+		name    string
+		opts    []Option
+		expErr  []bool
+		expLeaf map[string]bool
+		evicted int64
+		reject  int64
+	}{
+		{
+			name:    "reject",
+			opts:    []Option{WithQuota(Quota{MaxLeaves: 2})},
+			expErr:  []bool{false, false, true, true},
+			expLeaf: map[string]bool{"a": true, "b": true, "c": false, "d": false},
+			reject:  2,
+		},
+		{
+			name:    "evict oldest",
+			opts:    []Option{WithQuota(Quota{MaxLeaves: 2, Policy: QuotaEvictOldest})},
+			expErr:  []bool{false, false, false, false},
+			expLeaf: map[string]bool{"a": false, "b": false, "c": true, "d": true},
+			evicted: 2,
+		},
+		{
+			// the oldest leaf is not evictable as it is not in the state subtree
+			name:    "evict subtree",
+			opts:    []Option{WithQuota(Quota{MaxLeaves: 2, Policy: QuotaEvictOldest, Evictable: [][]string{{"state"}}})},
+			expErr:  []bool{false, false, false, false},
+			expLeaf: map[string]bool{"a": true, "b": false, "c": false, "d": true},
+			evicted: 2,
+		},
+		{
+			name:    "config",
+			opts:    []Option{WithQuota(Quota{MaxLeaves: 2, Policy: QuotaEvictOldest}), WithConfig()},
+			expErr:  []bool{false, false, true, true},
+			expLeaf: map[string]bool{"a": true, "b": true, "c": false, "d": false},
+			reject:  2,
+		},
+	}
+	for _, tt := range tests {
+		c := New([]string{target}, tt.opts...)
+		tg := c.GetTarget(target)
+		for i, n := range []*pb.Notification{
+			quotaNoti(target, 1, "config", "a"),
+			quotaNoti(target, 2, "state", "b"),
+			quotaNoti(target, 3, "state", "c"),
+			quotaNoti(target, 4, "config", "d"),
+		} {
+			if err := tg.GnmiUpdate(n); (err != nil) != tt.expErr[i] {
+				t.Errorf("%s: GnmiUpdate %d: got error %v, want error %t", tt.name, i, err, tt.expErr[i])
+			}
+		}
+		for name, exp := range tt.expLeaf {
+			var found bool
+			for _, root := range []string{"config", "state"} {
+				if tg.t.GetLeafValue([]string{root, name, "value"}) != nil {
+					found = true
+				}
+			}
+			if found != exp {
+				t.Errorf("%s: leaf %s: got %t, want %t", tt.name, name, found, exp)
+			}
+		}
+		if v, _ := tg.meta.GetInt(EvictCount); v != tt.evicted {
+			t.Errorf("%s: evicted got %d, want %d", tt.name, v, tt.evicted)
+		}
+		if v, _ := tg.meta.GetInt(RejectCount); v != tt.reject {
+			t.Errorf("%s: rejected got %d, want %d", tt.name, v, tt.reject)
+		}
+	}
+}
+
+func TestQuotaBytes(t *testing.T) {
+	target := "dev1"
+	n := quotaNoti(target, 1, "state", "a")
+	c := New([]string{target}, WithQuota(Quota{MaxBytes: 2 * int64(len(n.String()))}))
+	tg := c.GetTarget(target)
+	if err := tg.GnmiUpdate(n); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	size, _ := tg.meta.GetInt(QuotaSize)
+	if size == 0 {
+		t.Fatalf("quota size not accounted")
+	}
+	// deleting the leaf returns its bytes to the quota
+	if err := tg.GnmiUpdate(&pb.Notification{
+		Timestamp: 2,
+		Prefix:    &pb.Path{Target: target},
+		Delete:    []*pb.Path{{Elem: []*pb.PathElem{{Name: "state"}}}},
+	}); err != nil {
+		t.Fatalf("GnmiUpdate: %v", err)
+	}
+	if v, _ := tg.meta.GetInt(QuotaSize); v != 0 {
+		t.Errorf("quota size after delete got %d, want 0", v)
+	}
+}
+
+func TestQuotaEvictReplay(t *testing.T) {
+	target := "dev1"
+	c := New([]string{target}, WithQuota(Quota{MaxLeaves: 1, Policy: QuotaEvictOldest}), WithJournal(t.TempDir(), 1))
+	tg := c.GetTarget(target)
+	var deletes int
+	c.SetClient(func(l *octree.Leaf) {
+		if n, ok := l.Value().(*pb.Notification); ok {
+			deletes += len(n.GetDelete())
+		}
+	})
+	for _, n := range []*pb.Notification{
+		quotaNoti(target, 1, "state", "a"),
+		quotaNoti(target, 2, "state", "b"),
+	} {
+		if err := tg.GnmiUpdate(n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+	if deletes != 1 {
+		t.Errorf("client got %d deletes, want 1", deletes)
+	}
+	rt, err := tg.Replay(time.Now())
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	// the replayed target matches the live target
+	for name, exp := range map[string]bool{"a": false, "b": true} {
+		for _, tgt := range []*Target{tg, rt} {
+			if got := tgt.t.GetLeafValue([]string{"state", name, "value"}) != nil; got != exp {
+				t.Errorf("leaf %s: got %t, want %t", name, got, exp)
+			}
+		}
+	}
+}
+
+func TestQuotaEvictAge(t *testing.T) {
+	target := "dev1"
+	c := New([]string{target}, WithQuota(Quota{MaxLeaves: 3, Policy: QuotaEvictOldest}))
+	tg := c.GetTarget(target)
+	for _, n := range []*pb.Notification{
+		quotaNoti(target, 1, "state", "a"),
+		quotaNoti(target, 2, "state", "b"),
+		quotaNoti(target, 3, "state", "c"),
+		// the update of a makes b the oldest leaf
+		quotaNoti(target, 4, "state", "a"),
+		// the deleted c is not evicted
+		{Timestamp: 5, Prefix: &pb.Path{Target: target}, Delete: []*pb.Path{{Elem: []*pb.PathElem{{Name: "state", Key: map[string]string{"name": "c"}}}}}},
+		quotaNoti(target, 6, "state", "d"),
+		quotaNoti(target, 7, "state", "e"),
+	} {
+		if err := tg.GnmiUpdate(n); err != nil {
+			t.Fatalf("GnmiUpdate: %v", err)
+		}
+	}
+	for name, exp := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "e": true} {
+		if found := tg.t.GetLeafValue([]string{"state", name, "value"}) != nil; found != exp {
+			t.Errorf("leaf %s: got %t, want %t", name, found, exp)
+		}
+	}
+	if v, _ := tg.meta.GetInt(EvictCount); v != 1 {
+		t.Errorf("evicted got %d, want 1", v)
+	}
+}
+
+func BenchmarkGnmiUpdateEvict(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tg := New([]string{"dev1"}, WithQuota(Quota{MaxLeaves: 500, Policy: QuotaEvictOldest})).GetTarget("dev1")
+		ns := benchmarkNotis("dev1", 100, 50)
+		b.StartTimer()
+		for _, n := range ns {
+			tg.GnmiUpdate(n)
+		}
+	}
+}
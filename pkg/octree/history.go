@@ -0,0 +1,61 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package octree
+
+// UpdateWithHistory sets the value of this Leaf to val and appends the replaced
+// value to the history of the leaf. At most depth values are kept, a depth of 0
+// keeps all values. A value is removed from the history once the value which
+// replaced it is expired, expired can be nil in which case values never expire.
+func (l *Leaf) UpdateWithHistory(val interface{}, depth int, expired func(interface{}) bool) {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	h := append(l.history, l.leafBranch)
+	if expired != nil {
+		// the most recent expired value is kept since it was still the value of
+		// the leaf at the start of the retention window
+		i := 0
+		for ; i < len(h); i++ {
+			next := val
+			if i+1 < len(h) {
+				next = h[i+1]
+			}
+			if !expired(next) {
+				break
+			}
+		}
+		h = h[i:]
+	}
+	if depth > 0 && len(h) > depth {
+		h = h[len(h)-depth:]
+	}
+	// copy to release the backing array of the removed values
+	l.history = append(make([]interface{}, 0, len(h)), h...)
+	l.leafBranch = val
+}
+
+// History returns the values this leaf held before its current value, oldest
+// first. History is safe to call on nil Leaf.
+func (l *Leaf) History() []interface{} {
+	if l == nil {
+		return nil
+	}
+	defer l.mu.RUnlock()
+	l.mu.RLock()
+	h := make([]interface{}, len(l.history))
+	copy(h, l.history)
+	return h
+}
@@ -35,6 +35,9 @@ type Tree struct {
 	mu sync.RWMutex
 	// Each node is either a leaf or a branch.
 	leafBranch interface{}
+	// history holds the values a leaf held before its current value, oldest
+	// first, it is only kept for leaves updated with UpdateWithHistory.
+	history []interface{}
 }
 
 // Leaf is a Tree node that represents a leaf.
@@ -237,6 +240,24 @@ func (t *Tree) Add(path []string, value interface{}) error {
 	return t.intermediateAdd(path, value)
 }
 
+// AddBatch adds the values to the Tree at the paths under a single acquisition
+// of the write lock of t, the paths must not be empty. The error of every
+// value is returned at its index, nil if the value was added. Concurrent
+// readers and writers of t wait for the whole batch.
+func (t *Tree) AddBatch(paths [][]string, values []interface{}) []error {
+	defer t.mu.Unlock()
+	t.mu.Lock()
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		if len(path) == 0 {
+			errs[i] = fmt.Errorf("AddBatch attempted to add value %#v at an empty path", values[i])
+			continue
+		}
+		errs[i] = t.slowAdd(path, values[i])
+	}
+	return errs
+}
+
 // Get returns the Tree node if path points to it, nil otherwise.
 // All nodes in path must be fully specified with no globbing (*).
 func (t *Tree) Get(path []string) *Tree {
@@ -278,13 +299,13 @@ func (t *Tree) GetLeaf(path []string) *Leaf {
 // return the supplied error.
 type VisitFunc func(path []string, l *Leaf, val interface{}) error
 
-func (t *Tree) enumerateChildren(prefix, path []string, f VisitFunc) error {
+func (t *Tree) enumerateChildren(prefix, path []string, keep KeepFunc, f VisitFunc) error {
 	// Caller should hold a read lock on t.
 	if n := len(path); n == 0 || (n == 1 && path[0] == "*") {
 		switch b := t.leafBranch.(type) {
 		case branch:
 			for k, br := range b {
-				if err := br.queryInternal(append(prefix, k), nil, f); err != nil {
+				if err := br.queryInternal(append(prefix, k), nil, keep, f); err != nil {
 					return err
 				}
 			}
@@ -296,7 +317,7 @@ func (t *Tree) enumerateChildren(prefix, path []string, f VisitFunc) error {
 	}
 	if b, ok := t.leafBranch.(branch); ok {
 		for k, br := range b {
-			if err := br.queryInternal(append(prefix, k), path[1:], f); err != nil {
+			if err := br.queryInternal(append(prefix, k), path[1:], keep, f); err != nil {
 				return err
 			}
 		}
@@ -309,18 +330,66 @@ func (t *Tree) enumerateChildren(prefix, path []string, f VisitFunc) error {
 // are passed to f as they are found in the Tree. No ordering of paths is
 // guaranteed.
 func (t *Tree) Query(path []string, f VisitFunc) error {
-	return t.queryInternal(nil, path, f)
+	return t.queryInternal(nil, path, nil, f)
+}
+
+// KeepFunc is called by QueryFiltered for every node it visits, before the
+// node is visited. The node is skipped when it returns false, for a branch
+// this skips the whole subtree. The node n is only valid during the call.
+type KeepFunc func(path []string, n QueryNode) bool
+
+// QueryNode is a node visited by QueryFiltered, the node is locked by the
+// query.
+type QueryNode struct {
+	t *Tree
+}
+
+// IsLeaf returns true if the node is a leaf.
+func (n QueryNode) IsLeaf() bool {
+	return n.t.leafBranch != nil && !n.t.isBranch()
+}
+
+// GetLeafValue returns the value of the leaf at path below the node, or of the
+// leaf holding path when a leaf is found before the end of path, nil
+// otherwise.
+func (n QueryNode) GetLeafValue(path []string) interface{} {
+	b, ok := n.t.leafBranch.(branch)
+	if !ok {
+		return n.t.leafBranch
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	return b[path[0]].lookup(path[1:])
+}
+
+func (t *Tree) lookup(path []string) interface{} {
+	if t == nil {
+		return nil
+	}
+	defer t.mu.RUnlock()
+	t.mu.RLock()
+	return QueryNode{t: t}.GetLeafValue(path)
 }
 
-func (t *Tree) queryInternal(prefix, path []string, f VisitFunc) error {
+// QueryFiltered calls f for all leaves that match a given query like Query,
+// the nodes for which keep returns false are not visited.
+func (t *Tree) QueryFiltered(path []string, keep KeepFunc, f VisitFunc) error {
+	return t.queryInternal(nil, path, keep, f)
+}
+
+func (t *Tree) queryInternal(prefix, path []string, keep KeepFunc, f VisitFunc) error {
 	defer t.mu.RUnlock()
 	t.mu.RLock()
+	if keep != nil && !keep(prefix, QueryNode{t: t}) {
+		return nil
+	}
 	if len(path) == 0 || path[0] == "*" {
-		return t.enumerateChildren(prefix, path, f)
+		return t.enumerateChildren(prefix, path, keep, f)
 	}
 	if b, ok := t.leafBranch.(branch); ok {
 		if br := b[path[0]]; br != nil {
-			return br.queryInternal(append(prefix, path[0]), path[1:], f)
+			return br.queryInternal(append(prefix, path[0]), path[1:], keep, f)
 		}
 	}
 	return nil
@@ -414,7 +483,7 @@ func (t *Tree) internalDelete(subpath []string, condition func(interface{}) bool
 			if condition(t.leafBranch) {
 				// The second parameter is an empty path that will be filled as recursion
 				// unwinds for this leaf that will be deleted in its parent.
-				return true, [][]string{}
+				return true, [][]string{nil}
 			}
 			return false, [][]string{}
 		}
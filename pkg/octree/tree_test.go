@@ -0,0 +1,99 @@
+package octree
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   []string
+		leaves []string
+	}{
+		{name: "leaf", path: []string{"a", "b", "c"}, leaves: []string{"a/b/c"}},
+		{name: "branch", path: []string{"a", "b"}, leaves: []string{"a/b/c", "a/b/d"}},
+		{name: "wildcard", path: []string{"a", "*", "c"}, leaves: []string{"a/b/c", "a/e/c"}},
+		{name: "root", path: []string{}, leaves: []string{"a/b/c", "a/b/d", "a/e/c"}},
+		{name: "not found", path: []string{"a", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Tree{}
+			for _, p := range [][]string{{"a", "b", "c"}, {"a", "b", "d"}, {"a", "e", "c"}} {
+				if err := tr.Add(p, 1); err != nil {
+					t.Fatalf("Add %v: %v", p, err)
+				}
+			}
+			var leaves []string
+			for _, l := range tr.Delete(tt.path) {
+				leaves = append(leaves, strings.Join(l, "/"))
+			}
+			sort.Strings(leaves)
+			if !reflect.DeepEqual(leaves, tt.leaves) {
+				t.Errorf("Delete %v: got %v, want %v", tt.path, leaves, tt.leaves)
+			}
+			for _, l := range tt.leaves {
+				if tr.GetLeaf(strings.Split(l, "/")) != nil {
+					t.Errorf("Delete %v: leaf %s not deleted", tt.path, l)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryFiltered(t *testing.T) {
+	tr := &Tree{}
+	for _, p := range [][]string{{"a", "b", "c"}, {"a", "b", "d"}, {"a", "e", "c"}, {"a", "e", "f", "g"}} {
+		if err := tr.Add(p, 1); err != nil {
+			t.Fatalf("Add %v: %v", p, err)
+		}
+	}
+	var walked, leaves []string
+	if err := tr.QueryFiltered([]string{"a", "*"},
+		func(path []string, n QueryNode) bool {
+			walked = append(walked, strings.Join(path, "/"))
+			// skip the branch b and the leaf c
+			return !(len(path) == 2 && path[1] == "b") && !(n.IsLeaf() && path[len(path)-1] == "c")
+		},
+		func(path []string, _ *Leaf, _ interface{}) error {
+			leaves = append(leaves, strings.Join(path, "/"))
+			return nil
+		}); err != nil {
+		t.Fatalf("QueryFiltered: %v", err)
+	}
+	sort.Strings(walked)
+	if exp := []string{"", "a", "a/b", "a/e", "a/e/c", "a/e/f", "a/e/f/g"}; !reflect.DeepEqual(walked, exp) {
+		t.Errorf("QueryFiltered: walked %v, want %v", walked, exp)
+	}
+	if exp := []string{"a/e/f/g"}; !reflect.DeepEqual(leaves, exp) {
+		t.Errorf("QueryFiltered: got %v, want %v", leaves, exp)
+	}
+}
+
+func TestQueryNodeGetLeafValue(t *testing.T) {
+	tr := &Tree{}
+	for p, v := range map[string]int{"a/b/c": 1, "a/d": 2} {
+		if err := tr.Add(strings.Split(p, "/"), v); err != nil {
+			t.Fatalf("Add %v: %v", p, err)
+		}
+	}
+	got := make(map[string]interface{})
+	tr.QueryFiltered([]string{"a"},
+		func(path []string, n QueryNode) bool {
+			if len(path) == 1 {
+				for _, l := range []string{"b/c", "d/e", "x"} {
+					got[l] = n.GetLeafValue(strings.Split(l, "/"))
+				}
+			}
+			return true
+		},
+		func([]string, *Leaf, interface{}) error { return nil })
+	// the leaf d holds the path d/e
+	exp := map[string]interface{}{"b/c": 1, "d/e": 2, "x": nil}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("GetLeafValue: got %v, want %v", got, exp)
+	}
+}
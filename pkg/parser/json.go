@@ -29,6 +29,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/wI2L/jsondiff"
 	"github.com/yndd/ndd-runtime/pkg/utils"
+	"github.com/yndd/ndd-yang/pkg/tracing"
 )
 
 const (
@@ -308,6 +309,8 @@ func (p *Parser) CleanCacheValueForComparisonGnmi(path *gnmi.Path, cacheValue in
 // in tc since tc is a global conext
 // NOTE2: ConfigResolveLeafRef is a run to completion and hsould not find a return in the path until the end
 // NOTE3: all other actions are returning something based on the path they traverse
+//
+// Deprecated: use the jtree package.
 func (p *Parser) ParseTreeWithActionGnmi(x1 interface{}, tc *TraceCtxtGnmi, idx, lridx int) interface{} {
 	// idx is a local counter that will stay local, after the recurssive function calls it remains the same
 	// tc.Idx is a global index used for tracing to trace, after a recursive function it will change if the recursive function changed it
@@ -1010,8 +1013,11 @@ func (p *Parser) PopulateRemoteLeafRefKeyGnmi(rlref *ResolvedLeafRefGnmi) {
 // GetUpdatesFromJSONData returns config.Updates based on the JSON input data and config.Path/reference Paths
 // These updates are used prepared so they can be send to a GNMI capable device
 func (p *Parser) GetUpdatesFromJSONDataGnmi(rootPath, path *gnmi.Path, x1 interface{}, refPaths []*gnmi.Path) []*gnmi.Update {
+	span := p.startSpan("parser.GetUpdatesFromJSONData", tracing.Path(tracing.AttrPath, path))
+	defer span.End()
 	updates := make([]*gnmi.Update, 0)
-	tc := &TraceCtxtGnmi{}
+	// the trace messages are recorded as events of the span
+	tc := &TraceCtxtGnmi{Path: path, Span: span}
 	updates, tc = p.ParseJSONData2ConfigUpdatesGnmi(tc, path, x1, 0, updates, refPaths)
 	if p.log != nil {
 		p.log.Debug("GetUpdatesFromJSONData", "Updates", updates, "Trace Msg", tc.Msg)
@@ -1021,6 +1027,7 @@ func (p *Parser) GetUpdatesFromJSONDataGnmi(rootPath, path *gnmi.Path, x1 interf
 	if p.log != nil {
 		p.log.Debug("GetUpdatesFromJSONData", "Updates", updates)
 	}
+	span.SetAttributes(tracing.Int(tracing.AttrCount, len(updates)))
 	//fmt.Printf("GetUpdatesFromJSONDataGnmi Updates %v, Trace Msg %v\n", updates, tc.Msg)
 	return updates
 }
@@ -1029,28 +1036,28 @@ func (p *Parser) GetUpdatesFromJSONDataGnmi(rootPath, path *gnmi.Path, x1 interf
 func (p *Parser) ParseJSONData2ConfigUpdatesGnmi(tc *TraceCtxtGnmi, path *gnmi.Path, x1 interface{}, idx int, updates []*gnmi.Update, refPaths []*gnmi.Path) ([]*gnmi.Update, *TraceCtxtGnmi) {
 	// this is a recursive function which parses all the data till the end, hence return is only at the end
 	updateValue := false
-	tc.Msg = append(tc.Msg, fmt.Sprintf("entry, idx: %d", idx))
+	tc.AddMsg(fmt.Sprintf("entry, idx: %d", idx))
 	switch x := x1.(type) {
 	case map[string]interface{}:
-		tc.Msg = append(tc.Msg, "map[string]interface{}")
+		tc.AddMsg("map[string]interface{}")
 		value := make(map[string]interface{})
 		for k, v := range x {
 			if v != nil {
-				tc.Msg = append(tc.Msg, fmt.Sprintf("type: %v\n", reflect.TypeOf(v)))
+				tc.AddMsg(fmt.Sprintf("type: %v", reflect.TypeOf(v)))
 			} else {
-				tc.Msg = append(tc.Msg, "nil")
+				tc.AddMsg("nil")
 			}
 
-			tc.Msg = append(tc.Msg, fmt.Sprintf("k: %s, v: %v\n", k, v))
+			tc.AddMsg(fmt.Sprintf("k: %s, v: %v", k, v))
 			switch x1 := v.(type) {
 			case []interface{}:
-				tc.Msg = append(tc.Msg, "[]interface{}")
+				tc.AddMsg("[]interface{}")
 				// a list with a key, for each list entry we create a new path with its dedicated keys
 				for i, vv := range x1 {
 					if v != nil {
-						tc.Msg = append(tc.Msg, fmt.Sprintf("type: %v, i: %d\n", reflect.TypeOf(v), i))
+						tc.AddMsg(fmt.Sprintf("type: %v, i: %d", reflect.TypeOf(v), i))
 					} else {
-						tc.Msg = append(tc.Msg, fmt.Sprintf("type: nil, i: %d\n", i))
+						tc.AddMsg(fmt.Sprintf("type: nil, i: %d", i))
 					}
 					newPath := p.DeepCopyGnmiPath(path)
 					keys := p.GetKeyNamesFromGnmiPaths(newPath, k, refPaths)
@@ -1078,9 +1085,9 @@ func (p *Parser) ParseJSONData2ConfigUpdatesGnmi(tc *TraceCtxtGnmi, path *gnmi.P
 				updates, tc = p.ParseJSONData2ConfigUpdatesGnmi(tc, newPath, x1, idx+1, updates, refPaths)
 				//return updates
 			case nil:
-				tc.Msg = append(tc.Msg, "nil")
+				tc.AddMsg("nil")
 			default:
-				tc.Msg = append(tc.Msg, "default")
+				tc.AddMsg("default")
 				// string, other types
 				// we are at the end of the path
 				value[k] = v
@@ -1123,7 +1130,7 @@ func (p *Parser) ParseJSONData2ConfigUpdatesGnmi(tc *TraceCtxtGnmi, path *gnmi.P
 		}
 		//return updates, tc
 	case []interface{}:
-		tc.Msg = append(tc.Msg, "DO WE COME HERE ?")
+		tc.AddMsg("DO WE COME HERE ?")
 	}
 	return updates, tc
 }
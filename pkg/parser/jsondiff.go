@@ -25,6 +25,7 @@ import (
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/pkg/errors"
 	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/tracing"
 )
 
 const (
@@ -54,6 +55,18 @@ type Operation struct {
 }
 
 func (p *Parser) FindResourceDeltaGnmi(updatesx1, updatesx2 []*gnmi.Update, log logging.Logger) ([]*gnmi.Path, []*gnmi.Update, error) {
+	span := p.startSpan("parser.FindResourceDelta")
+	defer span.End()
+	deletes, updates, err := p.findResourceDeltaGnmi(span, updatesx1, updatesx2)
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+	span.SetAttributes(tracing.Int("deletes", len(deletes)), tracing.Int("updates", len(updates)))
+	return deletes, updates, nil
+}
+
+func (p *Parser) findResourceDeltaGnmi(span tracing.Span, updatesx1, updatesx2 []*gnmi.Update) ([]*gnmi.Path, []*gnmi.Update, error) {
 
 	deletes := make([]*gnmi.Path, 0)
 	updates := make([]*gnmi.Update, 0)
@@ -114,7 +127,11 @@ func (p *Parser) FindResourceDeltaGnmi(updatesx1, updatesx2 []*gnmi.Update, log
 						if err != nil {
 							return nil, nil, err
 						}
-						fmt.Printf("Patch Operation: type %v, path: %v, value: %v\n", operation.Type, operation.Path, string(v))
+						span.AddEvent("patch operation",
+							tracing.Path(tracing.AttrPath, updatex1.Path),
+							tracing.String(tracing.AttrOperation, string(operation.Type)),
+							tracing.String("patch path", operation.Path),
+							tracing.String("value", string(v)))
 						switch operation.Type {
 						case OperationTypeDelete:
 							path := p.DeepCopyGnmiPath(updatex1.Path)
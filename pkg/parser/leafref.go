@@ -17,11 +17,15 @@ limitations under the License.
 package parser
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/goyang/pkg/yang"
 	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/jtree"
+	"github.com/yndd/ndd-yang/pkg/tracing"
 )
 
 type LeafRefValidationKind string
@@ -190,6 +194,8 @@ func (p *Parser) ProcessLeafRefGnmi(e *yang.Entry, resfullPath string, activeRes
 // the remote leaf refs within the objects are located
 /// based on the result this funciton return the result + information on the validation
 func (p *Parser) ValidateLeafRefGnmi(kind LeafRefValidationKind, x1, x2 interface{}, definedLeafRefs []*LeafRefGnmi, log logging.Logger) (bool, []*ResolvedLeafRefGnmi, error) {
+	span := p.startSpan("parser.ValidateLeafRef", tracing.String("kind", string(kind)))
+	defer span.End()
 
 	// a global indication if the leafRef resolution was successfull or not
 	// we are positive so we initialize to true
@@ -201,75 +207,49 @@ func (p *Parser) ValidateLeafRefGnmi(kind LeafRefValidationKind, x1, x2 interfac
 	// if the local leafref is resolved, validate if the remote leafref is present
 	// if not the resource cannot be configured
 	for _, leafRef := range definedLeafRefs {
-		// Initialize 1 entry in resolvedLeafRefs, whether it will be resolved or not
-		// will be indicated by the Resolved flag in the resolvedLeafRef
-		resolvedLeafRefs := make([]*ResolvedLeafRefGnmi, 0)
-		resolvedLeafRef := &ResolvedLeafRefGnmi{
-			LocalPath:  p.DeepCopyGnmiPath(leafRef.LocalPath), // used for path
-			RemotePath: p.DeepCopyGnmiPath(leafRef.RemotePath),
-			Value:      "",
-			Resolved:   false,
-		}
-		resolvedLeafRefs = append(resolvedLeafRefs, resolvedLeafRef)
 		// resolve the leafreference
-		tc := &TraceCtxtGnmi{
-			Path:             p.DeepCopyGnmiPath(leafRef.LocalPath), // used to walk through the object -> this data will not be filled in
-			Idx:              0,
-			Msg:              make([]string, 0),
-			ResolvedLeafRefs: resolvedLeafRefs,
-			Action:           ConfigResolveLeafRef,
+		resolvedLeafRefs, err := p.resolveLocalLeafRefs(x1, leafRef)
+		if err != nil {
+			span.RecordError(err)
+			return false, nil, err
 		}
-		p.ParseTreeWithActionGnmi(x1, tc, 0, 0)
-
-		/*
-			if len(tc.ResolvedLeafRefs) > 1 {
-				fmt.Printf("ValidateLeafRef, localpath:%s, tc: %v\n", *p.ConfigGnmiPathToXPath(tc.Path, true), tc)
-				for _, resolvedLeafRef := range tc.ResolvedLeafRefs {
-					fmt.Printf("ValidateLeafRef resolvedLeafRef value     :%v\n", resolvedLeafRef.Value)
-					fmt.Printf("ValidateLeafRef resolvedLeafRef resolved  :%v\n", resolvedLeafRef.Resolved)
-					fmt.Printf("ValidateLeafRef resolvedLeafRef local path:%v\n", *p.ConfigGnmiPathToXPath(resolvedLeafRef.LocalPath, true))
-					fmt.Printf("ValidateLeafRef resolvedLeafRef remotepath:%v\n", *p.ConfigGnmiPathToXPath(resolvedLeafRef.RemotePath, true))
-				}
-			}
-		*/
 
 		// for all the resolved leafrefs validate if the remote leafref exists
-		for _, resolvedLeafRef := range tc.ResolvedLeafRefs {
-			// Validate if the leaf ref is resolved
-			if resolvedLeafRef.Resolved {
-				// populate the remote leaf ref key
-				p.PopulateRemoteLeafRefKeyGnmi(resolvedLeafRef)
-				// find the Remote leafRef in the JSON data
-
-				tc := &TraceCtxtGnmi{
-					Path:   p.DeepCopyGnmiPath(resolvedLeafRef.RemotePath), // used to walk through the object -> this data will not be filled in
-					Idx:    0,
-					Msg:    make([]string, 0),
-					Action: ConfigTreeActionFind,
-				}
-				if kind == LeafRefValidationLocal {
-					// use the local data supplied in x1 for the remote leafref resolution
-					p.ParseTreeWithActionGnmi(x1, tc, 0, 0)
-				} else {
-					// use the external data supplied in x2 for the remote leafref resolution
-					p.ParseTreeWithActionGnmi(x2, tc, 0, 0)
-				}
+		for _, resolvedLeafRef := range resolvedLeafRefs {
+			// populate the remote leaf ref key
+			p.PopulateRemoteLeafRefKeyGnmi(resolvedLeafRef)
+			// find the Remote leafRef in the JSON data
+			x := x2
+			if kind == LeafRefValidationLocal {
+				// use the local data supplied in x1 for the remote leafref resolution
+				x = x1
+			}
+			found, err := findPath(x, resolvedLeafRef.RemotePath)
+			if err != nil {
+				span.RecordError(err)
+				return false, nil, err
+			}
 
-				// check if the remote leafref got resolved
-				if !tc.Found {
-					success = false
-				}
-				// fill out information which will be returned
-				resultResolvedLeafRef := &ResolvedLeafRefGnmi{
-					LocalPath:  resolvedLeafRef.LocalPath,
-					RemotePath: resolvedLeafRef.RemotePath,
-					Value:      resolvedLeafRef.Value,
-					Resolved:   tc.Found,
-				}
-				resultResolvedLeafRefs = append(resultResolvedLeafRefs, resultResolvedLeafRef)
+			// check if the remote leafref got resolved
+			if !found {
+				success = false
+			}
+			// fill out information which will be returned
+			resultResolvedLeafRef := &ResolvedLeafRefGnmi{
+				LocalPath:  resolvedLeafRef.LocalPath,
+				RemotePath: resolvedLeafRef.RemotePath,
+				Value:      resolvedLeafRef.Value,
+				Resolved:   found,
 			}
+			resultResolvedLeafRefs = append(resultResolvedLeafRefs, resultResolvedLeafRef)
+			span.AddEvent("leafref",
+				tracing.Path("local path", resolvedLeafRef.LocalPath),
+				tracing.Path("remote path", resolvedLeafRef.RemotePath),
+				tracing.String("value", resolvedLeafRef.Value),
+				tracing.Bool(tracing.AttrFound, found))
 		}
 	}
+	span.SetAttributes(tracing.Bool("success", success))
 	return success, resultResolvedLeafRefs, nil
 }
 
@@ -277,6 +257,8 @@ func (p *Parser) ValidateLeafRefGnmi(kind LeafRefValidationKind, x1, x2 interfac
 // based on the result this function returns the result + information on the validation
 // we use a get here since we resolved the values of the keys alreay
 func (p *Parser) ValidateParentDependency(x1 interface{}, definedParentDependencies []*LeafRefGnmi, log logging.Logger) (bool, []*ResolvedLeafRefGnmi, error) {
+	span := p.startSpan("parser.ValidateParentDependency")
+	defer span.End()
 	// a global indication if the leafRef resolution was successfull or not
 	// we are positive so we initialize to true
 	success := true
@@ -297,23 +279,21 @@ func (p *Parser) ValidateParentDependency(x1 interface{}, definedParentDependenc
 		depLeafRef.RemotePath.Elem = depLeafRef.RemotePath.GetElem()[:(lastKeyElemIdx + 1)]
 
 		// get the Remote leafRef in the JSON data
-		tc := &TraceCtxtGnmi{
-			Path:   p.DeepCopyGnmiPath(depLeafRef.RemotePath), // used to walk through the object -> this data will not be filled in
-			Idx:    0,
-			Msg:    make([]string, 0),
-			Action: ConfigTreeActionGet,
+		found, err := findPath(x1, depLeafRef.RemotePath)
+		if err != nil {
+			span.RecordError(err)
+			return false, nil, err
 		}
-
-		p.ParseTreeWithActionGnmi(x1, tc, 0, 0)
+		span.AddEvent("parent dependency", tracing.Path("remote path", depLeafRef.RemotePath), tracing.Bool(tracing.AttrFound, found))
 
 		// check if the remote leafref got resolved
-		if !tc.Found {
+		if !found {
 			success = false
 		}
 		// fill out information which will be returned
 		resolvedLeafRefValidationResult := &ResolvedLeafRefGnmi{
 			RemotePath: depLeafRef.RemotePath,
-			Resolved:   tc.Found,
+			Resolved:   found,
 		}
 		resultleafRefValidation = append(resultleafRefValidation, resolvedLeafRefValidationResult)
 
@@ -326,6 +306,8 @@ func (p *Parser) ValidateParentDependency(x1 interface{}, definedParentDependenc
 // the remote leaf refs within the objects are located
 /// based on the result this funciton return the result + information on the validation
 func (p *Parser) ValidateParentDependencyGnmi(x1 interface{}, value string, definedParentDependencies []*LeafRefGnmi, log logging.Logger) (bool, []*ResolvedLeafRefGnmi, error) {
+	span := p.startSpan("parser.ValidateParentDependency", tracing.String("value", value))
+	defer span.End()
 	// a global indication if the leafRef resolution was successfull or not
 	// we are positive so we initialize to true
 	success := true
@@ -343,28 +325,151 @@ func (p *Parser) ValidateParentDependencyGnmi(x1 interface{}, value string, defi
 
 		p.PopulateRemoteLeafRefKeyGnmi(resolvedLeafRef)
 		// find the Remote leafRef in the JSON data
-		tc := &TraceCtxtGnmi{
-			Path:   p.DeepCopyGnmiPath(resolvedLeafRef.RemotePath), // used to walk through the object -> this data will not be filled in
-			Idx:    0,
-			Msg:    make([]string, 0),
-			Value:  resolvedLeafRef.Value,
-			Action: ConfigTreeActionFind,
+		found, err := findPath(x1, resolvedLeafRef.RemotePath)
+		if err != nil {
+			span.RecordError(err)
+			return false, nil, err
 		}
-
-		p.ParseTreeWithActionGnmi(x1, tc, 0, 0)
+		span.AddEvent("parent dependency", tracing.Path("remote path", resolvedLeafRef.RemotePath), tracing.Bool(tracing.AttrFound, found))
 
 		// check if the remote leafref got resolved
-		if !tc.Found {
+		if !found {
 			success = false
 		}
 		// fill out information which will be returned
 		resolvedLeafRefValidationResult := &ResolvedLeafRefGnmi{
 			RemotePath: resolvedLeafRef.RemotePath,
 			Value:      resolvedLeafRef.Value,
-			Resolved:   tc.Found,
+			Resolved:   found,
 		}
 		resultleafRefValidation = append(resultleafRefValidation, resolvedLeafRefValidationResult)
 
 	}
 	return success, resultleafRefValidation, nil
 }
+
+// resolveLocalLeafRefs returns the leafrefs at the local path of the leafref
+// which are present in the data x1. The keys of the local path which are not
+// set match all list entries, they are filled in with the keys of the entry
+// holding the leafref. The leafref can be a key of the last list of the local
+// path. The data is descended along the local path, a list is only scanned
+// for the entries matching the keys of the path.
+func (p *Parser) resolveLocalLeafRefs(x1 interface{}, leafRef *LeafRefGnmi) ([]*ResolvedLeafRefGnmi, error) {
+	resolvedLeafRefs := make([]*ResolvedLeafRefGnmi, 0)
+	x, ok := x1.(map[string]interface{})
+	localElems := leafRef.LocalPath.GetElem()
+	if !ok || len(localElems) == 0 {
+		return resolvedLeafRefs, nil
+	}
+	resolveLocal(x, localElems, make([]map[string]string, len(localElems)), 0, func(keys []map[string]string, v interface{}) {
+		value, ok := leafRefValue(v)
+		if !ok {
+			return
+		}
+		resolvedLeafRef := &ResolvedLeafRefGnmi{
+			LocalPath:  p.DeepCopyGnmiPath(leafRef.LocalPath),
+			RemotePath: p.DeepCopyGnmiPath(leafRef.RemotePath),
+			Value:      value,
+			Resolved:   true,
+		}
+		for i, pe := range resolvedLeafRef.LocalPath.GetElem() {
+			for k := range pe.GetKey() {
+				pe.GetKey()[k] = keys[i][k]
+			}
+		}
+		resolvedLeafRefs = append(resolvedLeafRefs, resolvedLeafRef)
+	})
+	return resolvedLeafRefs, nil
+}
+
+// resolveLocal descends the data x along the path elements elems starting at
+// elems[i] and calls fn for the values found at the end of the path. keys
+// holds the keys of the list entries on the way, per path element.
+func resolveLocal(x map[string]interface{}, elems []*gnmi.PathElem, keys []map[string]string, i int, fn func([]map[string]string, interface{})) {
+	pe := elems[i]
+	v, ok := x[pe.GetName()]
+	if !ok {
+		return
+	}
+	last := i == len(elems)-1
+	if len(pe.GetKey()) == 0 {
+		if last {
+			fn(keys, v)
+		} else if m, ok := v.(map[string]interface{}); ok {
+			resolveLocal(m, elems, keys, i+1, fn)
+		}
+		return
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+	for _, le := range l {
+		entry, ok := le.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if keys[i], ok = localKeys(entry, pe.GetKey()); !ok {
+			continue
+		}
+		if last {
+			// the leafref is a key of the last list of the local path
+			for k := range pe.GetKey() {
+				fn(keys, entry[k])
+			}
+			continue
+		}
+		resolveLocal(entry, elems, keys, i+1, fn)
+	}
+}
+
+// localKeys returns the keys of the list entry, false if the entry does not
+// match the keys. An empty key value matches all entries.
+func localKeys(entry map[string]interface{}, keys map[string]string) (map[string]string, bool) {
+	ek := make(map[string]string, len(keys))
+	for k, kv := range keys {
+		v, ok := leafRefKey(entry[k])
+		if !ok || (kv != "" && kv != v) {
+			return nil, false
+		}
+		ek[k] = v
+	}
+	return ek, true
+}
+
+// leafRefKey returns the key value v as used in a path, false if v is not a
+// scalar value
+func leafRefKey(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	default:
+		return fmt.Sprintf("%v", x), true
+	}
+}
+
+// leafRefValue returns the string value of a leafref, false if v is not a
+// scalar value
+func leafRefValue(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case int:
+		return strconv.Itoa(x), true
+	case float64:
+		return fmt.Sprintf("%.0f", x), true
+	}
+	return "", false
+}
+
+// findPath returns true if the path p is present in the data x
+func findPath(x interface{}, p *gnmi.Path) (bool, error) {
+	m, ok := x.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	_, found, err := jtree.New(m).Get(p)
+	return found, err
+}
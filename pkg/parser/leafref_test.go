@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestValidateLeafRefGnmi(t *testing.T) {
+	p := NewParser()
+	x1 := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+		"interface": [
+			{"name": "ethernet-1/1", "lag": "lag1", "vlan": 10},
+			{"name": "ethernet-1/2", "lag": "lag2"},
+			{"name": "ethernet-1/3"}
+		],
+		"lag": [{"name": "lag1"}]
+	}`), &x1); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	x2 := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{"vlan": [{"id": 10}]}`), &x2); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	tests := []struct {
+		name     string
+		kind     LeafRefValidationKind
+		lr       *LeafRefGnmi
+		success  bool
+		resolved map[string]bool
+	}{
+		{
+			name: "local",
+			kind: LeafRefValidationLocal,
+			lr: &LeafRefGnmi{
+				LocalPath:  p.XpathToGnmiPath("/interface[name=]/lag", 0),
+				RemotePath: p.XpathToGnmiPath("/lag[name=]", 0),
+			},
+			resolved: map[string]bool{"/interface[name=ethernet-1/1]/lag": true, "/interface[name=ethernet-1/2]/lag": false},
+		},
+		{
+			name: "local with key",
+			kind: LeafRefValidationLocal,
+			lr: &LeafRefGnmi{
+				LocalPath: &gnmi.Path{Elem: []*gnmi.PathElem{
+					{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+					{Name: "lag"},
+				}},
+				RemotePath: p.XpathToGnmiPath("/lag[name=]", 0),
+			},
+			success:  true,
+			resolved: map[string]bool{"/interface[name=ethernet-1/1]/lag": true},
+		},
+		{
+			name: "external number",
+			kind: LeafRefValidationExternal,
+			lr: &LeafRefGnmi{
+				LocalPath:  p.XpathToGnmiPath("/interface[name=]/vlan", 0),
+				RemotePath: p.XpathToGnmiPath("/vlan[id=]", 0),
+			},
+			success:  true,
+			resolved: map[string]bool{"/interface[name=ethernet-1/1]/vlan": true},
+		},
+		{
+			name: "not present",
+			kind: LeafRefValidationLocal,
+			lr: &LeafRefGnmi{
+				LocalPath:  p.XpathToGnmiPath("/interface[name=]/description", 0),
+				RemotePath: p.XpathToGnmiPath("/lag[name=]", 0),
+			},
+			success:  true,
+			resolved: map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			success, rlrs, err := p.ValidateLeafRefGnmi(tt.kind, x1, x2, []*LeafRefGnmi{tt.lr}, nil)
+			if err != nil {
+				t.Fatalf("ValidateLeafRefGnmi: %v", err)
+			}
+			if success != tt.success {
+				t.Errorf("success: got %t, want %t", success, tt.success)
+			}
+			resolved := map[string]bool{}
+			for _, rlr := range rlrs {
+				resolved[*p.GnmiPathToXPath(rlr.LocalPath, true)] = rlr.Resolved
+			}
+			if !reflect.DeepEqual(resolved, tt.resolved) {
+				t.Errorf("resolved: got %v, want %v", resolved, tt.resolved)
+			}
+		})
+	}
+}
+
+func TestValidateParentDependencyGnmi(t *testing.T) {
+	p := NewParser()
+	x1 := map[string]interface{}{
+		"interface": []interface{}{map[string]interface{}{"name": "ethernet-1/1"}},
+	}
+	tests := []struct {
+		value   string
+		success bool
+	}{
+		{value: "ethernet-1/1", success: true},
+		{value: "ethernet-1/2"},
+	}
+	for _, tt := range tests {
+		dep := &LeafRefGnmi{RemotePath: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": ""}}}}}
+		success, _, err := p.ValidateParentDependencyGnmi(x1, tt.value, []*LeafRefGnmi{dep}, nil)
+		if err != nil {
+			t.Fatalf("ValidateParentDependencyGnmi: %v", err)
+		}
+		if success != tt.success {
+			t.Errorf("%s: got %t, want %t", tt.value, success, tt.success)
+		}
+	}
+}
+
+func TestResolveLocalLeafRefs(t *testing.T) {
+	p := NewParser()
+	x1 := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+		"interface": [
+			{"name": "ethernet-1/1", "subinterface": [
+				{"index": 1, "vlan": 10},
+				{"index": 2, "vlan": 20}
+			]},
+			{"name": "ethernet-1/2", "subinterface": [{"index": 1, "vlan": 30}]},
+			{"name": "ethernet-1/3"}
+		]
+	}`), &x1); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	tests := []struct {
+		name      string
+		localPath *gnmi.Path
+		resolved  map[string]string
+	}{
+		{
+			name:      "nested lists",
+			localPath: p.XpathToGnmiPath("/interface[name=]/subinterface[index=]/vlan", 0),
+			resolved: map[string]string{
+				"/interface[name=ethernet-1/1]/subinterface[index=1]/vlan": "10",
+				"/interface[name=ethernet-1/1]/subinterface[index=2]/vlan": "20",
+				"/interface[name=ethernet-1/2]/subinterface[index=1]/vlan": "30",
+			},
+		},
+		{
+			name: "set key",
+			localPath: &gnmi.Path{Elem: []*gnmi.PathElem{
+				{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+				{Name: "subinterface", Key: map[string]string{"index": ""}},
+				{Name: "vlan"},
+			}},
+			resolved: map[string]string{
+				"/interface[name=ethernet-1/1]/subinterface[index=1]/vlan": "10",
+				"/interface[name=ethernet-1/1]/subinterface[index=2]/vlan": "20",
+			},
+		},
+		{
+			name:      "key of the last list",
+			localPath: p.XpathToGnmiPath("/interface[name=]/subinterface[index=]", 0),
+			resolved: map[string]string{
+				"/interface[name=ethernet-1/1]/subinterface[index=1]": "1",
+				"/interface[name=ethernet-1/1]/subinterface[index=2]": "2",
+				"/interface[name=ethernet-1/2]/subinterface[index=1]": "1",
+			},
+		},
+		{
+			name:      "not present",
+			localPath: p.XpathToGnmiPath("/interface[name=]/subinterface[index=]/description", 0),
+			resolved:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rlrs, err := p.resolveLocalLeafRefs(x1, &LeafRefGnmi{LocalPath: tt.localPath, RemotePath: p.XpathToGnmiPath("/vlan[id=]", 0)})
+			if err != nil {
+				t.Fatalf("resolveLocalLeafRefs: %v", err)
+			}
+			resolved := map[string]string{}
+			for _, rlr := range rlrs {
+				resolved[*p.GnmiPathToXPath(rlr.LocalPath, true)] = rlr.Value
+			}
+			if !reflect.DeepEqual(resolved, tt.resolved) {
+				t.Errorf("resolveLocalLeafRefs: got %v, want %v", resolved, tt.resolved)
+			}
+		})
+	}
+}
@@ -17,12 +17,18 @@ limitations under the License.
 package parser
 
 import (
+	"context"
+
 	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/tracing"
 )
 
 type Parser struct {
 	// logging
 	log logging.Logger
+	// tracing
+	tracer tracing.Tracer
+	ctx    context.Context
 }
 
 // Option can be used to manipulate Options.
@@ -34,8 +40,19 @@ func WithLogger(log logging.Logger) Option {
 		p.log = log
 	}
 }
+
+// WithTracer specifies the tracer of the Parser.
+func WithTracer(t tracing.Tracer) Option {
+	return func(p *Parser) {
+		p.tracer = t
+	}
+}
+
 func NewParser(opts ...Option) *Parser {
-	p := &Parser{}
+	p := &Parser{
+		tracer: tracing.NewNopTracer(),
+		ctx:    context.Background(),
+	}
 
 	for _, o := range opts {
 		o(p)
@@ -43,3 +60,21 @@ func NewParser(opts ...Option) *Parser {
 
 	return p
 }
+
+// WithContext returns a shallow copy of the Parser which starts its spans as
+// children of the span in ctx.
+func (p *Parser) WithContext(ctx context.Context) *Parser {
+	p2 := *p
+	p2.ctx = ctx
+	return &p2
+}
+
+func (p *Parser) startSpan(name string, attrs ...tracing.Attribute) tracing.Span {
+	t, ctx := p.tracer, p.ctx
+	// a Parser which is not created with NewParser does not trace
+	if t == nil {
+		t = tracing.NewNopTracer()
+	}
+	_, span := t.Start(ctx, name, attrs...)
+	return span
+}
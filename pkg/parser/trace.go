@@ -18,6 +18,7 @@ package parser
 
 import (
 	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/tracing"
 )
 
 const (
@@ -69,8 +70,19 @@ type TraceCtxtGnmi struct {
 	Data             interface{}
 	Value            interface{} // used for leafref resolution
 	Msg              []string
+	Span             tracing.Span // optional span the messages are recorded in as events
 }
 
+// AddMsg adds the message s to the trace, the message is recorded as an event
+// of the span of the trace if it has one.
 func (tc *TraceCtxtGnmi) AddMsg(s string) {
 	tc.Msg = append(tc.Msg, s)
+	if tc.Span == nil {
+		return
+	}
+	attrs := []tracing.Attribute{tracing.Path(tracing.AttrPath, tc.Path)}
+	if tc.Action != "" {
+		attrs = append(attrs, tracing.String(tracing.AttrAction, tc.Action.String()))
+	}
+	tc.Span.AddEvent(s, attrs...)
 }
@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/tracing"
+)
+
+func TestTraceEvents(t *testing.T) {
+	r := tracing.NewRecorder()
+	p := NewParser(WithTracer(r))
+	path := &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}}
+	p.GetUpdatesFromJSONDataGnmi(&gnmi.Path{}, path, map[string]interface{}{"description": "uplink"}, nil)
+
+	spans := r.SpansByName("parser.GetUpdatesFromJSONData")
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	events := spans[0].Events
+	if len(events) == 0 {
+		t.Fatalf("the trace messages are not recorded as events")
+	}
+	if events[0].Name != "entry, idx: 0" {
+		t.Errorf("got first event %q, want %q", events[0].Name, "entry, idx: 0")
+	}
+	for _, a := range events[0].Attributes {
+		if a.Key == tracing.AttrPath && a.Value != "/interface[name=e1]" {
+			t.Errorf("got event path %v, want /interface[name=e1]", a.Value)
+		}
+	}
+}
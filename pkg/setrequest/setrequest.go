@@ -0,0 +1,306 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package setrequest builds gNMI SetRequests from the deletes and updates of a
+// resource delta.
+package setrequest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultMaxMessageSize is the default max message size of a gRPC server
+	DefaultMaxMessageSize = 4 * 1024 * 1024
+
+	// errors
+	errGroupTooLarge = "the updates of %s exceed the max message size of %d bytes"
+)
+
+// Builder builds the SetRequests of a delta.
+type Builder interface {
+	// Build returns the SetRequests applying the deletes and updates to the
+	// target. The operations are filled in the order deletes, replaces and
+	// updates, the order a target applies them within a SetRequest, and are
+	// only split over multiple SetRequests at the size and update limits. The
+	// leafs of a list entry are never split over multiple SetRequests.
+	Build(target string, deletes []*gnmi.Path, updates []*gnmi.Update) ([]*gnmi.SetRequest, error)
+}
+
+// Option can be used to manipulate Builder config.
+type Option func(*builder)
+
+// WithLogging specifies how the Builder should log messages.
+func WithLogging(log logging.Logger) Option {
+	return func(b *builder) {
+		b.log = log
+	}
+}
+
+// WithMaxMessageSize limits the encoded size of a SetRequest in bytes.
+func WithMaxMessageSize(n int) Option {
+	return func(b *builder) {
+		b.maxSize = n
+	}
+}
+
+// WithMaxUpdates limits the number of deletes, replaces and updates of a
+// SetRequest, 0 means no limit.
+func WithMaxUpdates(n int) Option {
+	return func(b *builder) {
+		b.maxUpdates = n
+	}
+}
+
+type builder struct {
+	log        logging.Logger
+	rs         *yentry.Entry
+	maxSize    int
+	maxUpdates int
+}
+
+// New returns a Builder for the schema rs.
+func New(rs *yentry.Entry, opts ...Option) Builder {
+	b := &builder{
+		log:     logging.NewNopLogger(),
+		rs:      rs,
+		maxSize: DefaultMaxMessageSize,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+type opKind int
+
+const (
+	opDelete opKind = iota
+	opReplace
+	opUpdate
+)
+
+// group holds the operations of a list entry which are sent in the same
+// SetRequest.
+type group struct {
+	kind    opKind
+	key     string
+	deletes []*gnmi.Path
+	updates []*gnmi.Update
+}
+
+func (g *group) len() int {
+	return len(g.deletes) + len(g.updates)
+}
+
+// size returns the encoded size of the operations of the group in a
+// SetRequest.
+func (g *group) size() int {
+	n := 0
+	for _, d := range g.deletes {
+		n += fieldSize(proto.Size(d))
+	}
+	for _, u := range g.updates {
+		n += fieldSize(proto.Size(u))
+	}
+	return n
+}
+
+// fieldSize returns the encoded size of an embedded message of size n.
+func fieldSize(n int) int {
+	return protowire.SizeTag(1) + protowire.SizeBytes(n)
+}
+
+func (b *builder) Build(target string, deletes []*gnmi.Path, updates []*gnmi.Update) ([]*gnmi.SetRequest, error) {
+	deleted := make(map[string]bool)
+	for _, d := range deletes {
+		deleted[yparser.GnmiPath2XPath(d, true)] = true
+	}
+	// a delete followed by an update of the same container is a replace
+	replaced := make(map[string]bool)
+	groups := make([]*group, 0)
+	idx := make(map[opKind]map[string]*group)
+	add := func(kind opKind, p *gnmi.Path, u *gnmi.Update) {
+		key := listEntry(p)
+		if idx[kind] == nil {
+			idx[kind] = make(map[string]*group)
+		}
+		g, ok := idx[kind][key]
+		if !ok {
+			g = &group{kind: kind, key: key}
+			idx[kind][key] = g
+			groups = append(groups, g)
+		}
+		if u == nil {
+			g.deletes = append(g.deletes, p)
+		} else {
+			g.updates = append(g.updates, u)
+		}
+	}
+	for _, u := range updates {
+		if b.isReplace(u, deleted) {
+			replaced[yparser.GnmiPath2XPath(u.GetPath(), true)] = true
+			add(opReplace, u.GetPath(), u)
+			continue
+		}
+		add(opUpdate, u.GetPath(), u)
+	}
+	for _, d := range deletes {
+		if !replaced[yparser.GnmiPath2XPath(d, true)] {
+			add(opDelete, d, nil)
+		}
+	}
+
+	reqs := make([]*gnmi.SetRequest, 0)
+	// the size of a request is estimated with the full paths, factoring out
+	// the common prefix only makes the request smaller
+	base := proto.Size(&gnmi.SetRequest{Prefix: &gnmi.Path{Target: target}})
+	var cur []*group
+	size, n := base, 0
+	// deletes before replaces before updates, a SetRequest is applied in the
+	// same order so the delta stays a single transaction within the limits
+	for _, kind := range []opKind{opDelete, opReplace, opUpdate} {
+		for _, g := range groups {
+			if g.kind != kind {
+				continue
+			}
+			gs := g.size()
+			if base+gs > b.maxSize && b.maxSize != 0 {
+				return nil, fmt.Errorf(errGroupTooLarge, g.key, b.maxSize)
+			}
+			if len(cur) != 0 && ((b.maxSize != 0 && size+gs > b.maxSize) || (b.maxUpdates != 0 && n+g.len() > b.maxUpdates)) {
+				reqs = append(reqs, newSetRequest(target, cur))
+				cur, size, n = nil, base, 0
+			}
+			cur = append(cur, g)
+			size += gs
+			n += g.len()
+		}
+	}
+	if len(cur) != 0 {
+		reqs = append(reqs, newSetRequest(target, cur))
+	}
+	b.log.Debug("set requests", "target", target, "deletes", len(deletes), "updates", len(updates), "requests", len(reqs))
+	return reqs, nil
+}
+
+// isReplace returns true if the update sets a container or list entry which
+// is deleted as well, the update then rewrites the whole subtree, or if the
+// update sets all entries of a list or leaf-list ordered by user, a merge
+// would keep the observed order. Other container updates are merged, a
+// partial update must not remove the sibling leafs. Leafs are always updated.
+func (b *builder) isReplace(u *gnmi.Update, deleted map[string]bool) bool {
+	v := bytes.TrimSpace(u.GetVal().GetJsonIetfVal())
+	if len(v) == 0 {
+		v = bytes.TrimSpace(u.GetVal().GetJsonVal())
+	}
+	if len(v) == 0 {
+		return false
+	}
+	switch v[0] {
+	case '{':
+		return deleted[yparser.GnmiPath2XPath(u.GetPath(), true)]
+	case '[':
+		return b.rs != nil && yparser.IsOrderedByUser(b.rs, u.GetPath())
+	}
+	return false
+}
+
+// listEntry returns the xpath of the deepest list entry in the path, the
+// operations of the same list entry are kept together.
+func listEntry(p *gnmi.Path) string {
+	elems := p.GetElem()
+	for i := len(elems) - 1; i >= 0; i-- {
+		if len(elems[i].GetKey()) != 0 {
+			return yparser.GnmiPath2XPath(&gnmi.Path{Elem: elems[:i+1]}, true)
+		}
+	}
+	return yparser.GnmiPath2XPath(p, true)
+}
+
+func newSetRequest(target string, gs []*group) *gnmi.SetRequest {
+	paths := make([]*gnmi.Path, 0)
+	for _, g := range gs {
+		paths = append(paths, g.deletes...)
+		for _, u := range g.updates {
+			paths = append(paths, u.GetPath())
+		}
+	}
+	n := commonPrefix(paths)
+	req := &gnmi.SetRequest{
+		Prefix: &gnmi.Path{Target: target, Elem: yparser.DeepCopyGnmiPath(&gnmi.Path{Elem: paths[0].GetElem()[:n]}).GetElem()},
+	}
+	for _, g := range gs {
+		for _, d := range g.deletes {
+			req.Delete = append(req.Delete, &gnmi.Path{Elem: d.GetElem()[n:]})
+		}
+		for _, u := range g.updates {
+			ru := &gnmi.Update{Path: &gnmi.Path{Elem: u.GetPath().GetElem()[n:]}, Val: u.GetVal()}
+			switch g.kind {
+			case opReplace:
+				req.Replace = append(req.Replace, ru)
+			default:
+				req.Update = append(req.Update, ru)
+			}
+		}
+	}
+	return req
+}
+
+// commonPrefix returns the number of path elements the paths have in common,
+// every path keeps at least one element.
+func commonPrefix(paths []*gnmi.Path) int {
+	if len(paths) == 0 {
+		return 0
+	}
+	n := len(paths[0].GetElem())
+	for _, p := range paths {
+		if len(p.GetElem())-1 < n {
+			n = len(p.GetElem()) - 1
+		}
+		for i := 0; i < n; i++ {
+			a, b := paths[0].GetElem()[i], p.GetElem()[i]
+			if a.GetName() != b.GetName() || !equalKeys(a.GetKey(), b.GetKey()) {
+				n = i
+				break
+			}
+		}
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func equalKeys(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package setrequest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"github.com/yndd/ndd-yang/pkg/yparser"
+	"google.golang.org/protobuf/proto"
+)
+
+func schema() *yentry.Entry {
+	rs := &yentry.Entry{Name: "root"}
+	itfce := &yentry.Entry{Name: "interface", Key: []string{"name"}, Parent: rs, ResourceBoundary: true}
+	rs.Children = map[string]*yentry.Entry{
+		"interface": itfce,
+		"system":    {Name: "system", Parent: rs},
+	}
+	return rs
+}
+
+func update(xpath, v string) *gnmi.Update {
+	return &gnmi.Update{
+		Path: yparser.Xpath2GnmiPath(xpath, 0),
+		Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(v)}},
+	}
+}
+
+func xpaths(ps []*gnmi.Path) []string {
+	s := make([]string, 0, len(ps))
+	for _, p := range ps {
+		s = append(s, yparser.GnmiPath2XPath(p, true))
+	}
+	return s
+}
+
+func updXpaths(us []*gnmi.Update) []string {
+	s := make([]string, 0, len(us))
+	for _, u := range us {
+		s = append(s, yparser.GnmiPath2XPath(u.GetPath(), true))
+	}
+	return s
+}
+
+func TestBuild(t *testing.T) {
+	b := New(schema())
+	deletes := []*gnmi.Path{
+		yparser.Xpath2GnmiPath("/system/ntp", 0),
+		yparser.Xpath2GnmiPath("/system/dns", 0),
+	}
+	updates := []*gnmi.Update{
+		update("/interface[name=e1]", `{"admin-state":"enable"}`),
+		update("/system/dns", `{"server":"1.1.1.1"}`),
+		update("/system/name", `"dev1"`),
+	}
+	reqs, err := b.Build("dev1", deletes, updates)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	// the delta is applied in a single transaction
+	if len(reqs) != 1 {
+		t.Fatalf("Build: got %d requests, want 1", len(reqs))
+	}
+	req := reqs[0]
+	if req.GetPrefix().GetTarget() != "dev1" {
+		t.Errorf("prefix target: got %q, want dev1", req.GetPrefix().GetTarget())
+	}
+	// the delete of the updated dns container became a replace
+	if got := xpaths(req.GetDelete()); !reflect.DeepEqual(got, []string{"/system/ntp"}) {
+		t.Errorf("deletes: got %v", got)
+	}
+	if got := updXpaths(req.GetReplace()); !reflect.DeepEqual(got, []string{"/system/dns"}) {
+		t.Errorf("replaces: got %v", got)
+	}
+	// the partial update of the interface resource keeps its other leafs
+	if got := updXpaths(req.GetUpdate()); !reflect.DeepEqual(got, []string{"/interface[name=e1]", "/system/name"}) {
+		t.Errorf("updates: got %v", got)
+	}
+
+	// the requests are only split at the limits, the deletes come first
+	reqs, err = New(schema(), WithMaxUpdates(2)).Build("dev1", deletes, updates)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var got []string
+	for _, req := range reqs {
+		got = append(got, fmt.Sprintf("%d/%d/%d", len(req.GetDelete()), len(req.GetReplace()), len(req.GetUpdate())))
+	}
+	if exp := []string{"1/1/0", "0/0/2"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Build with max updates: got %v, want %v", got, exp)
+	}
+}
+
+func TestBuildChunks(t *testing.T) {
+	updates := make([]*gnmi.Update, 0)
+	for i := 0; i < 10; i++ {
+		updates = append(updates,
+			update(fmt.Sprintf("/interface[name=e%d]/admin-state", i), `"enable"`),
+			update(fmt.Sprintf("/interface[name=e%d]/description", i), `"interface description"`),
+			update(fmt.Sprintf("/interface[name=e%d]/mtu", i), `9000`),
+		)
+	}
+	tests := []struct {
+		name    string
+		opts    []Option
+		reqs    int
+		maxSize int
+	}{
+		{name: "no limit", reqs: 1},
+		{name: "max updates", opts: []Option{WithMaxUpdates(4)}, reqs: 10},
+		{name: "max updates multiple entries", opts: []Option{WithMaxUpdates(7)}, reqs: 5},
+		{name: "max size", opts: []Option{WithMaxMessageSize(400)}, maxSize: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := New(schema(), tt.opts...).Build("dev1", nil, updates)
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			if tt.maxSize != 0 && len(reqs) < 2 {
+				t.Errorf("Build: got %d requests, want the updates split", len(reqs))
+			}
+			if tt.reqs != 0 && len(reqs) != tt.reqs {
+				t.Errorf("Build: got %d requests, want %d", len(reqs), tt.reqs)
+			}
+			n := 0
+			for _, req := range reqs {
+				if tt.maxSize != 0 && proto.Size(req) > tt.maxSize {
+					t.Errorf("request size %d exceeds the limit", proto.Size(req))
+				}
+				// the leafs of an interface are never split
+				entries := make(map[string]int)
+				for _, u := range req.GetUpdate() {
+					p := &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, req.GetPrefix().GetElem()...), u.GetPath().GetElem()...)}
+					entries[yparser.GnmiPath2XPath(&gnmi.Path{Elem: p.GetElem()[:1]}, true)]++
+				}
+				for k, c := range entries {
+					if c != 3 {
+						t.Errorf("list entry %s split: %d leafs in request", k, c)
+					}
+				}
+				n += len(req.GetUpdate())
+			}
+			if n != len(updates) {
+				t.Errorf("Build: got %d updates, want %d", n, len(updates))
+			}
+		})
+	}
+
+	if _, err := New(schema(), WithMaxMessageSize(100)).Build("dev1", nil, updates); err == nil {
+		t.Errorf("Build: want error for a list entry exceeding the max message size")
+	}
+}
+
+func TestBuildOrderedByUser(t *testing.T) {
+	rs := schema()
+	rs.Children["system"].OrderedByUser = map[string]bool{"server": true, "search": false}
+	updates := []*gnmi.Update{
+		update("/system/server", `[{"address":"10.0.0.2"},{"address":"10.0.0.1"}]`),
+		update("/system/search", `["b.com","a.com"]`),
+	}
+	reqs, err := New(rs).Build("dev1", nil, updates)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("Build: got %d requests, want 1", len(reqs))
+	}
+	// the list ordered by user is replaced so the intended order is applied,
+	// the common prefix is /system
+	if got := updXpaths(reqs[0].GetReplace()); !reflect.DeepEqual(got, []string{"/server"}) {
+		t.Errorf("replaces: got %v", got)
+	}
+	if got := updXpaths(reqs[0].GetUpdate()); !reflect.DeepEqual(got, []string{"/search"}) {
+		t.Errorf("updates: got %v", got)
+	}
+}
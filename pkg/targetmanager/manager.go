@@ -0,0 +1,330 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package targetmanager reconciles the targets of a cache and their gNMI
+// subscriptions with the desired target configs.
+package targetmanager
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/metadata"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pkg/errors"
+	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/ingest"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// errors
+	errDial   = "cannot dial target"
+	errTLS    = "cannot create the tls config"
+	errNoName = "target config without name"
+)
+
+// TargetGetter returns the desired targets, e.g. a dispatcher.Handler.
+type TargetGetter interface {
+	GetTargets() []*types.TargetConfig
+}
+
+// Dialer returns a connection to the target.
+type Dialer func(ctx context.Context, tc *types.TargetConfig) (*grpc.ClientConn, error)
+
+// Status is the connection state of a target from the cache metadata.
+type Status struct {
+	Connected    bool
+	Sync         bool
+	ConnectError string
+}
+
+// Manager manages the targets of a cache.
+type Manager interface {
+	// Reconcile adds the targets which are desired to the cache and starts
+	// their subscription, the targets which are no longer desired are stopped
+	// and removed from the cache. The targets of the cache which are not
+	// managed and not desired, e.g. replayed from a journal, are removed as
+	// well. A target with a changed config is restarted.
+	Reconcile(ctx context.Context, tcs []*types.TargetConfig) error
+	// ReconcileFrom reconciles the targets returned by g.
+	ReconcileFrom(ctx context.Context, g TargetGetter) error
+	// GetTargets returns the names of the managed targets.
+	GetTargets() []string
+	// GetStatus returns the connection state of the target, false if the
+	// target is not managed.
+	GetStatus(name string) (*Status, bool)
+	// Stop stops the subscriptions of all targets, the targets stay in the
+	// cache.
+	Stop()
+}
+
+// Option can be used to manipulate Manager config.
+type Option func(*manager)
+
+// WithLogging specifies how the Manager should log messages.
+func WithLogging(log logging.Logger) Option {
+	return func(m *manager) {
+		m.log = log
+	}
+}
+
+// WithDialer specifies how the Manager connects to a target.
+func WithDialer(d Dialer) Option {
+	return func(m *manager) {
+		m.dial = d
+	}
+}
+
+// WithSubscribeRequest specifies the subscription of a target.
+func WithSubscribeRequest(fn func(tc *types.TargetConfig) *gnmi.SubscribeRequest) Option {
+	return func(m *manager) {
+		m.req = fn
+	}
+}
+
+// WithIngestOptions specifies the options of the ingester of a target.
+func WithIngestOptions(opts ...ingest.Option) Option {
+	return func(m *manager) {
+		m.ingestOpts = opts
+	}
+}
+
+type managedTarget struct {
+	config string
+	conn   *grpc.ClientConn
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type manager struct {
+	log        logging.Logger
+	c          *cache.Cache
+	dial       Dialer
+	req        func(tc *types.TargetConfig) *gnmi.SubscribeRequest
+	ingestOpts []ingest.Option
+
+	mu      sync.Mutex
+	targets map[string]*managedTarget
+}
+
+// New returns a Manager of the targets of the cache c.
+func New(c *cache.Cache, opts ...Option) Manager {
+	m := &manager{
+		log:     logging.NewNopLogger(),
+		c:       c,
+		dial:    DefaultDialer,
+		req:     DefaultSubscribeRequest,
+		targets: make(map[string]*managedTarget),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *manager) ReconcileFrom(ctx context.Context, g TargetGetter) error {
+	return m.Reconcile(ctx, g.GetTargets())
+}
+
+func (m *manager) Reconcile(ctx context.Context, tcs []*types.TargetConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	desired := make(map[string]*types.TargetConfig, len(tcs))
+	for _, tc := range tcs {
+		if tc.Name == "" {
+			return errors.New(errNoName)
+		}
+		desired[tc.Name] = tc
+	}
+	for name, t := range m.targets {
+		tc, ok := desired[name]
+		if ok && tc.String() == t.config {
+			continue
+		}
+		m.log.Debug("remove target", "target", name)
+		m.stop(t)
+		delete(m.targets, name)
+		// removing the target notifies the subscribers of the cache
+		m.c.GetCache().Remove(name)
+	}
+	for _, name := range m.c.GetCache().GetTargets() {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		m.log.Debug("remove unmanaged target", "target", name)
+		m.c.GetCache().Remove(name)
+	}
+	for _, name := range sortedNames(desired) {
+		if _, ok := m.targets[name]; ok {
+			continue
+		}
+		if err := m.start(ctx, desired[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *manager) start(ctx context.Context, tc *types.TargetConfig) error {
+	m.log.Debug("add target", "target", tc.Name, "address", tc.Address)
+	if m.c.GetCache().GetTarget(tc.Name) == nil {
+		m.c.GetCache().Add(tc.Name)
+	}
+	conn, err := m.dial(ctx, tc)
+	if err != nil {
+		m.c.GetCache().ConnectError(tc.Name, err)
+		return errors.Wrap(err, errDial)
+	}
+	ictx, cancel := context.WithCancel(context.Background())
+	t := &managedTarget{
+		config: tc.String(),
+		conn:   conn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.targets[tc.Name] = t
+	i := ingest.New(m.c, tc.Name, gnmi.NewGNMIClient(conn), m.req(tc), append([]ingest.Option{ingest.WithLogging(m.log)}, m.ingestOpts...)...)
+	go func() {
+		defer close(t.done)
+		i.Run(ictx)
+	}()
+	return nil
+}
+
+func (m *manager) stop(t *managedTarget) {
+	t.cancel()
+	<-t.done
+	if err := t.conn.Close(); err != nil {
+		m.log.Debug("cannot close connection", "error", err)
+	}
+}
+
+func (m *manager) GetTargets() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.targets))
+	for name := range m.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *manager) GetStatus(name string) (*Status, bool) {
+	m.mu.Lock()
+	_, ok := m.targets[name]
+	m.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	s := &Status{}
+	m.c.GetCache().Query(name, []string{metadata.Root}, func(p []string, _ *octree.Leaf, n interface{}) error {
+		nn, ok := n.(*gnmi.Notification)
+		if !ok || len(nn.GetUpdate()) == 0 || len(p) < 2 {
+			return nil
+		}
+		v := nn.GetUpdate()[0].GetVal()
+		switch p[len(p)-1] {
+		case metadata.Connected:
+			s.Connected = v.GetBoolVal()
+		case metadata.Sync:
+			s.Sync = v.GetBoolVal()
+		case metadata.ConnectError:
+			s.ConnectError = v.GetStringVal()
+		}
+		return nil
+	})
+	return s, true
+}
+
+func (m *manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, t := range m.targets {
+		m.stop(t)
+		delete(m.targets, name)
+	}
+}
+
+// DefaultDialer connects to the address of the target with the TLS and
+// credentials of the target config.
+func DefaultDialer(ctx context.Context, tc *types.TargetConfig) (*grpc.ClientConn, error) {
+	insecure := tc.Insecure != nil && *tc.Insecure
+	opts := []grpc.DialOption{}
+	if insecure {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		tlsConfig, err := tc.NewTLS()
+		if err != nil {
+			return nil, errors.Wrap(err, errTLS)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	if tc.Username != nil || tc.Password != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(&loginCredentials{
+			username: tc.UsernameString(),
+			password: tc.PasswordString(),
+			secure:   !insecure,
+		}))
+	}
+	return grpc.DialContext(ctx, tc.Address, opts...)
+}
+
+// DefaultSubscribeRequest subscribes to the changes of all config and state
+// of the target.
+func DefaultSubscribeRequest(tc *types.TargetConfig) *gnmi.SubscribeRequest {
+	return &gnmi.SubscribeRequest{
+		Request: &gnmi.SubscribeRequest_Subscribe{
+			Subscribe: &gnmi.SubscriptionList{
+				Prefix:   &gnmi.Path{Target: tc.Name},
+				Mode:     gnmi.SubscriptionList_STREAM,
+				Encoding: gnmi.Encoding_JSON_IETF,
+				Subscription: []*gnmi.Subscription{
+					{Path: &gnmi.Path{}, Mode: gnmi.SubscriptionMode_ON_CHANGE},
+				},
+			},
+		},
+	}
+}
+
+type loginCredentials struct {
+	username string
+	password string
+	secure   bool
+}
+
+func (c *loginCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"username": c.username, "password": c.password}, nil
+}
+
+func (c *loginCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+func sortedNames(m map[string]*types.TargetConfig) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
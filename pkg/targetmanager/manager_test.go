@@ -0,0 +1,157 @@
+package targetmanager
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/cache"
+	"github.com/yndd/ndd-yang/pkg/octree"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeServer struct {
+	gnmi.UnimplementedGNMIServer
+}
+
+func (s *fakeServer) Subscribe(stream gnmi.GNMI_SubscribeServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	target := req.GetSubscribe().GetPrefix().GetTarget()
+	rsps := []*gnmi.SubscribeResponse{
+		{Response: &gnmi.SubscribeResponse_Update{Update: &gnmi.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Prefix:    &gnmi.Path{Target: target},
+			Update: []*gnmi.Update{{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "name"}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: target}},
+			}},
+		}}},
+		{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}},
+	}
+	for _, rsp := range rsps {
+		if err := stream.Send(rsp); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// dialer returns a Dialer connecting every address to an in-process server
+func dialer(t *testing.T) Dialer {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	gnmi.RegisterGNMIServer(s, &fakeServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return func(ctx context.Context, tc *types.TargetConfig) (*grpc.ClientConn, error) {
+		return grpc.DialContext(ctx, tc.Address,
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+			grpc.WithInsecure())
+	}
+}
+
+type getter []*types.TargetConfig
+
+func (g getter) GetTargets() []*types.TargetConfig { return g }
+
+func eventually(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", desc)
+}
+
+func TestReconcile(t *testing.T) {
+	c := cache.New(nil)
+	var mu sync.Mutex
+	removed := make([]string, 0)
+	c.GetCache().SetClient(func(l *octree.Leaf) {
+		if n, ok := l.Value().(*gnmi.Notification); ok && len(n.GetDelete()) != 0 && len(n.GetDelete()[0].GetElem()) != 0 &&
+			n.GetDelete()[0].GetElem()[0].GetName() == "*" {
+			mu.Lock()
+			removed = append(removed, n.GetPrefix().GetTarget())
+			mu.Unlock()
+		}
+	})
+	m := New(c, WithDialer(dialer(t)))
+	defer m.Stop()
+
+	ctx := context.Background()
+	if err := m.ReconcileFrom(ctx, getter{{Name: "dev1", Address: "dev1:57400"}, {Name: "dev2", Address: "dev2:57400"}}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got := m.GetTargets(); !reflect.DeepEqual(got, []string{"dev1", "dev2"}) {
+		t.Errorf("GetTargets: got %v", got)
+	}
+	for _, name := range []string{"dev1", "dev2"} {
+		eventually(t, name+" in sync", func() bool {
+			s, ok := m.GetStatus(name)
+			return ok && s.Connected && s.Sync && s.ConnectError == ""
+		})
+		if n, err := c.Query(name, &gnmi.Path{Target: name}, &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "name"}}}); err != nil || n == nil {
+			t.Errorf("%s: no data ingested: %v", name, err)
+		}
+	}
+
+	if err := m.Reconcile(ctx, []*types.TargetConfig{{Name: "dev1", Address: "dev1:57400"}}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got := m.GetTargets(); !reflect.DeepEqual(got, []string{"dev1"}) {
+		t.Errorf("GetTargets: got %v", got)
+	}
+	if c.GetCache().GetTarget("dev2") != nil {
+		t.Errorf("dev2 is not removed from the cache")
+	}
+	if _, ok := m.GetStatus("dev2"); ok {
+		t.Errorf("GetStatus: dev2 is still managed")
+	}
+	mu.Lock()
+	if !reflect.DeepEqual(removed, []string{"dev2"}) {
+		t.Errorf("subscribers notified of removal: got %v, want [dev2]", removed)
+	}
+	mu.Unlock()
+
+	// a changed config restarts the target
+	if err := m.Reconcile(ctx, []*types.TargetConfig{{Name: "dev1", Address: "dev1:57401"}}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	eventually(t, "dev1 restarted", func() bool {
+		s, ok := m.GetStatus("dev1")
+		return ok && s.Connected && s.Sync
+	})
+
+	if err := m.Reconcile(ctx, []*types.TargetConfig{{Address: "dev3:57400"}}); err == nil {
+		t.Errorf("Reconcile: want error for a target without name")
+	}
+}
+
+func TestReconcileCacheTargets(t *testing.T) {
+	// the targets are in the cache before the manager starts, e.g. replayed
+	// from a journal
+	c := cache.New([]string{"dev1", "stale"})
+	m := New(c, WithDialer(dialer(t)))
+	defer m.Stop()
+
+	if err := m.Reconcile(context.Background(), []*types.TargetConfig{{Name: "dev1", Address: "dev1:57400"}}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got := c.GetCache().GetTargets(); !reflect.DeepEqual(got, []string{"dev1"}) {
+		t.Errorf("cache targets: got %v, want [dev1]", got)
+	}
+	if got := m.GetTargets(); !reflect.DeepEqual(got, []string{"dev1"}) {
+		t.Errorf("GetTargets: got %v, want [dev1]", got)
+	}
+}
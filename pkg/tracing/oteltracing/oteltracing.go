@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oteltracing adapts an OpenTelemetry tracer to a tracing.Tracer.
+package oteltracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yndd/ndd-yang/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns a tracing.Tracer which starts the spans with t.
+func New(t trace.Tracer) tracing.Tracer {
+	return &tracer{t: t}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t *tracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, s := t.t.Start(ctx, name, trace.WithAttributes(convert(attrs)...))
+	return ctx, &span{s: s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s *span) AddEvent(name string, attrs ...tracing.Attribute) {
+	s.s.AddEvent(name, trace.WithAttributes(convert(attrs)...))
+}
+
+func (s *span) SetAttributes(attrs ...tracing.Attribute) {
+	s.s.SetAttributes(convert(attrs)...)
+}
+
+func (s *span) RecordError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s *span) End() {
+	s.s.End()
+}
+
+func convert(attrs []tracing.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(a.Key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(a.Key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(a.Key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(a.Key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(a.Key, v))
+		default:
+			kvs = append(kvs, attribute.String(a.Key, fmt.Sprintf("%v", v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Event is an event recorded in a span.
+type Event struct {
+	Name       string
+	Attributes []Attribute
+}
+
+// RecordedSpan is a span recorded by the Recorder.
+type RecordedSpan struct {
+	r *Recorder
+
+	Name       string
+	Parent     *RecordedSpan
+	Attributes []Attribute
+	Events     []Event
+	Errors     []error
+	Ended      bool
+}
+
+// Attribute returns the value of the last attribute with key k and whether it
+// was set.
+func (s *RecordedSpan) Attribute(k string) (interface{}, bool) {
+	defer s.r.mu.Unlock()
+	s.r.mu.Lock()
+	for i := len(s.Attributes) - 1; i >= 0; i-- {
+		if s.Attributes[i].Key == k {
+			return s.Attributes[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *RecordedSpan) AddEvent(name string, attrs ...Attribute) {
+	defer s.r.mu.Unlock()
+	s.r.mu.Lock()
+	s.Events = append(s.Events, Event{Name: name, Attributes: attrs})
+}
+
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	defer s.r.mu.Unlock()
+	s.r.mu.Lock()
+	s.Attributes = append(s.Attributes, attrs...)
+}
+
+func (s *RecordedSpan) RecordError(err error) {
+	defer s.r.mu.Unlock()
+	s.r.mu.Lock()
+	s.Errors = append(s.Errors, err)
+}
+
+func (s *RecordedSpan) End() {
+	defer s.r.mu.Unlock()
+	s.r.mu.Lock()
+	s.Ended = true
+}
+
+// Recorder is a Tracer which keeps all spans in memory, it is intended for
+// tests.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// NewRecorder returns a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+type spanKey struct{}
+
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &RecordedSpan{
+		r:          r,
+		Name:       name,
+		Attributes: attrs,
+	}
+	if p, ok := ctx.Value(spanKey{}).(*RecordedSpan); ok && p.r == r {
+		s.Parent = p
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Spans returns the recorded spans in the order they were started.
+func (r *Recorder) Spans() []*RecordedSpan {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	spans := make([]*RecordedSpan, len(r.spans))
+	copy(spans, r.spans)
+	return spans
+}
+
+// SpansByName returns the recorded spans with name.
+func (r *Recorder) SpansByName(name string) []*RecordedSpan {
+	var spans []*RecordedSpan
+	for _, s := range r.Spans() {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Reset removes all recorded spans.
+func (r *Recorder) Reset() {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	r.spans = nil
+}
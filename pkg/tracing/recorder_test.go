@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	ctx, parent := r.Start(context.Background(), "parent", String(AttrTarget, "dev1"))
+	_, child := r.Start(ctx, "child", Path(AttrPath, &gnmi.Path{Elem: []*gnmi.PathElem{
+		{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+	}}))
+	child.AddEvent("found", Bool(AttrFound, true))
+	child.RecordError(errors.New("failed"))
+	child.End()
+	parent.SetAttributes(Int(AttrCount, 1))
+	parent.End()
+
+	spans := r.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Spans: got %d, want 2", len(spans))
+	}
+	c := r.SpansByName("child")[0]
+	if c.Parent != spans[0] {
+		t.Errorf("child: got parent %v, want %v", c.Parent, spans[0])
+	}
+	if v, _ := c.Attribute(AttrPath); v != "/interface[name=ethernet-1/1]" {
+		t.Errorf("child: got path %v", v)
+	}
+	if len(c.Events) != 1 || len(c.Errors) != 1 || !c.Ended {
+		t.Errorf("child: got events %v, errors %v, ended %t", c.Events, c.Errors, c.Ended)
+	}
+	if v, ok := spans[0].Attribute(AttrCount); !ok || v != 1 {
+		t.Errorf("parent: got count %v", v)
+	}
+
+	r.Reset()
+	if len(r.Spans()) != 0 {
+		t.Errorf("Reset: got %d spans", len(r.Spans()))
+	}
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		path *gnmi.Path
+		exp  string
+	}{
+		{path: &gnmi.Path{}, exp: "/"},
+		{path: &gnmi.Path{Elem: []*gnmi.PathElem{
+			{Name: "network-instance", Key: map[string]string{"name": "default"}},
+			{Name: "route", Key: map[string]string{"prefix": "10.0.0.0/8", "owner": "static"}},
+			{Name: "metric"},
+		}}, exp: "/network-instance[name=default]/route[owner=static][prefix=10.0.0.0/8]/metric"},
+	}
+	for _, tt := range tests {
+		if got := Path(AttrPath, tt.path).Value; got != tt.exp {
+			t.Errorf("Path: got %v, want %s", got, tt.exp)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing defines the tracer used to trace the schema, JSON, cache and
+// dispatcher operations. A Tracer records spans with attributes and per path
+// events, the Recorder keeps them in memory and the oteltracing package
+// adapts an OpenTelemetry tracer.
+package tracing
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// Attribute keys used by the instrumented packages.
+const (
+	AttrTarget    = "target"
+	AttrPath      = "path"
+	AttrAction    = "action"
+	AttrOperation = "operation"
+	AttrFound     = "found"
+	AttrCount     = "count"
+)
+
+// Attribute is a key value pair annotating a span or an event.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string Attribute.
+func String(k, v string) Attribute {
+	return Attribute{Key: k, Value: v}
+}
+
+// Int returns an integer Attribute.
+func Int(k string, v int) Attribute {
+	return Attribute{Key: k, Value: v}
+}
+
+// Bool returns a boolean Attribute.
+func Bool(k string, v bool) Attribute {
+	return Attribute{Key: k, Value: v}
+}
+
+// Path returns an Attribute with the xpath of p.
+func Path(k string, p *gnmi.Path) Attribute {
+	return Attribute{Key: k, Value: xpath(p)}
+}
+
+// xpath returns the xpath of p with the keys ordered by name, tracing does not
+// depend on the yparser so every package can be traced.
+func xpath(p *gnmi.Path) string {
+	if len(p.GetElem()) == 0 {
+		return "/"
+	}
+	sb := strings.Builder{}
+	for _, pe := range p.GetElem() {
+		sb.WriteString("/")
+		sb.WriteString(pe.GetName())
+		keys := make([]string, 0, len(pe.GetKey()))
+		for k := range pe.GetKey() {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sb.WriteString("[" + k + "=" + pe.GetKey()[k] + "]")
+		}
+	}
+	return sb.String()
+}
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span which is a child of the span in ctx, if any. The
+	// returned context holds the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	// AddEvent records an event with attributes in the span.
+	AddEvent(name string, attrs ...Attribute)
+	// SetAttributes sets attributes of the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError records err in the span.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// NewNopTracer returns a Tracer which does not record anything.
+func NewNopTracer() Tracer {
+	return nopTracer{}
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) AddEvent(string, ...Attribute) {}
+func (nopSpan) SetAttributes(...Attribute)    {}
+func (nopSpan) RecordError(error)             {}
+func (nopSpan) End()                          {}
@@ -18,9 +18,11 @@ package yentry
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/yndd/ndd-runtime/pkg/logging"
+	"github.com/yndd/ndd-yang/pkg/container"
 	"github.com/yndd/ndd-yang/pkg/leafref"
 )
 
@@ -37,6 +39,15 @@ type Entry struct {
 	LeafRefs         []*leafref.LeafRef
 	Resources        []*gnmi.Path
 	Defaults         map[string]string
+	LeafTypes        map[string]string
+	Presence         bool
+	Choices          map[string]map[string][]string
+	OrderedByUser    map[string]bool
+	Enums            map[string][]string
+	IdentityRefs     map[string][]string
+	Unions           map[string][]*container.UnionType
+	ReadOnly         bool
+	ReadOnlyLeafs    map[string]bool
 }
 
 type EntryOption func(*Entry)
@@ -107,6 +118,10 @@ func (e *Entry) GetModule() string {
 }
 
 func (e *Entry) GetKey() []string {
+	if e == nil {
+		// no schema available
+		return nil
+	}
 	return e.Key
 }
 
@@ -130,8 +145,146 @@ func (e *Entry) GetDefaults() map[string]string {
 	return e.Defaults
 }
 
+func (e *Entry) GetLeafType(name string) string {
+	if e == nil {
+		return ""
+	}
+	if t, ok := e.LeafTypes[name]; ok {
+		return t
+	}
+	return "string"
+}
+
+func (e *Entry) GetPresence() bool {
+	if e == nil {
+		return false
+	}
+	return e.Presence
+}
+
+func (e *Entry) GetChoices() map[string]map[string][]string {
+	return e.Choices
+}
+
+// GetEnum returns the values of the enumeration leaf name
+func (e *Entry) GetEnum(name string) []string {
+	if e == nil {
+		return nil
+	}
+	return e.Enums[name]
+}
+
+// GetUnionTypes returns the member types of the union leaf name
+func (e *Entry) GetUnionTypes(name string) []*container.UnionType {
+	if e == nil {
+		return nil
+	}
+	return e.Unions[name]
+}
+
+// GetIdentities returns the identities derived from the base of the
+// identityref leaf name, the bool is false if the leaf is not an identityref
+func (e *Entry) GetIdentities(name string) ([]string, bool) {
+	if e == nil {
+		return nil, false
+	}
+	ids, ok := e.IdentityRefs[name]
+	return ids, ok
+}
+
+// GetReadOnly returns true if the container or list is config false
+func (e *Entry) GetReadOnly() bool {
+	if e == nil {
+		return false
+	}
+	return e.ReadOnly
+}
+
+// IsReadOnly returns true if the leaf or child name is config false
+func (e *Entry) IsReadOnly(name string) bool {
+	if e == nil {
+		return false
+	}
+	if c, ok := e.Children[name]; ok {
+		return c.ReadOnly
+	}
+	return e.ReadOnly || e.ReadOnlyLeafs[name]
+}
+
+// IsOrderedByUser returns true if the child list or leaf-list name is ordered
+// by user, lists and leaf-lists are ordered by system by default
+func (e *Entry) IsOrderedByUser(name string) bool {
+	if e == nil {
+		return false
+	}
+	return e.OrderedByUser[name]
+}
+
+// GetChoiceCases returns the case per choice of which the child or leaf name
+// is a member, indexed by choice. A member of a nested choice is a member of
+// the enclosing choices as well. The result is empty if it is not part of a
+// choice.
+func (e *Entry) GetChoiceCases(name string) map[string]string {
+	cases := make(map[string]string)
+	if e == nil {
+		return cases
+	}
+	for choice, cs := range e.Choices {
+		for c, members := range cs {
+			for _, m := range members {
+				if m == name {
+					cases[choice] = c
+				}
+			}
+		}
+	}
+	return cases
+}
+
+// GetCaseSiblings returns the members of the other cases of the choices of
+// which the child or leaf name is a member
+func (e *Entry) GetCaseSiblings(name string) []string {
+	cases := e.GetChoiceCases(name)
+	if len(cases) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	siblings := make([]string, 0)
+	for choice, cs := range cases {
+		for c, members := range e.Choices[choice] {
+			if c == cs {
+				continue
+			}
+			for _, m := range members {
+				if !seen[m] {
+					seen[m] = true
+					siblings = append(siblings, m)
+				}
+			}
+		}
+	}
+	sort.Strings(siblings)
+	return siblings
+}
+
+// GetEntry returns the schema entry of path p, nil if p is not in the schema
+func (e *Entry) GetEntry(p *gnmi.Path) *Entry {
+	if e == nil || len(p.GetElem()) == 0 {
+		return e
+	}
+	c, ok := e.Children[p.GetElem()[0].GetName()]
+	if !ok {
+		return nil
+	}
+	return c.GetEntry(&gnmi.Path{Elem: p.GetElem()[1:]})
+}
+
 // GetKeys return the list of keys
 func (e *Entry) GetKeys(p *gnmi.Path) []string {
+	if e == nil {
+		// no schema available
+		return []string{}
+	}
 	if len(p.GetElem()) != 0 {
 		// TODO DO we need to put protection in here?
 		if _, ok := e.Children[p.GetElem()[0].GetName()]; ok {
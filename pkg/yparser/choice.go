@@ -0,0 +1,129 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// ValidateChoice returns an error if the data d of path p holds members of
+// more than one case of a choice.
+func ValidateChoice(rs *yentry.Entry, p *gnmi.Path, d interface{}) error {
+	var err error
+	walkData(rs, p, d, func(e *yentry.Entry, x map[string]interface{}) {
+		if err != nil {
+			return
+		}
+		for choice := range e.GetChoices() {
+			if cases := activeCases(e, choice, x); len(cases) > 1 {
+				err = fmt.Errorf("choice %s in %s has multiple cases %v", choice, e.GetName(), cases)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// activeCases returns the cases of the choice with members in x.
+func activeCases(e *yentry.Entry, choice string, x map[string]interface{}) []string {
+	cases := make([]string, 0)
+	for cs, members := range e.GetChoices()[choice] {
+		for _, m := range members {
+			if _, ok := x[m]; ok {
+				cases = append(cases, cs)
+				break
+			}
+		}
+	}
+	sort.Strings(cases)
+	return cases
+}
+
+// isActiveMember returns true if name is not a member of a choice or, for
+// every choice of which it is a member, a member of the case with members in
+// x.
+func isActiveMember(e *yentry.Entry, name string, x map[string]interface{}) bool {
+	for choice, cs := range e.GetChoiceCases(name) {
+		active := false
+		for _, c := range activeCases(e, choice, x) {
+			if c == cs {
+				active = true
+				break
+			}
+		}
+		if !active {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCaseDeletes returns the paths of the members of the other cases of the
+// choices set by the updates. Setting a member of a case implicitly deletes
+// the members of the other cases, the deletes need to be applied before the
+// updates.
+func GetCaseDeletes(rs *yentry.Entry, upds []*gnmi.Update) []*gnmi.Path {
+	if rs == nil {
+		return nil
+	}
+	deletes := make([]*gnmi.Path, 0)
+	seen := make(map[string]bool)
+	add := func(elems []*gnmi.PathElem, names []string) {
+		for _, n := range names {
+			p := &gnmi.Path{Elem: append(DeepCopyGnmiPath(&gnmi.Path{Elem: elems}).GetElem(), &gnmi.PathElem{Name: n})}
+			if xp := GnmiPath2XPath(p, true); !seen[xp] {
+				seen[xp] = true
+				deletes = append(deletes, p)
+			}
+		}
+	}
+	for _, u := range upds {
+		elems := u.GetPath().GetElem()
+		for i := range elems {
+			e := rs.GetEntry(&gnmi.Path{Elem: elems[:i]})
+			if e == nil {
+				break
+			}
+			add(elems[:i], e.GetCaseSiblings(elems[i].GetName()))
+		}
+		// the members set by a container value
+		e := rs.GetEntry(u.GetPath())
+		if e == nil || len(e.GetChoices()) == 0 {
+			continue
+		}
+		var x map[string]interface{}
+		if err := json.Unmarshal(u.GetVal().GetJsonIetfVal(), &x); err != nil {
+			if err := json.Unmarshal(u.GetVal().GetJsonVal(), &x); err != nil {
+				continue
+			}
+		}
+		names := make([]string, 0, len(x))
+		for k := range x {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			add(elems, e.GetCaseSiblings(k))
+		}
+	}
+	return deletes
+}
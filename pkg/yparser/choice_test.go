@@ -0,0 +1,272 @@
+package yparser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// action has a choice between accept and reject, accept is a presence
+// container
+func choiceSchema() *yentry.Entry {
+	rs := testSchema()
+	policy := addEntry(rs, &yentry.Entry{Name: "policy", Key: []string{"name"}})
+	action := addEntry(policy, &yentry.Entry{Name: "action",
+		Defaults: map[string]string{"reject-reason": "none", "log": "false"},
+		Choices: map[string]map[string][]string{
+			"result": {
+				"accept": {"accept"},
+				"reject": {"reject-reason", "reject-code"},
+			},
+		},
+	})
+	addEntry(action, &yentry.Entry{Name: "accept", Presence: true})
+	return rs
+}
+
+func TestValidateChoice(t *testing.T) {
+	rs := choiceSchema()
+	p := Xpath2GnmiPath("/policy[name=p1]/action", 0)
+	tests := []struct {
+		name string
+		data interface{}
+		err  bool
+	}{
+		{name: "accept", data: map[string]interface{}{"accept": map[string]interface{}{}}},
+		{name: "reject", data: map[string]interface{}{"reject-reason": "x", "reject-code": float64(1)}},
+		{name: "both", data: map[string]interface{}{"accept": map[string]interface{}{}, "reject-code": float64(1)}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateChoice(rs, p, tt.data); (err != nil) != tt.err {
+				t.Errorf("ValidateChoice: got %v, want error %t", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestChoiceDefaults(t *testing.T) {
+	rs := choiceSchema()
+	p := Xpath2GnmiPath("/policy[name=p1]/action", 0)
+
+	got := ApplyDefaults(rs, p, map[string]interface{}{"accept": map[string]interface{}{}})
+	exp := map[string]interface{}{"accept": map[string]interface{}{}, "log": "false"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("ApplyDefaults: got %v, want %v", got, exp)
+	}
+	got = ApplyDefaults(rs, p, map[string]interface{}{"reject-code": float64(1)})
+	exp = map[string]interface{}{"reject-code": float64(1), "reject-reason": "none", "log": "false"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("ApplyDefaults: got %v, want %v", got, exp)
+	}
+}
+
+func TestGetCaseDeletes(t *testing.T) {
+	rs := choiceSchema()
+	tests := []struct {
+		name string
+		upds []*gnmi.Update
+		exp  []string
+	}{
+		{
+			name: "leaf",
+			upds: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/reject-code", 0)}},
+			exp:  []string{"/policy[name=p1]/action/accept"},
+		},
+		{
+			name: "presence container",
+			upds: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/accept", 0)}},
+			exp:  []string{"/policy[name=p1]/action/reject-code", "/policy[name=p1]/action/reject-reason"},
+		},
+		{
+			name: "container value",
+			upds: []*gnmi.Update{{
+				Path: Xpath2GnmiPath("/policy[name=p1]/action", 0),
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"accept":{}}`)}},
+			}},
+			exp: []string{"/policy[name=p1]/action/reject-code", "/policy[name=p1]/action/reject-reason"},
+		},
+		{
+			name: "no choice",
+			upds: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/log", 0)}},
+			exp:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, p := range GetCaseDeletes(rs, tt.upds) {
+				got = append(got, GnmiPath2XPath(p, true))
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("GetCaseDeletes: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestGranularUpdatesPresence(t *testing.T) {
+	rs := choiceSchema()
+	p := Xpath2GnmiPath("/policy[name=p1]/action", 0)
+	upds, err := GetGranularUpdatesFromJSON(p, map[string]interface{}{"accept": map[string]interface{}{}}, rs)
+	if err != nil {
+		t.Fatalf("GetGranularUpdatesFromJSON: %v", err)
+	}
+	if len(upds) != 1 || GnmiPath2XPath(upds[0].GetPath(), true) != "/policy[name=p1]/action/accept" ||
+		string(upds[0].GetVal().GetJsonIetfVal()) != "{}" {
+		t.Errorf("GetGranularUpdatesFromJSON: got %v, want the empty accept container", upds)
+	}
+}
+
+func TestValidateLeafRefChoice(t *testing.T) {
+	rs := choiceSchema()
+	p := Xpath2GnmiPath("/policy[name=p1]/action", 0)
+	x := map[string]interface{}{"accept": map[string]interface{}{}, "reject-code": float64(1)}
+	if _, _, err := ValidateLeafRef(p, x, x, nil, rs); err == nil {
+		t.Errorf("ValidateLeafRef: got no error for multiple cases")
+	}
+}
+
+func TestFindResourceDeltaCase(t *testing.T) {
+	rs := choiceSchema()
+	val := func(s string) *gnmi.TypedValue {
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(s)}}
+	}
+	tests := []struct {
+		name     string
+		spec     []*gnmi.Update
+		observed []*gnmi.Update
+		exp      []string
+	}{
+		{
+			name:     "leaf",
+			spec:     []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/accept", 0), Val: val("{}")}},
+			observed: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/reject-code", 0), Val: val("1")}},
+			exp:      []string{"/policy[name=p1]/action/reject-code"},
+		},
+		{
+			name:     "container value",
+			spec:     []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action", 0), Val: val(`{"accept":{}}`)}},
+			observed: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action", 0), Val: val(`{"reject-reason":"x"}`)}},
+			exp:      []string{"/policy[name=p1]/action/reject-reason"},
+		},
+		{
+			name:     "parent deleted",
+			spec:     []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/accept", 0), Val: val("{}")}},
+			observed: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action", 0), Val: val(`{"reject-reason":"x"}`)}},
+			exp:      []string{"/policy[name=p1]/action"},
+		},
+		{
+			name: "not observed",
+			spec: []*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/accept", 0), Val: val("{}")}},
+			exp:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dels, _, err := FindResourceDelta(tt.spec, tt.observed, WithSchema(rs))
+			if err != nil {
+				t.Fatalf("FindResourceDelta: %v", err)
+			}
+			got := make([]string, 0)
+			for _, p := range dels {
+				got = append(got, GnmiPath2XPath(p, true))
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("FindResourceDelta deletes: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
+
+// the reject case of the result choice holds the nested reason choice
+func nestedChoiceSchema() *yentry.Entry {
+	rs := testSchema()
+	policy := addEntry(rs, &yentry.Entry{Name: "policy", Key: []string{"name"}})
+	action := addEntry(policy, &yentry.Entry{Name: "action",
+		Defaults: map[string]string{"reject-reason": "none"},
+		Choices: map[string]map[string][]string{
+			"result": {
+				"accept": {"accept"},
+				"reject": {"reject-code", "reject-reason"},
+			},
+			"reason": {
+				"code": {"reject-code"},
+				"text": {"reject-reason"},
+			},
+		},
+	})
+	addEntry(action, &yentry.Entry{Name: "accept", Presence: true})
+	return rs
+}
+
+func TestNestedChoice(t *testing.T) {
+	rs := nestedChoiceSchema()
+	p := Xpath2GnmiPath("/policy[name=p1]/action", 0)
+
+	validate := []struct {
+		name string
+		data map[string]interface{}
+		err  bool
+	}{
+		{name: "inner case", data: map[string]interface{}{"reject-code": float64(1)}},
+		{name: "outer case", data: map[string]interface{}{"accept": map[string]interface{}{}}},
+		{name: "inner conflict", data: map[string]interface{}{"reject-code": float64(1), "reject-reason": "x"}, err: true},
+		{name: "outer conflict", data: map[string]interface{}{"accept": map[string]interface{}{}, "reject-code": float64(1)}, err: true},
+	}
+	for _, tt := range validate {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateChoice(rs, p, tt.data); (err != nil) != tt.err {
+				t.Errorf("ValidateChoice: got %v, want error %t", err, tt.err)
+			}
+			if _, _, err := ValidateLeafRef(p, tt.data, tt.data, nil, rs); (err != nil) != tt.err {
+				t.Errorf("ValidateLeafRef: got %v, want error %t", err, tt.err)
+			}
+		})
+	}
+
+	deletes := []struct {
+		name string
+		path string
+		exp  []string
+	}{
+		{name: "inner member", path: "/policy[name=p1]/action/reject-code",
+			exp: []string{"/policy[name=p1]/action/accept", "/policy[name=p1]/action/reject-reason"}},
+		{name: "outer member", path: "/policy[name=p1]/action/accept",
+			exp: []string{"/policy[name=p1]/action/reject-code", "/policy[name=p1]/action/reject-reason"}},
+	}
+	for _, tt := range deletes {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, p := range GetCaseDeletes(rs, []*gnmi.Update{{Path: Xpath2GnmiPath(tt.path, 0)}}) {
+				got = append(got, GnmiPath2XPath(p, true))
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("GetCaseDeletes: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+
+	// the observed member of the outer choice is deleted for the nested case
+	val := func(s string) *gnmi.TypedValue {
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(s)}}
+	}
+	dels, _, err := FindResourceDelta(
+		[]*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/reject-code", 0), Val: val("1")}},
+		[]*gnmi.Update{{Path: Xpath2GnmiPath("/policy[name=p1]/action/accept", 0), Val: val("{}")}},
+		WithSchema(rs))
+	if err != nil {
+		t.Fatalf("FindResourceDelta: %v", err)
+	}
+	if len(dels) != 1 || GnmiPath2XPath(dels[0], true) != "/policy[name=p1]/action/accept" {
+		t.Errorf("FindResourceDelta: got deletes %v, want the accept container", dels)
+	}
+
+	// the default of the inner text case is only applied when the case is active
+	if got := ApplyDefaults(rs, p, map[string]interface{}{"accept": map[string]interface{}{}}); !reflect.DeepEqual(got,
+		map[string]interface{}{"accept": map[string]interface{}{}}) {
+		t.Errorf("ApplyDefaults: got %v, want no defaults for the inactive reject case", got)
+	}
+}
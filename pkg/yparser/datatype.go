@@ -16,7 +16,12 @@ limitations under the License.
 
 package yparser
 
-import "github.com/openconfig/gnmi/proto/gnmi"
+import (
+	"encoding/json"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
 
 const (
 	CacheTypeState  = "STATE"
@@ -41,3 +46,223 @@ func GetDataType(t gnmi.GetRequest_DataType) string {
 		}
 	}
 }
+
+// LeafDataType returns the data type of the leaf name of the schema entry e.
+// A config false leaf is OPERATIONAL unless it mirrors a leaf of the config
+// container next to its state container, the applied config is STATE.
+func LeafDataType(e *yentry.Entry, name string) gnmi.GetRequest_DataType {
+	if !e.IsReadOnly(name) {
+		return gnmi.GetRequest_CONFIG
+	}
+	if e.GetName() == "state" && e.GetParent() != nil {
+		if cfg, ok := e.GetParent().GetChildren()["config"]; ok {
+			if _, ok := cfg.LeafTypes[name]; ok {
+				return gnmi.GetRequest_STATE
+			}
+		}
+	}
+	return gnmi.GetRequest_OPERATIONAL
+}
+
+// IsDataType returns true if data of type dt is returned for the data type t
+// of a GetRequest, STATE includes the OPERATIONAL data.
+func IsDataType(t, dt gnmi.GetRequest_DataType) bool {
+	switch t {
+	case gnmi.GetRequest_ALL:
+		return true
+	case gnmi.GetRequest_STATE:
+		return dt == gnmi.GetRequest_STATE || dt == gnmi.GetRequest_OPERATIONAL
+	default:
+		return t == dt
+	}
+}
+
+// hasDataType returns true if the schema subtree of e holds data of type t.
+func hasDataType(e *yentry.Entry, t gnmi.GetRequest_DataType) bool {
+	if e == nil {
+		return false
+	}
+	for name := range e.LeafTypes {
+		if IsDataType(t, LeafDataType(e, name)) {
+			return true
+		}
+	}
+	for name := range e.ReadOnlyLeafs {
+		if IsDataType(t, LeafDataType(e, name)) {
+			return true
+		}
+	}
+	for _, c := range e.GetChildren() {
+		if hasDataType(c, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterJSON returns the data of type t of the data d of path p, nil if no
+// data of type t is present. The keys of the list entries are kept. The data
+// d is not modified, without schema the data is returned as is.
+func FilterJSON(rs *yentry.Entry, p *gnmi.Path, d interface{}, t gnmi.GetRequest_DataType) interface{} {
+	if rs == nil || t == gnmi.GetRequest_ALL {
+		return d
+	}
+	if e := rs.GetEntry(p); e != nil {
+		return filterJSON(e, d, t)
+	}
+	if !isLeafDataType(rs, p, t) {
+		return nil
+	}
+	return d
+}
+
+// isLeafDataType returns true if the leaf of path p is of type t.
+func isLeafDataType(rs *yentry.Entry, p *gnmi.Path, t gnmi.GetRequest_DataType) bool {
+	l := len(p.GetElem())
+	e := rs.GetEntry(&gnmi.Path{Elem: p.GetElem()[:l-1]})
+	return IsDataType(t, LeafDataType(e, p.GetElem()[l-1].GetName()))
+}
+
+func filterJSON(e *yentry.Entry, d interface{}, t gnmi.GetRequest_DataType) interface{} {
+	switch x := d.(type) {
+	case map[string]interface{}:
+		if len(x) == 0 {
+			// an empty presence container
+			if IsDataType(t, containerDataType(e)) {
+				return x
+			}
+			return nil
+		}
+		r := make(map[string]interface{})
+		for k, v := range x {
+			if c, ok := e.GetChildren()[k]; ok {
+				if fv := filterJSON(c, v, t); fv != nil {
+					r[k] = fv
+				}
+				continue
+			}
+			if IsDataType(t, LeafDataType(e, k)) {
+				r[k] = v
+			}
+		}
+		if len(r) == 0 {
+			return nil
+		}
+		// the keys identify the list entry
+		for _, k := range e.GetKey() {
+			if v, ok := x[k]; ok {
+				r[k] = v
+			}
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, 0, len(x))
+		for _, v := range x {
+			if fv := filterJSON(e, v, t); fv != nil {
+				r = append(r, fv)
+			}
+		}
+		if len(r) == 0 {
+			return nil
+		}
+		return r
+	}
+	return d
+}
+
+func containerDataType(e *yentry.Entry) gnmi.GetRequest_DataType {
+	if e.GetReadOnly() {
+		return gnmi.GetRequest_OPERATIONAL
+	}
+	return gnmi.GetRequest_CONFIG
+}
+
+// SplitJSON returns the CONFIG, STATE and OPERATIONAL data of the data d of
+// path p.
+func SplitJSON(rs *yentry.Entry, p *gnmi.Path, d interface{}) (interface{}, interface{}, interface{}) {
+	return FilterJSON(rs, p, d, gnmi.GetRequest_CONFIG),
+		FilterJSON(rs, p, d, gnmi.GetRequest_STATE),
+		FilterJSON(rs, p, d, gnmi.GetRequest_OPERATIONAL)
+}
+
+// FilterNotification returns the updates and deletes of type t of the
+// notification n, nil if none remain. JSON values are filtered with
+// FilterJSON, a delete is kept if the deleted subtree can hold data of type t.
+func FilterNotification(rs *yentry.Entry, n *gnmi.Notification, t gnmi.GetRequest_DataType) *gnmi.Notification {
+	if rs == nil || t == gnmi.GetRequest_ALL {
+		return n
+	}
+	fn := &gnmi.Notification{
+		Timestamp: n.GetTimestamp(),
+		Prefix:    n.GetPrefix(),
+		Alias:     n.GetAlias(),
+		Atomic:    n.GetAtomic(),
+	}
+	fullPath := func(p *gnmi.Path) *gnmi.Path {
+		return &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, n.GetPrefix().GetElem()...), p.GetElem()...)}
+	}
+	for _, u := range n.GetUpdate() {
+		if fu := filterUpdate(rs, fullPath(u.GetPath()), u, t); fu != nil {
+			fn.Update = append(fn.Update, fu)
+		}
+	}
+	for _, d := range n.GetDelete() {
+		fp := fullPath(d)
+		e := rs.GetEntry(fp)
+		if (e != nil && hasDataType(e, t)) || (e == nil && isLeafDataType(rs, fp, t)) {
+			fn.Delete = append(fn.Delete, d)
+		}
+	}
+	if len(fn.GetUpdate())+len(fn.GetDelete()) == 0 {
+		return nil
+	}
+	return fn
+}
+
+func filterUpdate(rs *yentry.Entry, p *gnmi.Path, u *gnmi.Update, t gnmi.GetRequest_DataType) *gnmi.Update {
+	var b []byte
+	ietf := true
+	switch v := u.GetVal().GetValue().(type) {
+	case *gnmi.TypedValue_JsonIetfVal:
+		b = v.JsonIetfVal
+	case *gnmi.TypedValue_JsonVal:
+		b = v.JsonVal
+		ietf = false
+	default:
+		if FilterJSON(rs, p, u.GetVal(), t) == nil {
+			return nil
+		}
+		return u
+	}
+	var d interface{}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return u
+	}
+	fd := FilterJSON(rs, p, d, t)
+	if fd == nil {
+		return nil
+	}
+	b, err := json.Marshal(fd)
+	if err != nil {
+		return u
+	}
+	val := &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: b}}
+	if !ietf {
+		val = &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: b}}
+	}
+	return &gnmi.Update{Path: u.GetPath(), Val: val, Duplicates: u.GetDuplicates()}
+}
+
+// SplitNotifications returns the CONFIG, STATE and OPERATIONAL parts of the
+// notifications.
+func SplitNotifications(rs *yentry.Entry, ns []*gnmi.Notification) ([]*gnmi.Notification, []*gnmi.Notification, []*gnmi.Notification) {
+	split := make(map[gnmi.GetRequest_DataType][]*gnmi.Notification)
+	for _, n := range ns {
+		for _, t := range []gnmi.GetRequest_DataType{gnmi.GetRequest_CONFIG, gnmi.GetRequest_STATE, gnmi.GetRequest_OPERATIONAL} {
+			if fn := FilterNotification(rs, n, t); fn != nil {
+				split[t] = append(split[t], fn)
+			}
+		}
+	}
+	return split[gnmi.GetRequest_CONFIG], split[gnmi.GetRequest_STATE], split[gnmi.GetRequest_OPERATIONAL]
+}
@@ -0,0 +1,193 @@
+package yparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+func dataTypeSchema() *yentry.Entry {
+	rs := testSchema()
+	itfce := rs.Children["interface"]
+	addEntry(itfce, &yentry.Entry{Name: "config",
+		LeafTypes: map[string]string{"name": "string", "mtu": "uint16"},
+	})
+	state := addEntry(itfce, &yentry.Entry{Name: "state", ReadOnly: true,
+		LeafTypes: map[string]string{"name": "string", "mtu": "uint16", "oper-state": "string"},
+	})
+	addEntry(state, &yentry.Entry{Name: "counters", ReadOnly: true,
+		LeafTypes: map[string]string{"in-pkts": "uint64"},
+	})
+	return rs
+}
+
+func TestLeafDataType(t *testing.T) {
+	rs := dataTypeSchema()
+	tests := []struct {
+		path string
+		leaf string
+		exp  gnmi.GetRequest_DataType
+	}{
+		{path: "/interface", leaf: "name", exp: gnmi.GetRequest_CONFIG},
+		{path: "/interface/config", leaf: "mtu", exp: gnmi.GetRequest_CONFIG},
+		{path: "/interface/state", leaf: "mtu", exp: gnmi.GetRequest_STATE},
+		{path: "/interface/state", leaf: "oper-state", exp: gnmi.GetRequest_OPERATIONAL},
+		{path: "/interface/state/counters", leaf: "in-pkts", exp: gnmi.GetRequest_OPERATIONAL},
+	}
+	for _, tt := range tests {
+		if got := LeafDataType(rs.GetEntry(Xpath2GnmiPath(tt.path, 0)), tt.leaf); got != tt.exp {
+			t.Errorf("LeafDataType(%s, %s): got %s, want %s", tt.path, tt.leaf, got, tt.exp)
+		}
+	}
+}
+
+func TestFilterJSON(t *testing.T) {
+	rs := dataTypeSchema()
+	data := func() interface{} {
+		return map[string]interface{}{
+			"interface": []interface{}{
+				map[string]interface{}{
+					"name":   "e1",
+					"config": map[string]interface{}{"name": "e1", "mtu": float64(1500)},
+					"state": map[string]interface{}{"name": "e1", "mtu": float64(1500), "oper-state": "up",
+						"counters": map[string]interface{}{"in-pkts": "10"}},
+				},
+				map[string]interface{}{
+					"name":   "e2",
+					"config": map[string]interface{}{"name": "e2"},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		path     *gnmi.Path
+		dataType gnmi.GetRequest_DataType
+		data     interface{}
+		exp      interface{}
+	}{
+		{
+			name:     "all",
+			path:     &gnmi.Path{},
+			data:     data(),
+			dataType: gnmi.GetRequest_ALL,
+			exp:      data(),
+		},
+		{
+			name:     "config",
+			path:     &gnmi.Path{},
+			data:     data(),
+			dataType: gnmi.GetRequest_CONFIG,
+			exp: map[string]interface{}{
+				"interface": []interface{}{
+					map[string]interface{}{"name": "e1", "config": map[string]interface{}{"name": "e1", "mtu": float64(1500)}},
+					map[string]interface{}{"name": "e2", "config": map[string]interface{}{"name": "e2"}},
+				},
+			},
+		},
+		{
+			// the list entry without state is dropped, the keys are kept
+			name:     "state",
+			path:     &gnmi.Path{},
+			data:     data(),
+			dataType: gnmi.GetRequest_STATE,
+			exp: map[string]interface{}{
+				"interface": []interface{}{
+					map[string]interface{}{"name": "e1", "state": map[string]interface{}{"name": "e1", "mtu": float64(1500), "oper-state": "up",
+						"counters": map[string]interface{}{"in-pkts": "10"}}},
+				},
+			},
+		},
+		{
+			name:     "operational",
+			path:     &gnmi.Path{},
+			data:     data(),
+			dataType: gnmi.GetRequest_OPERATIONAL,
+			exp: map[string]interface{}{
+				"interface": []interface{}{
+					map[string]interface{}{"name": "e1", "state": map[string]interface{}{"oper-state": "up",
+						"counters": map[string]interface{}{"in-pkts": "10"}}},
+				},
+			},
+		},
+		{
+			name:     "config of state container",
+			path:     Xpath2GnmiPath("/interface[name=e1]/state", 0),
+			dataType: gnmi.GetRequest_CONFIG,
+			data:     map[string]interface{}{"name": "e1", "mtu": float64(1500), "oper-state": "up"},
+			exp:      nil,
+		},
+		{
+			name:     "state leaf",
+			path:     Xpath2GnmiPath("/interface[name=e1]/state/oper-state", 0),
+			dataType: gnmi.GetRequest_STATE,
+			data:     "up",
+			exp:      "up",
+		},
+	}
+	for _, tt := range tests {
+		got := FilterJSON(rs, tt.path, tt.data, tt.dataType)
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.exp)
+		}
+		if len(tt.path.GetElem()) == 0 && !reflect.DeepEqual(tt.data, data()) {
+			t.Errorf("%s: the data is modified", tt.name)
+		}
+	}
+}
+
+func TestFilterNotification(t *testing.T) {
+	rs := dataTypeSchema()
+	blob, _ := json.Marshal(map[string]interface{}{"name": "e1", "mtu": 1500, "oper-state": "up"})
+	n := &gnmi.Notification{
+		Prefix: &gnmi.Path{Target: "dev1", Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "e1"}}}},
+		Update: []*gnmi.Update{
+			{Path: Xpath2GnmiPath("/config/mtu", 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 1500}}},
+			{Path: Xpath2GnmiPath("/state/oper-state", 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "up"}}},
+			{Path: Xpath2GnmiPath("/state", 0), Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: blob}}},
+		},
+		Delete: []*gnmi.Path{Xpath2GnmiPath("/config", 0), Xpath2GnmiPath("/state/counters", 0)},
+	}
+	tests := []struct {
+		dataType gnmi.GetRequest_DataType
+		updates  []string
+		deletes  []string
+	}{
+		{dataType: gnmi.GetRequest_CONFIG, updates: []string{"/config/mtu=1500"}, deletes: []string{"/config"}},
+		{dataType: gnmi.GetRequest_STATE, updates: []string{
+			"/state/oper-state=up",
+			`/state={"mtu":1500,"name":"e1","oper-state":"up"}`,
+		}, deletes: []string{"/state/counters"}},
+		{dataType: gnmi.GetRequest_OPERATIONAL, updates: []string{
+			"/state/oper-state=up",
+			`/state={"oper-state":"up"}`,
+		}, deletes: []string{"/state/counters"}},
+	}
+	for _, tt := range tests {
+		fn := FilterNotification(rs, n, tt.dataType)
+		var updates, deletes []string
+		for _, u := range fn.GetUpdate() {
+			v := fmt.Sprint(u.GetVal().GetUintVal())
+			switch {
+			case u.GetVal().GetStringVal() != "":
+				v = u.GetVal().GetStringVal()
+			case u.GetVal().GetJsonIetfVal() != nil:
+				v = string(u.GetVal().GetJsonIetfVal())
+			}
+			updates = append(updates, GnmiPath2XPath(u.GetPath(), true)+"="+v)
+		}
+		for _, d := range fn.GetDelete() {
+			deletes = append(deletes, GnmiPath2XPath(d, true))
+		}
+		if !reflect.DeepEqual(updates, tt.updates) {
+			t.Errorf("%s updates: got %v, want %v", tt.dataType, updates, tt.updates)
+		}
+		if !reflect.DeepEqual(deletes, tt.deletes) {
+			t.Errorf("%s deletes: got %v, want %v", tt.dataType, deletes, tt.deletes)
+		}
+	}
+}
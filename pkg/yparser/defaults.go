@@ -0,0 +1,282 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// JSONOption modifies the JSON data before it is returned or transformed into
+// updates.
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	applyDefaults  bool
+	stripDefaults  bool
+	normalizeOrder bool
+	normalizeValue bool
+	dataType       gnmi.GetRequest_DataType
+	filter         LeafFilter
+	predicates     []*Predicate
+}
+
+// WithDefaults adds the schema defaults of the leafs which are not present.
+func WithDefaults() JSONOption {
+	return func(o *jsonOptions) {
+		o.applyDefaults = true
+		o.stripDefaults = false
+	}
+}
+
+// WithoutDefaults removes the leafs with the schema default value.
+func WithoutDefaults() JSONOption {
+	return func(o *jsonOptions) {
+		o.stripDefaults = true
+		o.applyDefaults = false
+	}
+}
+
+// WithNormalizedOrder sorts the leaf-lists and lists ordered by system.
+func WithNormalizedOrder() JSONOption {
+	return func(o *jsonOptions) {
+		o.normalizeOrder = true
+	}
+}
+
+// WithNormalizedValues canonicalizes the identityref and enumeration values.
+func WithNormalizedValues() JSONOption {
+	return func(o *jsonOptions) {
+		o.normalizeValue = true
+	}
+}
+
+// WithDataType only keeps the data of the GetRequest data type t.
+func WithDataType(t gnmi.GetRequest_DataType) JSONOption {
+	return func(o *jsonOptions) {
+		o.dataType = t
+	}
+}
+
+// GetOptionsDataType returns the GetRequest data type of the options.
+func GetOptionsDataType(opts ...JSONOption) gnmi.GetRequest_DataType {
+	o := &jsonOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o.dataType
+}
+
+// ProcessJSON applies the options to a copy of the data d of path p.
+func ProcessJSON(rs *yentry.Entry, p *gnmi.Path, d interface{}, opts ...JSONOption) interface{} {
+	o := &jsonOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !o.applyDefaults && !o.stripDefaults && !o.normalizeOrder && !o.normalizeValue && o.dataType == gnmi.GetRequest_ALL {
+		return d
+	}
+	d = copyJSON(d)
+	if o.normalizeValue {
+		// normalize first so the defaults are compared with canonical values
+		d = NormalizeValues(rs, p, d)
+	}
+	switch {
+	case o.applyDefaults:
+		d = ApplyDefaults(rs, p, d)
+	case o.stripDefaults:
+		d = StripDefaults(rs, p, d)
+	}
+	if o.dataType != gnmi.GetRequest_ALL {
+		// filter after applying the defaults, they include config false leafs
+		if d = FilterJSON(rs, p, d, o.dataType); d == nil {
+			return nil
+		}
+	}
+	if o.normalizeOrder {
+		d = NormalizeOrder(rs, p, d)
+	}
+	return d
+}
+
+// ApplyDefaults adds the schema defaults to the data d of path p for the
+// leafs which are not present. Defaults are added to the containers and list
+// entries present in the data and to their absent non-presence containers,
+// which exist whenever their parent exists. An absent presence container stays
+// absent. The defaults of a case are only added when the case is present in
+// the data. The default is typed according to the leaf type. The data is
+// modified in place and returned.
+func ApplyDefaults(rs *yentry.Entry, p *gnmi.Path, d interface{}) interface{} {
+	walkData(rs, p, d, applyDefaults)
+	return d
+}
+
+// applyDefaults adds the defaults of e to x and creates the absent
+// non-presence containers of e which hold defaults.
+func applyDefaults(e *yentry.Entry, x map[string]interface{}) {
+	for k, def := range e.GetDefaults() {
+		if _, ok := x[k]; !ok && isActiveMember(e, k, x) {
+			x[k] = typedDefault(e.GetLeafType(k), def)
+		}
+	}
+	for k, c := range e.GetChildren() {
+		if _, ok := x[k]; ok || !isDefaultContainer(c) || !isActiveMember(e, k, x) {
+			continue
+		}
+		cx := make(map[string]interface{})
+		applyDefaults(c, cx)
+		if len(cx) != 0 {
+			x[k] = cx
+		}
+	}
+}
+
+// StripDefaults removes the leafs with the schema default value from the data
+// d of path p, it is the inverse of ApplyDefaults. The non-presence
+// containers which only hold defaults are removed as well. The data is
+// modified in place and returned.
+func StripDefaults(rs *yentry.Entry, p *gnmi.Path, d interface{}) interface{} {
+	walkData(rs, p, d, func(e *yentry.Entry, x map[string]interface{}) {
+		for k, def := range e.GetDefaults() {
+			if v, ok := x[k]; ok && fmt.Sprint(v) == def {
+				delete(x, k)
+			}
+		}
+		for k, c := range e.GetChildren() {
+			if cx, ok := x[k].(map[string]interface{}); ok && isDefaultContainer(c) && onlyDefaults(c, cx) {
+				delete(x, k)
+			}
+		}
+	})
+	return d
+}
+
+// isDefaultContainer returns true if e is a non-presence container, which
+// exists together with its parent.
+func isDefaultContainer(e *yentry.Entry) bool {
+	return len(e.GetKey()) == 0 && !e.GetPresence()
+}
+
+// onlyDefaults returns true if the container x of e is not empty and only
+// holds leafs with their default value and non-presence containers which only
+// hold defaults.
+func onlyDefaults(e *yentry.Entry, x map[string]interface{}) bool {
+	if len(x) == 0 {
+		return false
+	}
+	for k, v := range x {
+		if def, ok := e.GetDefaults()[k]; ok && fmt.Sprint(v) == def {
+			continue
+		}
+		c, ok := e.GetChildren()[k]
+		if !ok || !isDefaultContainer(c) {
+			return false
+		}
+		if cx, ok := v.(map[string]interface{}); !ok || !onlyDefaults(c, cx) {
+			return false
+		}
+	}
+	return true
+}
+
+// walkData calls fn for every container and list entry in the data d of path
+// p. The data of a list path without keys or with a wildcard key holds the
+// list in an object, e.g. {"interface":[...]} as returned by GetJson, the walk
+// starts at the list entries.
+func walkData(rs *yentry.Entry, p *gnmi.Path, d interface{}, fn func(*yentry.Entry, map[string]interface{})) {
+	e := rs.GetEntry(p)
+	if elems := p.GetElem(); len(elems) != 0 && len(e.GetKey()) != 0 && !hasExactKey(elems[len(elems)-1]) {
+		if x, ok := d.(map[string]interface{}); ok {
+			if l, ok := x[elems[len(elems)-1].GetName()]; ok {
+				d = l
+			}
+		}
+	}
+	walkDefaults(e, d, fn)
+}
+
+func hasExactKey(pe *gnmi.PathElem) bool {
+	if len(pe.GetKey()) == 0 {
+		return false
+	}
+	for _, v := range pe.GetKey() {
+		if v == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// walkDefaults calls fn for every container and list entry in d together with
+// its schema entry.
+func walkDefaults(e *yentry.Entry, d interface{}, fn func(*yentry.Entry, map[string]interface{})) {
+	if e == nil {
+		return
+	}
+	switch x := d.(type) {
+	case map[string]interface{}:
+		fn(e, x)
+		for k, v := range x {
+			if c, ok := e.GetChildren()[k]; ok {
+				walkDefaults(c, v, fn)
+			}
+		}
+	case []interface{}:
+		// list entries
+		for _, v := range x {
+			walkDefaults(e, v, fn)
+		}
+	}
+}
+
+// typedDefault returns the default as it is encoded in JSON IETF, 64 bit
+// numbers and decimals are encoded as strings.
+func typedDefault(t, def string) interface{} {
+	switch t {
+	case "boolean", "bool":
+		if b, err := strconv.ParseBool(def); err == nil {
+			return b
+		}
+	case "int8", "int16", "int32", "uint8", "uint16", "uint32":
+		if f, err := strconv.ParseFloat(def, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func copyJSON(d interface{}) interface{} {
+	switch x := d.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			c[k] = copyJSON(v)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, 0, len(x))
+		for _, v := range x {
+			c = append(c, copyJSON(v))
+		}
+		return c
+	default:
+		return d
+	}
+}
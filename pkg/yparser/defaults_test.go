@@ -0,0 +1,127 @@
+package yparser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+func defaultsSchema() *yentry.Entry {
+	rs := testSchema()
+	itfce := rs.Children["interface"]
+	itfce.Defaults = map[string]string{"admin-state": "enable", "mtu": "1500", "loopback-mode": "false"}
+	itfce.LeafTypes["mtu"] = "uint16"
+	itfce.LeafTypes["loopback-mode"] = "boolean"
+	addEntry(itfce, &yentry.Entry{Name: "lag", Defaults: map[string]string{"lacp-fallback": "static"}})
+	addEntry(itfce, &yentry.Entry{Name: "hold-time", Presence: true, Defaults: map[string]string{"up": "0"}})
+	return rs
+}
+
+func TestDefaults(t *testing.T) {
+	rs := defaultsSchema()
+	tests := []struct {
+		name    string
+		path    *gnmi.Path
+		data    interface{}
+		applied interface{}
+	}{
+		{
+			name: "list",
+			path: &gnmi.Path{},
+			data: map[string]interface{}{
+				"interface": []interface{}{
+					map[string]interface{}{"name": "e1", "admin-state": "disable"},
+					map[string]interface{}{"name": "e2", "lag": map[string]interface{}{"mode": "active"}},
+				},
+			},
+			applied: map[string]interface{}{
+				"interface": []interface{}{
+					map[string]interface{}{"name": "e1", "admin-state": "disable", "mtu": float64(1500), "loopback-mode": false,
+						"lag": map[string]interface{}{"lacp-fallback": "static"}},
+					map[string]interface{}{"name": "e2", "admin-state": "enable", "mtu": float64(1500), "loopback-mode": false,
+						"lag": map[string]interface{}{"mode": "active", "lacp-fallback": "static"}},
+				},
+			},
+		},
+		{
+			// the data of a list path holds the list as returned by GetJson
+			name: "list path",
+			path: Xpath2GnmiPath("/interface", 0),
+			data: map[string]interface{}{"interface": []interface{}{map[string]interface{}{"name": "e1"}}},
+			applied: map[string]interface{}{"interface": []interface{}{
+				map[string]interface{}{"name": "e1", "admin-state": "enable", "mtu": float64(1500), "loopback-mode": false,
+					"lag": map[string]interface{}{"lacp-fallback": "static"}},
+			}},
+		},
+		{
+			name: "list path with wildcard key",
+			path: Xpath2GnmiPath("/interface[name=*]", 0),
+			data: map[string]interface{}{"interface": []interface{}{map[string]interface{}{"name": "e1", "mtu": float64(9000)}}},
+			applied: map[string]interface{}{"interface": []interface{}{
+				map[string]interface{}{"name": "e1", "admin-state": "enable", "mtu": float64(9000), "loopback-mode": false,
+					"lag": map[string]interface{}{"lacp-fallback": "static"}},
+			}},
+		},
+		{
+			// the absent non-presence lag container gets its defaults, the
+			// absent hold-time presence container is not created
+			name: "list entry",
+			path: Xpath2GnmiPath("/interface[name=e1]", 0),
+			data: map[string]interface{}{"description": "uplink"},
+			applied: map[string]interface{}{"description": "uplink", "admin-state": "enable", "mtu": float64(1500), "loopback-mode": false,
+				"lag": map[string]interface{}{"lacp-fallback": "static"}},
+		},
+		{
+			name: "present presence container",
+			path: Xpath2GnmiPath("/interface[name=e1]", 0),
+			data: map[string]interface{}{"hold-time": map[string]interface{}{}},
+			applied: map[string]interface{}{"admin-state": "enable", "mtu": float64(1500), "loopback-mode": false,
+				"lag": map[string]interface{}{"lacp-fallback": "static"}, "hold-time": map[string]interface{}{"up": "0"}},
+		},
+		{
+			name:    "unknown path",
+			path:    Xpath2GnmiPath("/system", 0),
+			data:    map[string]interface{}{"name": "x"},
+			applied: map[string]interface{}{"name": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := copyJSON(tt.data)
+			applied := ProcessJSON(rs, tt.path, tt.data, WithDefaults())
+			if !reflect.DeepEqual(applied, tt.applied) {
+				t.Errorf("ApplyDefaults: got %v, want %v", applied, tt.applied)
+			}
+			if !reflect.DeepEqual(tt.data, orig) {
+				t.Errorf("ProcessJSON modified its input: got %v, want %v", tt.data, orig)
+			}
+			if stripped := StripDefaults(rs, tt.path, applied); !reflect.DeepEqual(stripped, orig) {
+				t.Errorf("StripDefaults: got %v, want %v", stripped, orig)
+			}
+		})
+	}
+}
+
+func TestGranularUpdatesWithDefaults(t *testing.T) {
+	rs := defaultsSchema()
+	p := Xpath2GnmiPath("/interface[name=e1]", 0)
+	d := map[string]interface{}{"admin-state": "enable", "mtu": float64(9000)}
+
+	upds, err := GetGranularUpdatesFromJSON(p, d, rs, WithoutDefaults())
+	if err != nil {
+		t.Fatalf("GetGranularUpdatesFromJSON: %v", err)
+	}
+	got := make(map[string]string)
+	for _, u := range upds {
+		got[GnmiPath2XPath(u.GetPath(), true)] = string(u.GetVal().GetJsonIetfVal())
+	}
+	exp := map[string]string{
+		"/interface[name=e1]/name": `"e1"`,
+		"/interface[name=e1]/mtu":  "9000",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("GetGranularUpdatesFromJSON: got %v, want %v", got, exp)
+	}
+}
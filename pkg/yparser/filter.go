@@ -0,0 +1,123 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// LeafFilter selects the leafs of a query by depth and leaf name, the keys of
+// the selected list entries are always kept.
+type LeafFilter struct {
+	// Depth is the max number of path elements of a leaf below the query
+	// path, 0 means no limit
+	Depth int
+	// Include holds the names of the leafs to keep, empty keeps all leafs
+	Include map[string]bool
+	// Exclude holds the names of the leafs to drop
+	Exclude map[string]bool
+}
+
+// WithDepth only keeps the leafs up to depth path elements below the query
+// path, the leafs of the query path have depth 1.
+func WithDepth(depth int) JSONOption {
+	return func(o *jsonOptions) {
+		o.filter.Depth = depth
+	}
+}
+
+// WithIncludeLeafs only keeps the leafs with one of the names.
+func WithIncludeLeafs(names ...string) JSONOption {
+	return func(o *jsonOptions) {
+		o.filter.Include = addNames(o.filter.Include, names)
+	}
+}
+
+// WithExcludeLeafs drops the leafs with one of the names.
+func WithExcludeLeafs(names ...string) JSONOption {
+	return func(o *jsonOptions) {
+		o.filter.Exclude = addNames(o.filter.Exclude, names)
+	}
+}
+
+func addNames(m map[string]bool, names []string) map[string]bool {
+	if m == nil {
+		m = make(map[string]bool, len(names))
+	}
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+// GetLeafFilter returns the leaf filter of the options, nil if the options
+// keep all leafs. The leaf filter is applied by the queries reading the data,
+// ProcessJSON does not apply it.
+func GetLeafFilter(opts ...JSONOption) *LeafFilter {
+	o := &jsonOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.filter.IsEmpty() {
+		return nil
+	}
+	return &o.filter
+}
+
+// IsEmpty returns true if the filter keeps all leafs.
+func (f *LeafFilter) IsEmpty() bool {
+	return f == nil || (f.Depth == 0 && len(f.Include) == 0 && len(f.Exclude) == 0)
+}
+
+// Match returns true if the leaf l below the query path p is kept. A leaf is a
+// key if its name is a key of the parent path element or of the list entry in
+// the schema rs.
+func (f *LeafFilter) Match(rs *yentry.Entry, p, l *gnmi.Path) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	elems := l.GetElem()
+	if len(elems) == 0 {
+		return true
+	}
+	if f.Depth != 0 && len(elems)-len(p.GetElem()) > f.Depth {
+		return false
+	}
+	name := elems[len(elems)-1].GetName()
+	if isKeyLeaf(rs, elems) {
+		return true
+	}
+	if len(f.Include) != 0 && !f.Include[name] {
+		return false
+	}
+	return !f.Exclude[name]
+}
+
+func isKeyLeaf(rs *yentry.Entry, elems []*gnmi.PathElem) bool {
+	if len(elems) < 2 {
+		return false
+	}
+	name := elems[len(elems)-1].GetName()
+	if _, ok := elems[len(elems)-2].GetKey()[name]; ok {
+		return true
+	}
+	if rs == nil {
+		return false
+	}
+	return contains(rs.GetEntry(&gnmi.Path{Elem: elems[:len(elems)-1]}).GetKey(), name)
+}
@@ -0,0 +1,41 @@
+package yparser
+
+import (
+	"testing"
+)
+
+func TestLeafFilter(t *testing.T) {
+	rs := testSchema()
+
+	tests := []struct {
+		name string
+		opts []JSONOption
+		path string
+		leaf string
+		exp  bool
+	}{
+		{name: "no filter", path: "/interface", leaf: "/interface[name=e1]/subinterface[index=0]/admin-state", exp: true},
+		{name: "depth", opts: []JSONOption{WithDepth(1)}, path: "/interface", leaf: "/interface[name=e1]/admin-state", exp: true},
+		{name: "below depth", opts: []JSONOption{WithDepth(1)}, path: "/interface", leaf: "/interface[name=e1]/subinterface[index=0]/admin-state", exp: false},
+		{name: "below depth key", opts: []JSONOption{WithDepth(1)}, path: "/interface", leaf: "/interface[name=e1]/subinterface[index=0]/index", exp: false},
+		{name: "include", opts: []JSONOption{WithIncludeLeafs("admin-state", "oper-state")}, path: "/interface", leaf: "/interface[name=e1]/oper-state", exp: true},
+		{name: "not included", opts: []JSONOption{WithIncludeLeafs("admin-state", "oper-state")}, path: "/interface", leaf: "/interface[name=e1]/mtu", exp: false},
+		{name: "path key", opts: []JSONOption{WithIncludeLeafs("admin-state")}, path: "/interface", leaf: "/interface[name=e1]/subinterface[index=0]/index", exp: true},
+		// the key of the list entry is taken from the schema if the path has no keys
+		{name: "schema key", opts: []JSONOption{WithIncludeLeafs("admin-state")}, path: "/interface", leaf: "/interface/name", exp: true},
+		{name: "exclude", opts: []JSONOption{WithExcludeLeafs("name", "mtu")}, path: "/interface", leaf: "/interface[name=e1]/mtu", exp: false},
+		{name: "excluded key", opts: []JSONOption{WithExcludeLeafs("name", "mtu")}, path: "/interface", leaf: "/interface[name=e1]/name", exp: true},
+	}
+	for _, tt := range tests {
+		f := GetLeafFilter(tt.opts...)
+		if (f == nil) != (len(tt.opts) == 0) {
+			t.Errorf("%s: got filter %v", tt.name, f)
+		}
+		if got := f.Match(rs, Xpath2GnmiPath(tt.path, 0), Xpath2GnmiPath(tt.leaf, 0)); got != tt.exp {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.exp)
+		}
+	}
+	if f := GetLeafFilter(WithDefaults()); f != nil {
+		t.Errorf("GetLeafFilter without filter options: got %v, want nil", f)
+	}
+}
@@ -35,8 +35,9 @@ type updates struct {
 }
 
 // GetGranularUpdatesFromJSON provides an update per leaf level
-func GetGranularUpdatesFromJSON(p *gnmi.Path, d interface{}, rs *yentry.Entry) ([]*gnmi.Update, error) {
+func GetGranularUpdatesFromJSON(p *gnmi.Path, d interface{}, rs *yentry.Entry, opts ...JSONOption) ([]*gnmi.Update, error) {
 	var err error
+	d = ProcessJSON(rs, p, d, opts...)
 	updates := &updates{
 		upds: make([]*gnmi.Update, 0),
 	}
@@ -63,7 +64,8 @@ func getGranularUpdatesFromJSON(path *gnmi.Path, d interface{}, u *updates, rs *
 		for k, v := range p.GetElem()[len(p.GetElem())-1].GetKey() {
 			p := DeepCopyGnmiPath(p)
 
-			value, err := json.Marshal(v)
+			// union keys are encoded for the resolved member type
+			value, err := json.Marshal(encodeUnionLeaf(rs.GetEntry(p), k, v))
 			if err != nil {
 				return err
 			}
@@ -116,6 +118,9 @@ func getGranularUpdatesFromJSON(path *gnmi.Path, d interface{}, u *updates, rs *
 					}
 					if leaflist {
 						// leaflists are added as a single value
+						if rs != nil && !rs.GetEntry(p).IsOrderedByUser(k) {
+							val = SortLeafList(val)
+						}
 						value, err := json.Marshal(val)
 						if err != nil {
 							return err
@@ -128,6 +133,13 @@ func getGranularUpdatesFromJSON(path *gnmi.Path, d interface{}, u *updates, rs *
 				case map[string]interface{}:
 					newPath := DeepCopyGnmiPath(p)
 					newPath.Elem = append(newPath.GetElem(), &gnmi.PathElem{Name: k})
+					if len(val) == 0 && rs.GetEntry(newPath).GetPresence() {
+						// an empty presence container is kept as an empty object
+						u.upds = append(u.upds, &gnmi.Update{
+							Path: newPath,
+							Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte("{}")}},
+						})
+					}
 					err := getGranularUpdatesFromJSON(newPath, v, u, rs)
 					if err != nil {
 						return err
@@ -199,6 +211,9 @@ func getUpdatesFromJSON(p *gnmi.Path, d interface{}, u []*gnmi.Update, rs *yentr
 				}
 				if leaflist {
 					// leaflists are added as a single value
+					if rs != nil && !rs.GetEntry(p).IsOrderedByUser(k) {
+						val = SortLeafList(val)
+					}
 					v, err := json.Marshal(val)
 					if err != nil {
 						return nil, err
@@ -250,7 +265,7 @@ func getPathWithKeys(p *gnmi.Path, keys []string, k string, value map[string]int
 	if len(keys) != 0 {
 		pathKeys := make(map[string]string)
 		for _, key := range keys {
-			pathKeys[key] = fmt.Sprintf("%v", value[key])
+			pathKeys[key] = keyString(value[key])
 		}
 		return &gnmi.Path{
 			Elem: append(p.GetElem(), &gnmi.PathElem{
@@ -265,6 +280,22 @@ func getPathWithKeys(p *gnmi.Path, keys []string, k string, value map[string]int
 	//}
 }
 
+// keyString returns the value as it is rendered in a path key, numbers are
+// rendered without exponent so the key is the same whatever the member type
+// of a union.
+func keyString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case uint32:
+		return strconv.FormatUint(uint64(x), 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 // getUpdatesFromContainer
 // adds the keys to the path and deletes them from the data/json
 func getUpdatesFromContainer(path *gnmi.Path, value map[string]interface{}) (*gnmi.Update, error) {
@@ -275,14 +306,7 @@ func getUpdatesFromContainer(path *gnmi.Path, value map[string]interface{}) (*gn
 			for k := range p.GetElem()[len(p.GetElem())-1].GetKey() {
 				if v, ok := value[k]; ok {
 					// add Value to path
-					switch v := v.(type) {
-					case string:
-						p.GetElem()[len(p.GetElem())-1].GetKey()[k] = string(v)
-					case uint32:
-						p.GetElem()[len(p.GetElem())-1].GetKey()[k] = strconv.Itoa(int(v))
-					case float64:
-						p.GetElem()[len(p.GetElem())-1].GetKey()[k] = fmt.Sprintf("%.0f", v)
-					}
+					p.GetElem()[len(p.GetElem())-1].GetKey()[k] = keyString(v)
 					// delete element from the value
 					delete(value, k)
 				}
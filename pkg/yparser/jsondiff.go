@@ -21,9 +21,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/pkg/errors"
+	"github.com/yndd/ndd-yang/pkg/yentry"
 )
 
 const (
@@ -52,8 +54,102 @@ type Operation struct {
 	Value interface{}
 }
 
-func FindResourceDelta(updatesx1, updatesx2 []*gnmi.Update) ([]*gnmi.Path, []*gnmi.Update, error) {
+// DeltaOption modifies the comparison of FindResourceDelta.
+type DeltaOption func(*deltaOptions)
 
+type deltaOptions struct {
+	rs *yentry.Entry
+}
+
+// WithSchema compares the data according to the schema rs. Only the order of
+// lists and leaf-lists ordered by user is significant, without the schema the
+// order is ignored. A list ordered by user whose entries are observed in
+// another order is updated as a whole with the intended entries. The updates setting a member of a case delete the
+// members of the other cases of the choice which are present in the observed
+// data.
+func WithSchema(rs *yentry.Entry) DeltaOption {
+	return func(o *deltaOptions) {
+		o.rs = rs
+	}
+}
+
+func FindResourceDelta(updatesx1, updatesx2 []*gnmi.Update, opts ...DeltaOption) ([]*gnmi.Path, []*gnmi.Update, error) {
+	o := &deltaOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	deletes, updates, err := findResourceDelta(updatesx1, updatesx2, o)
+	if err != nil || o.rs == nil {
+		return deletes, updates, err
+	}
+	// the lists ordered by user with entries in another order are rewritten
+	// as a whole
+	reorders, err := getDeltaReorderUpdates(o.rs, updatesx1, updatesx2, updates)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the case deletes are applied before the other deletes and the updates
+	return append(observedCaseDeletes(o.rs, updates, updatesx2, deletes), deletes...), append(reorders, updates...), nil
+}
+
+// observedCaseDeletes returns the case deletes of the updates which are
+// present in the observed data and not deleted by the deletes yet.
+func observedCaseDeletes(rs *yentry.Entry, updates, observed []*gnmi.Update, deletes []*gnmi.Path) []*gnmi.Path {
+	cds := make([]*gnmi.Path, 0)
+	for _, d := range GetCaseDeletes(rs, updates) {
+		if !isDeleted(d, deletes) && isObserved(d, observed) {
+			cds = append(cds, d)
+		}
+	}
+	return cds
+}
+
+// isDeleted returns true if the path p or one of its parents is deleted.
+func isDeleted(p *gnmi.Path, deletes []*gnmi.Path) bool {
+	xp := GnmiPath2XPath(p, true)
+	for _, d := range deletes {
+		dxp := GnmiPath2XPath(d, true)
+		if xp == dxp || strings.HasPrefix(xp, dxp+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isObserved returns true if the observed updates hold data at or below the
+// path p, either as an update of p or below or as a member of the container
+// value of its parent.
+func isObserved(p *gnmi.Path, observed []*gnmi.Update) bool {
+	xp := GnmiPath2XPath(p, true)
+	parent := GnmiPath2XPath(&gnmi.Path{Elem: p.GetElem()[:len(p.GetElem())-1]}, true)
+	name := p.GetElem()[len(p.GetElem())-1].GetName()
+	for _, u := range observed {
+		uxp := GnmiPath2XPath(u.GetPath(), true)
+		if uxp == xp || strings.HasPrefix(uxp, xp+"/") {
+			return true
+		}
+		if uxp != parent {
+			continue
+		}
+		if x, ok := getJSONObject(u.GetVal()); ok {
+			if _, ok := x[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getJSONObject(v *gnmi.TypedValue) (map[string]interface{}, bool) {
+	d, err := GetValue(v)
+	if err != nil {
+		return nil, false
+	}
+	x, ok := d.(map[string]interface{})
+	return x, ok
+}
+
+func findResourceDelta(updatesx1, updatesx2 []*gnmi.Update, o *deltaOptions) ([]*gnmi.Path, []*gnmi.Update, error) {
 	deletes := make([]*gnmi.Path, 0)
 	updates := make([]*gnmi.Update, 0)
 	// First we check if there are paths, which are created but should not be there!
@@ -100,7 +196,7 @@ func FindResourceDelta(updatesx1, updatesx2 []*gnmi.Update) ([]*gnmi.Path, []*gn
 				if err != nil {
 					return nil, nil, err
 				}
-				patch, err := CompareJSONData(b1, b2)
+				patch, err := compareJSONData(b1, b2, o.rs != nil && IsOrderedByUser(o.rs, updatex1.GetPath()), true)
 				if err != nil {
 					return nil, nil, errors.Wrap(err, errJSONMarshalIndent)
 				}
@@ -181,6 +277,14 @@ func FindResourceDelta(updatesx1, updatesx2 []*gnmi.Update) ([]*gnmi.Path, []*gn
 
 // CompareJSONData compares the target with the source and provides operation guides
 func CompareJSONData(t, s []byte) ([]Operation, error) {
+	return compareJSONData(t, s, false, false)
+}
+
+// compareJSONData compares the target with the source, the order of a
+// leaf-list is only significant if it is ordered by user. A leaf-list with
+// elements which are only in the source differs if exact is set, otherwise
+// only the elements missing in the source are a difference.
+func compareJSONData(t, s []byte, orderedByUser, exact bool) ([]Operation, error) {
 	var x1, x2 interface{}
 	if err := json.Unmarshal(t, &x1); err != nil {
 		return nil, err
@@ -242,17 +346,26 @@ func CompareJSONData(t, s []byte) ([]Operation, error) {
 		// we expect only 1 element presnt
 		switch xx2 := x2.(type) {
 		case []interface{}:
-			for _, v1 := range xx1 {
-				found := false
-				for _, v2 := range xx2 {
-					if v1 == v2 {
-						found = true
-						break
+			// the leaf-list is reapplied as a whole
+			if !exact {
+				for _, v1 := range xx1 {
+					found := false
+					for _, v2 := range xx2 {
+						if v1 == v2 {
+							found = true
+							break
+						}
+					}
+					if !found {
+						operations = append(operations, Operation{Type: OperationTypeCreate})
 					}
 				}
-				if !found {
+			} else if orderedByUser {
+				if !reflect.DeepEqual(xx1, xx2) {
 					operations = append(operations, Operation{Type: OperationTypeCreate})
 				}
+			} else if !reflect.DeepEqual(SortLeafList(xx1), SortLeafList(xx2)) {
+				operations = append(operations, Operation{Type: OperationTypeCreate})
 			}
 		default:
 			// data is not present
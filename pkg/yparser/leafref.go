@@ -154,6 +154,17 @@ func getLeafRefPathRefernce(s *yang.Statement) (string, bool) {
 }
 
 func ValidateLeafRef(rootPath *gnmi.Path, x1, x2 interface{}, definedLeafRefs []*leafref.LeafRef, rs *yentry.Entry) (bool, []*leafref.ResolvedLeafRef, error) {
+	// the data cannot hold members of multiple cases of a choice
+	if err := ValidateChoice(rs, rootPath, x1); err != nil {
+		return false, nil, err
+	}
+	// the values must be valid for their type
+	if err := ValidateValues(rs, rootPath, x1); err != nil {
+		return false, nil, err
+	}
+	// the leafref values are compared without module prefixes
+	x1 = NormalizeValues(rs, rootPath, copyJSON(x1))
+	x2 = NormalizeValues(rs, &gnmi.Path{}, copyJSON(x2))
 	// a global indication if the leafRef resolution was successfull or not
 	// we are positive so we initialize to true
 	success := true
@@ -184,6 +195,7 @@ func ValidateLeafRef(rootPath *gnmi.Path, x1, x2 interface{}, definedLeafRefs []
 			//fmt.Printf("resolvedLeafRef remotePath: %s\n", GnmiPath2XPath(leafRef.RemotePath, true))
 			// Validate if the leaf ref is resolved
 			if resolvedLeafRef.Resolved {
+				resolvedLeafRef.Value = normalizeLeafRefValue(rs, leafRef.RemotePath, resolvedLeafRef.Value)
 				// validate if the leafref is local or external to the resource
 				var found bool
 				var remotePath *gnmi.Path
@@ -228,6 +240,15 @@ func ValidateLeafRef(rootPath *gnmi.Path, x1, x2 interface{}, definedLeafRefs []
 	return success, resultValidations, nil
 }
 
+// normalizeLeafRefValue returns the value v without the module prefix if the
+// remote leaf p is an identityref or enumeration.
+func normalizeLeafRefValue(rs *yentry.Entry, p *gnmi.Path, v string) string {
+	if s, ok := NormalizeLeafValue(rs, p, v).(string); ok {
+		return s
+	}
+	return v
+}
+
 func isRemoteLeafRefExternal(rootPath, remotePath *gnmi.Path) bool {
 	if strings.Contains(GnmiPath2XPath(remotePath, false), GnmiPath2XPath(rootPath, false)) {
 		// if the remotePath and the active Path match exactly we classify this in the external leafref category
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// StripModulePrefix returns the identity or enum value without the module
+// prefix, e.g. srl_nokia-if:ethernet becomes ethernet.
+func StripModulePrefix(v string) string {
+	if i := strings.Index(v, ":"); i >= 0 {
+		return v[i+1:]
+	}
+	return v
+}
+
+// isNormalized returns true if the values of the leaf name are canonicalized.
+func isNormalized(e *yentry.Entry, name string) bool {
+	_, ok := e.GetIdentities(name)
+	return ok || len(e.GetEnum(name)) != 0 || len(e.GetUnionTypes(name)) != 0
+}
+
+// NormalizeLeafValue returns the canonical value of the leaf or leaf-list at
+// path p, the module prefix is stripped from identityref and enumeration
+// values and union values are encoded for the resolved member type.
+func NormalizeLeafValue(rs *yentry.Entry, p *gnmi.Path, v interface{}) interface{} {
+	elems := p.GetElem()
+	if len(elems) == 0 {
+		return v
+	}
+	e := rs.GetEntry(&gnmi.Path{Elem: elems[:len(elems)-1]})
+	if !isNormalized(e, elems[len(elems)-1].GetName()) {
+		return v
+	}
+	return normalizeLeaf(e, elems[len(elems)-1].GetName(), v)
+}
+
+func normalizeLeaf(e *yentry.Entry, name string, v interface{}) interface{} {
+	if len(e.GetUnionTypes(name)) != 0 {
+		return encodeUnionLeaf(e, name, v)
+	}
+	return normalizeValue(v)
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		return StripModulePrefix(x)
+	case []interface{}:
+		l := make([]interface{}, 0, len(x))
+		for _, lv := range x {
+			l = append(l, normalizeValue(lv))
+		}
+		return l
+	}
+	return v
+}
+
+// NormalizeValues canonicalizes the identityref and enumeration values in the
+// data d of path p. The data is modified in place and returned.
+func NormalizeValues(rs *yentry.Entry, p *gnmi.Path, d interface{}) interface{} {
+	walkData(rs, p, d, func(e *yentry.Entry, x map[string]interface{}) {
+		for k, v := range x {
+			if isNormalized(e, k) {
+				x[k] = normalizeLeaf(e, k, v)
+			}
+		}
+	})
+	return d
+}
+
+// ValidateValues returns an error if an identityref value in the data d of
+// path p is not derived from the base of the identityref, an enumeration
+// value is not one of the enum values or a union value does not match any of
+// the member types.
+func ValidateValues(rs *yentry.Entry, p *gnmi.Path, d interface{}) error {
+	var err error
+	walkData(rs, p, d, func(e *yentry.Entry, x map[string]interface{}) {
+		for _, k := range sortedMapKeys(x) {
+			if err != nil {
+				return
+			}
+			vs, ok := x[k].([]interface{})
+			if !ok {
+				vs = []interface{}{x[k]}
+			}
+			if ts := e.GetUnionTypes(k); len(ts) != 0 {
+				for _, v := range vs {
+					if _, uerr := ResolveUnionType(ts, v); uerr != nil {
+						err = fmt.Errorf("%s: %v", k, uerr)
+						return
+					}
+				}
+				continue
+			}
+			allowed, identity := e.GetIdentities(k)
+			if !identity {
+				allowed = e.GetEnum(k)
+			}
+			if !identity && len(allowed) == 0 {
+				continue
+			}
+			for _, v := range vs {
+				if !contains(allowed, StripModulePrefix(fmt.Sprint(v))) {
+					if identity {
+						err = fmt.Errorf("%s: identity %v is not derived from the base", k, v)
+					} else {
+						err = fmt.Errorf("%s: %v is not a valid enum value", k, v)
+					}
+					return
+				}
+			}
+		}
+	})
+	return err
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
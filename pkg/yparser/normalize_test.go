@@ -0,0 +1,104 @@
+package yparser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/leafref"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+func normalizeSchema() *yentry.Entry {
+	rs := testSchema()
+	itfce := rs.Children["interface"]
+	itfce.Defaults = map[string]string{"type": "ethernet"}
+	itfce.Enums = map[string][]string{"admin-state": {"enable", "disable"}}
+	itfce.IdentityRefs = map[string][]string{"type": {"ethernet", "loopback"}, "features": {"lldp", "lacp"}}
+	return rs
+}
+
+func TestNormalizeValues(t *testing.T) {
+	rs := normalizeSchema()
+	p := Xpath2GnmiPath("/interface[name=e1]", 0)
+	d := map[string]interface{}{
+		"type":        "srl_nokia-if:ethernet",
+		"features":    []interface{}{"srl_nokia-if:lldp", "lacp"},
+		"description": "a:b",
+	}
+	exp := map[string]interface{}{
+		"features":    []interface{}{"lldp", "lacp"},
+		"description": "a:b",
+	}
+	// the canonical value equals the default and is stripped
+	if got := ProcessJSON(rs, p, d, WithNormalizedValues(), WithoutDefaults()); !reflect.DeepEqual(got, exp) {
+		t.Errorf("NormalizeValues: got %v, want %v", got, exp)
+	}
+	if got := NormalizeLeafValue(rs, Xpath2GnmiPath("/interface[name=e1]/admin-state", 0), "x:enable"); got != "enable" {
+		t.Errorf("NormalizeLeafValue: got %v, want enable", got)
+	}
+	if got := NormalizeLeafValue(rs, Xpath2GnmiPath("/interface[name=e1]/description", 0), "x:enable"); got != "x:enable" {
+		t.Errorf("NormalizeLeafValue: got %v, want x:enable", got)
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	rs := normalizeSchema()
+	p := Xpath2GnmiPath("/interface[name=e1]", 0)
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		err  bool
+	}{
+		{name: "valid", data: map[string]interface{}{"type": "srl_nokia-if:loopback", "admin-state": "enable", "features": []interface{}{"lacp"}}},
+		{name: "identity", data: map[string]interface{}{"type": "srl_nokia-if:vlan"}, err: true},
+		{name: "identity leaf-list", data: map[string]interface{}{"features": []interface{}{"lacp", "stp"}}, err: true},
+		{name: "enum", data: map[string]interface{}{"admin-state": "up"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateValues(rs, p, tt.data); (err != nil) != tt.err {
+				t.Errorf("ValidateValues: got %v, want error %t", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateLeafRefValues(t *testing.T) {
+	rs := normalizeSchema()
+	addEntry(rs, &yentry.Entry{Name: "profile", Key: []string{"type"},
+		IdentityRefs: map[string][]string{"type": {"gold", "silver"}},
+	})
+	p := Xpath2GnmiPath("/interface[name=e1]", 0)
+	lrs := []*leafref.LeafRef{{
+		LocalPath:  Xpath2GnmiPath("/profile", 0),
+		RemotePath: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "profile", Key: map[string]string{"type": ""}}, {Name: "type"}}},
+	}}
+	x2 := map[string]interface{}{"profile": []interface{}{map[string]interface{}{"type": "srl_qos:gold"}}}
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		success bool
+		err     bool
+	}{
+		{name: "prefixed", data: map[string]interface{}{"profile": "srl:gold"}, success: true},
+		{name: "unprefixed", data: map[string]interface{}{"profile": "gold"}, success: true},
+		{name: "missing", data: map[string]interface{}{"profile": "silver"}},
+		{name: "invalid value", data: map[string]interface{}{"profile": "gold", "admin-state": "up"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			success, _, err := ValidateLeafRef(p, tt.data, x2, lrs, rs)
+			if (err != nil) != tt.err {
+				t.Fatalf("ValidateLeafRef: got %v, want error %t", err, tt.err)
+			}
+			if success != tt.success {
+				t.Errorf("ValidateLeafRef: got %t, want %t", success, tt.success)
+			}
+		})
+	}
+	// the data is not modified
+	if x2["profile"].([]interface{})[0].(map[string]interface{})["type"] != "srl_qos:gold" {
+		t.Errorf("ValidateLeafRef: modified the data %v", x2)
+	}
+}
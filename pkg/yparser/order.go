@@ -0,0 +1,307 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// IsOrderedByUser returns true if the list or leaf-list at path p is ordered
+// by user.
+func IsOrderedByUser(rs *yentry.Entry, p *gnmi.Path) bool {
+	elems := p.GetElem()
+	if len(elems) == 0 {
+		return false
+	}
+	return rs.GetEntry(&gnmi.Path{Elem: elems[:len(elems)-1]}).IsOrderedByUser(elems[len(elems)-1].GetName())
+}
+
+// NormalizeOrder sorts the leaf-lists and lists ordered by system in the data
+// d of path p, leaf-lists by value and lists by key. The data is modified in
+// place and returned.
+func NormalizeOrder(rs *yentry.Entry, p *gnmi.Path, d interface{}) interface{} {
+	walkData(rs, p, d, func(e *yentry.Entry, x map[string]interface{}) {
+		for k, v := range x {
+			l, ok := v.([]interface{})
+			if !ok || e.IsOrderedByUser(k) {
+				continue
+			}
+			keys := e.GetChildren()[k].GetKey()
+			sort.SliceStable(l, func(i, j int) bool {
+				return lessValue(listSortKey(l[i], keys), listSortKey(l[j], keys))
+			})
+		}
+	})
+	return d
+}
+
+// SortLeafList returns a sorted copy of the leaf-list values.
+func SortLeafList(l []interface{}) []interface{} {
+	s := make([]interface{}, len(l))
+	copy(s, l)
+	sort.SliceStable(s, func(i, j int) bool {
+		return lessValue(s[i], s[j])
+	})
+	return s
+}
+
+// listSortKey returns the value of a leaf-list entry or the key values of a
+// list entry.
+func listSortKey(v interface{}, keys []string) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	ks := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ks = append(ks, fmt.Sprint(m[k]))
+	}
+	return strings.Join(ks, ",")
+}
+
+func lessValue(a, b interface{}) bool {
+	af, aok := a.(float64)
+	bf, bok := b.(float64)
+	if aok && bok {
+		return af < bf
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// GetReorderUpdates returns an update replacing the list or leaf-list with the
+// intended entries for every list and leaf-list ordered by user in the
+// intended data of path p whose entries are ordered differently in the
+// observed data. Entries which are not present in both are not considered,
+// they are handled by the regular delta.
+func GetReorderUpdates(rs *yentry.Entry, p *gnmi.Path, intended, observed interface{}) ([]*gnmi.Update, error) {
+	upds := make([]*gnmi.Update, 0)
+	if err := getReorderUpdates(rs.GetEntry(p), p, intended, observed, &upds); err != nil {
+		return nil, err
+	}
+	return upds, nil
+}
+
+func getReorderUpdates(e *yentry.Entry, p *gnmi.Path, intended, observed interface{}, upds *[]*gnmi.Update) error {
+	if e == nil {
+		return nil
+	}
+	xi, ok := intended.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	xo, _ := observed.(map[string]interface{})
+	for _, k := range sortedMapKeys(xi) {
+		c := e.GetChildren()[k]
+		cp := &gnmi.Path{Elem: append(DeepCopyGnmiPath(p).GetElem(), &gnmi.PathElem{Name: k})}
+		switch vi := xi[k].(type) {
+		case map[string]interface{}:
+			if err := getReorderUpdates(c, cp, vi, xo[k], upds); err != nil {
+				return err
+			}
+		case []interface{}:
+			vo, _ := xo[k].([]interface{})
+			keys := c.GetKey()
+			if e.IsOrderedByUser(k) && !sameOrder(vi, vo, keys) {
+				b, err := json.Marshal(vi)
+				if err != nil {
+					return err
+				}
+				*upds = append(*upds, &gnmi.Update{
+					Path: cp,
+					Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: b}},
+				})
+			}
+			// the list entries can hold ordered lists as well
+			if len(keys) == 0 {
+				continue
+			}
+			for _, ei := range vi {
+				mi, ok := ei.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				ep, err := getPathWithKeys(DeepCopyGnmiPath(p), keys, k, mi)
+				if err != nil {
+					return err
+				}
+				if err := getReorderUpdates(c, ep, mi, findListEntry(vo, keys, mi), upds); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getDeltaReorderUpdates returns the reorder updates of the intended and
+// observed updates of a resource delta. The updates hold a container or list
+// entry each, the entries of a list are in the order of their first update.
+// A reorder update of a path which is already updated is left out.
+func getDeltaReorderUpdates(rs *yentry.Entry, intended, observed, updates []*gnmi.Update) ([]*gnmi.Update, error) {
+	xi, err := updatesJSON(rs, intended)
+	if err != nil {
+		return nil, err
+	}
+	xo, err := updatesJSON(rs, observed)
+	if err != nil {
+		return nil, err
+	}
+	upds, err := GetReorderUpdates(rs, &gnmi.Path{}, xi, xo)
+	if err != nil {
+		return nil, err
+	}
+	updated := make(map[string]bool, len(updates))
+	for _, u := range updates {
+		updated[GnmiPath2XPath(u.GetPath(), true)] = true
+	}
+	reorders := make([]*gnmi.Update, 0, len(upds))
+	for _, u := range upds {
+		if !updated[GnmiPath2XPath(u.GetPath(), true)] {
+			reorders = append(reorders, u)
+		}
+	}
+	return reorders, nil
+}
+
+// updatesJSON returns the data from the root of the updates, the key values of
+// the list entries are typed from the schema rs.
+func updatesJSON(rs *yentry.Entry, upds []*gnmi.Update) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	for _, u := range upds {
+		v, err := GetValue(u.GetVal())
+		if err != nil {
+			return nil, err
+		}
+		x := root
+		elems := u.GetPath().GetElem()
+		for i, pe := range elems {
+			last := i == len(elems)-1
+			if len(pe.GetKey()) == 0 {
+				if last {
+					if m, ok := v.(map[string]interface{}); ok {
+						x[pe.GetName()] = mergeJSON(x[pe.GetName()], m)
+					} else {
+						x[pe.GetName()] = v
+					}
+					break
+				}
+				c, ok := x[pe.GetName()].(map[string]interface{})
+				if !ok {
+					c = make(map[string]interface{})
+					x[pe.GetName()] = c
+				}
+				x = c
+				continue
+			}
+			l, _ := x[pe.GetName()].([]interface{})
+			entry := findKeyedEntry(l, pe.GetKey())
+			if entry == nil {
+				e := rs.GetEntry(&gnmi.Path{Elem: elems[:i+1]})
+				entry = make(map[string]interface{}, len(pe.GetKey()))
+				for k, kv := range pe.GetKey() {
+					entry[k] = typedDefault(e.GetLeafType(k), kv)
+				}
+				x[pe.GetName()] = append(l, entry)
+			}
+			if m, ok := v.(map[string]interface{}); ok && last {
+				mergeJSON(entry, m)
+			}
+			x = entry
+		}
+	}
+	return root, nil
+}
+
+// mergeJSON adds the members of m to the container x and returns it.
+func mergeJSON(x interface{}, m map[string]interface{}) map[string]interface{} {
+	c, ok := x.(map[string]interface{})
+	if !ok {
+		c = make(map[string]interface{}, len(m))
+	}
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// findKeyedEntry returns the list entry with the key values of a path element.
+func findKeyedEntry(l []interface{}, keys map[string]string) map[string]interface{} {
+	for _, v := range l {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		found := true
+		for k, kv := range keys {
+			if keyString(m[k]) != kv {
+				found = false
+				break
+			}
+		}
+		if found {
+			return m
+		}
+	}
+	return nil
+}
+
+// sameOrder returns true if the entries present in both a and b have the same
+// relative order.
+func sameOrder(a, b []interface{}, keys []string) bool {
+	idx := make(map[string]int)
+	for i, v := range b {
+		idx[fmt.Sprint(listSortKey(v, keys))] = i
+	}
+	last := -1
+	for _, v := range a {
+		i, ok := idx[fmt.Sprint(listSortKey(v, keys))]
+		if !ok {
+			continue
+		}
+		if i < last {
+			return false
+		}
+		last = i
+	}
+	return true
+}
+
+func findListEntry(l []interface{}, keys []string, m map[string]interface{}) interface{} {
+	k := listSortKey(m, keys)
+	for _, v := range l {
+		if reflect.DeepEqual(listSortKey(v, keys), k) {
+			return v
+		}
+	}
+	return nil
+}
+
+func sortedMapKeys(x map[string]interface{}) []string {
+	keys := make([]string, 0, len(x))
+	for k := range x {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
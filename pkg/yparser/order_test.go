@@ -0,0 +1,212 @@
+package yparser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// policy is a list ordered by user with a leaf-list ordered by system, the
+// statements are a list ordered by user with a leaf-list ordered by user
+func orderSchema() *yentry.Entry {
+	rs := testSchema()
+	rs.OrderedByUser = map[string]bool{"policy": false}
+	policy := addEntry(rs, &yentry.Entry{Name: "policy", Key: []string{"name"},
+		OrderedByUser: map[string]bool{"statement": true}})
+	addEntry(policy, &yentry.Entry{Name: "statement", Key: []string{"seq"},
+		OrderedByUser: map[string]bool{"community": true}})
+	return rs
+}
+
+func mustJSON(t *testing.T, s string) interface{} {
+	var x interface{}
+	if err := json.Unmarshal([]byte(s), &x); err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestNormalizeOrder(t *testing.T) {
+	rs := orderSchema()
+	d := mustJSON(t, `{"policy":[
+		{"name":"b","prefix":["10.0.0.0/8","1.0.0.0/8"],"statement":[{"seq":2},{"seq":1}]},
+		{"name":"a","statement":[{"seq":10,"community":["z","a"]}]}]}`)
+	exp := mustJSON(t, `{"policy":[
+		{"name":"a","statement":[{"seq":10,"community":["z","a"]}]},
+		{"name":"b","prefix":["1.0.0.0/8","10.0.0.0/8"],"statement":[{"seq":2},{"seq":1}]}]}`)
+	if got := ProcessJSON(rs, &gnmi.Path{}, d, WithNormalizedOrder()); !reflect.DeepEqual(got, exp) {
+		t.Errorf("NormalizeOrder: got %v, want %v", got, exp)
+	}
+}
+
+func TestGetReorderUpdates(t *testing.T) {
+	rs := orderSchema()
+	p := Xpath2GnmiPath("/policy[name=a]", 0)
+	tests := []struct {
+		name     string
+		intended string
+		observed string
+		exp      map[string]string
+	}{
+		{
+			name:     "same order",
+			intended: `{"statement":[{"seq":1},{"seq":2}]}`,
+			observed: `{"statement":[{"seq":1},{"seq":3},{"seq":2}]}`,
+			exp:      map[string]string{},
+		},
+		{
+			name:     "list ordered by user",
+			intended: `{"statement":[{"seq":2},{"seq":1}]}`,
+			observed: `{"statement":[{"seq":1},{"seq":2}]}`,
+			exp:      map[string]string{"/policy[name=a]/statement": `[{"seq":2},{"seq":1}]`},
+		},
+		{
+			name:     "leaf-list ordered by user",
+			intended: `{"statement":[{"seq":1,"community":["b","a"]}]}`,
+			observed: `{"statement":[{"seq":1,"community":["a","b"]}]}`,
+			exp:      map[string]string{"/policy[name=a]/statement[seq=1]/community": `["b","a"]`},
+		},
+		{
+			name:     "leaf-list ordered by system",
+			intended: `{"prefix":["b","a"]}`,
+			observed: `{"prefix":["a","b"]}`,
+			exp:      map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upds, err := GetReorderUpdates(rs, p, mustJSON(t, tt.intended), mustJSON(t, tt.observed))
+			if err != nil {
+				t.Fatalf("GetReorderUpdates: %v", err)
+			}
+			got := make(map[string]string)
+			for _, u := range upds {
+				got[GnmiPath2XPath(u.GetPath(), true)] = string(u.GetVal().GetJsonIetfVal())
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("GetReorderUpdates: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestFindResourceDeltaOrder(t *testing.T) {
+	rs := orderSchema()
+	leaflist := func(xpath, v string) []*gnmi.Update {
+		return []*gnmi.Update{{
+			Path: Xpath2GnmiPath(xpath, 0),
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(v)}},
+		}}
+	}
+	tests := []struct {
+		name     string
+		xpath    string
+		intended string
+		observed string
+		opts     []DeltaOption
+		updates  int
+	}{
+		{name: "system reordered", xpath: "/policy[name=a]/prefix", intended: `["a","b"]`, observed: `["b","a"]`, opts: []DeltaOption{WithSchema(rs)}},
+		{name: "system extra", xpath: "/policy[name=a]/prefix", intended: `["a","b"]`, observed: `["b","a","c"]`, opts: []DeltaOption{WithSchema(rs)}, updates: 1},
+		{name: "user reordered", xpath: "/policy[name=a]/statement[seq=1]/community", intended: `["a","b"]`, observed: `["b","a"]`, opts: []DeltaOption{WithSchema(rs)}, updates: 1},
+		{name: "no schema", xpath: "/policy[name=a]/statement[seq=1]/community", intended: `["a","b"]`, observed: `["b","a"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, upds, err := FindResourceDelta(leaflist(tt.xpath, tt.intended), leaflist(tt.xpath, tt.observed), tt.opts...)
+			if err != nil {
+				t.Fatalf("FindResourceDelta: %v", err)
+			}
+			if len(upds) != tt.updates {
+				t.Errorf("FindResourceDelta: got %d updates, want %d", len(upds), tt.updates)
+			}
+		})
+	}
+}
+
+func TestCompareJSONDataLeafList(t *testing.T) {
+	tests := []struct {
+		name     string
+		intended string
+		observed string
+		ops      int
+	}{
+		{name: "same", intended: `["a","b"]`, observed: `["a","b"]`},
+		{name: "reordered", intended: `["a","b"]`, observed: `["b","a"]`},
+		// the elements which are only observed are not a difference
+		{name: "extra", intended: `["a","b"]`, observed: `["b","a","c"]`},
+		{name: "missing", intended: `["a","b"]`, observed: `["a"]`, ops: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := CompareJSONData([]byte(tt.intended), []byte(tt.observed))
+			if err != nil {
+				t.Fatalf("CompareJSONData: %v", err)
+			}
+			if len(ops) != tt.ops {
+				t.Errorf("CompareJSONData: got %v, want %d operations", ops, tt.ops)
+			}
+		})
+	}
+}
+
+func TestFindResourceDeltaReorder(t *testing.T) {
+	rs := orderSchema()
+	rs.Children["policy"].LeafTypes = map[string]string{"name": "string"}
+	rs.Children["policy"].Children["statement"].LeafTypes = map[string]string{"seq": "uint32"}
+	p := Xpath2GnmiPath("/policy[name=a]", 0)
+	tests := []struct {
+		name     string
+		intended string
+		observed string
+		exp      map[string]string
+	}{
+		{
+			name:     "same order",
+			intended: `{"name":"a","statement":[{"seq":1,"action":"accept"},{"seq":2}]}`,
+			observed: `{"name":"a","statement":[{"seq":1,"action":"accept"},{"seq":2}]}`,
+			exp:      map[string]string{},
+		},
+		{
+			name:     "reordered",
+			intended: `{"name":"a","statement":[{"seq":2},{"seq":1,"action":"accept"}]}`,
+			observed: `{"name":"a","statement":[{"seq":1,"action":"accept"},{"seq":2}]}`,
+			exp:      map[string]string{"/policy[name=a]/statement": `[{"seq":2},{"action":"accept","seq":1}]`},
+		},
+		{
+			name:     "reordered and changed",
+			intended: `{"name":"a","statement":[{"seq":2},{"seq":1,"action":"reject"}]}`,
+			observed: `{"name":"a","statement":[{"seq":1,"action":"accept"},{"seq":2}]}`,
+			exp: map[string]string{
+				"/policy[name=a]/statement":               `[{"seq":2},{"action":"reject","seq":1}]`,
+				"/policy[name=a]/statement[seq=1]/action": `"reject"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intended, err := GetUpdatesFromJSON(p, mustJSON(t, tt.intended), rs)
+			if err != nil {
+				t.Fatalf("GetUpdatesFromJSON: %v", err)
+			}
+			observed, err := GetUpdatesFromJSON(p, mustJSON(t, tt.observed), rs)
+			if err != nil {
+				t.Fatalf("GetUpdatesFromJSON: %v", err)
+			}
+			_, upds, err := FindResourceDelta(intended, observed, WithSchema(rs))
+			if err != nil {
+				t.Fatalf("FindResourceDelta: %v", err)
+			}
+			got := make(map[string]string)
+			for _, u := range upds {
+				got[GnmiPath2XPath(u.GetPath(), true)] = string(u.GetVal().GetJsonIetfVal())
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("FindResourceDelta: got %v, want %v", got, tt.exp)
+			}
+		})
+	}
+}
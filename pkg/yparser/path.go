@@ -70,14 +70,14 @@ func Xpath2GnmiPath(xpath string, offset int) (path *gnmi.Path) {
 						for _, eWithKey := range s2 {
 							// TODO if there is a "/" in the name of the path
 							s := strings.Split(eWithKey, "=")
+							// a predicate without value, e.g. [oper-state] or
+							// [mtu>9000], is a key with an empty value
 							var v string
-							if strings.Contains(s[1], "]") {
+							if len(s) > 1 {
 								v = strings.Trim(s[1], "]")
-							} else {
-								v = s[1]
 							}
 							// trim blanks from the final element/key/value
-							pathElem.Key[strings.Trim(s[0], " ")] = strings.Trim(v, " ")
+							pathElem.Key[strings.Trim(strings.Trim(s[0], "]"), " ")] = strings.Trim(v, " ")
 						}
 					} else {
 						// trim blanks from the final element/key/value
@@ -0,0 +1,309 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/pkg/errors"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+const (
+	// errors
+	errPredicateRegex = "cannot compile the predicate regex"
+	errPredicateLeaf  = "predicate without leaf"
+)
+
+// PredicateOp is the operator of a predicate.
+type PredicateOp string
+
+const (
+	PredicateEqual        PredicateOp = "="
+	PredicateNotEqual     PredicateOp = "!="
+	PredicateRegex        PredicateOp = "~="
+	PredicateLess         PredicateOp = "<"
+	PredicateLessEqual    PredicateOp = "<="
+	PredicateGreater      PredicateOp = ">"
+	PredicateGreaterEqual PredicateOp = ">="
+	PredicateExists       PredicateOp = ""
+)
+
+// Predicate selects the entries of a list by the value of a leaf of the list
+// entry. A predicate on a leaf which is not present in the list entry does not
+// match, also for the not-equal operator.
+type Predicate struct {
+	// List is the path of the list from the root, the keys of the list
+	// element can be left out or be a wildcard
+	List *gnmi.Path
+	// Leaf is the path of the leaf relative to the list entry
+	Leaf  *gnmi.Path
+	Op    PredicateOp
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewPredicate returns the predicate on the leaf, e.g. "state/oper-state", of
+// the entries of the list.
+func NewPredicate(list *gnmi.Path, leaf string, op PredicateOp, value string) (*Predicate, error) {
+	p := &Predicate{
+		List:  list,
+		Leaf:  Xpath2GnmiPath("/"+strings.Trim(leaf, "/"), 0),
+		Op:    op,
+		Value: value,
+	}
+	if len(p.Leaf.GetElem()) == 0 {
+		return nil, errors.New(errPredicateLeaf)
+	}
+	if op == PredicateRegex {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, errors.Wrap(err, errPredicateRegex)
+		}
+		p.re = re
+	}
+	return p, nil
+}
+
+// WithPredicates only keeps the list entries matching all predicates.
+func WithPredicates(ps ...*Predicate) JSONOption {
+	return func(o *jsonOptions) {
+		o.predicates = append(o.predicates, ps...)
+	}
+}
+
+// GetPredicates returns the predicates of the options. The predicates are
+// applied by the queries reading the data, ProcessJSON does not apply them.
+func GetPredicates(opts ...JSONOption) []*Predicate {
+	o := &jsonOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o.predicates
+}
+
+// ParsePredicates returns the path p of a query with prefix without its
+// textual predicates and the predicates. A key of a list element which is not
+// a key of the list in the schema rs is a predicate on the leaf with the key
+// name, e.g. interface[oper-state=down]. The operators are
+// [leaf=v], [leaf!=v], [leaf~=regex], [leaf<v], [leaf<=v], [leaf>v], [leaf>=v]
+// and [leaf] for a leaf which exists. The schema keys which are left out of a
+// list element with predicates are set to a wildcard. Without schema the keys
+// are kept as is.
+func ParsePredicates(rs *yentry.Entry, prefix, p *gnmi.Path) (*gnmi.Path, []*Predicate, error) {
+	if rs == nil {
+		return p, nil, nil
+	}
+	var ps []*Predicate
+	elems := make([]*gnmi.PathElem, 0, len(p.GetElem()))
+	for _, pe := range p.GetElem() {
+		list := append(append([]*gnmi.PathElem{}, prefix.GetElem()...), elems...)
+		list = append(list, &gnmi.PathElem{Name: pe.GetName()})
+		e := rs.GetEntry(&gnmi.Path{Elem: list})
+		if e == nil || len(pe.GetKey()) == 0 {
+			elems = append(elems, pe)
+			continue
+		}
+		keys := e.GetKey()
+		if len(keys) == 0 {
+			elems = append(elems, pe)
+			continue
+		}
+		var lps []*Predicate
+		ne := &gnmi.PathElem{Name: pe.GetName(), Key: map[string]string{}}
+		for k, v := range pe.GetKey() {
+			if contains(keys, k) {
+				ne.Key[k] = v
+				continue
+			}
+			leaf, op, value := parsePredicate(k, v)
+			lp, err := NewPredicate(&gnmi.Path{Elem: list}, leaf, op, value)
+			if err != nil {
+				return nil, nil, err
+			}
+			lps = append(lps, lp)
+		}
+		if len(lps) == 0 {
+			elems = append(elems, pe)
+			continue
+		}
+		for _, k := range keys {
+			if _, ok := ne.Key[k]; !ok {
+				ne.Key[k] = "*"
+			}
+		}
+		list[len(list)-1] = ne
+		// the predicates of an element are ordered by leaf for stable results
+		sort.Slice(lps, func(i, j int) bool {
+			return GnmiPath2XPath(lps[i].Leaf, false) < GnmiPath2XPath(lps[j].Leaf, false)
+		})
+		ps = append(ps, lps...)
+		elems = append(elems, ne)
+	}
+	if len(ps) == 0 {
+		return p, nil, nil
+	}
+	return &gnmi.Path{Origin: p.GetOrigin(), Target: p.GetTarget(), Elem: elems}, ps, nil
+}
+
+// parsePredicate returns the leaf, operator and value of the key k with value
+// v of a textual predicate, the operator characters before the "=" are part of
+// the key name.
+func parsePredicate(k, v string) (string, PredicateOp, string) {
+	if v == "" {
+		if i := strings.IndexAny(k, "<>"); i > 0 {
+			return strings.TrimSpace(k[:i]), PredicateOp(k[i : i+1]), strings.TrimSpace(k[i+1:])
+		}
+		return k, PredicateExists, ""
+	}
+	ops := map[string]PredicateOp{
+		"!": PredicateNotEqual,
+		"~": PredicateRegex,
+		"<": PredicateLessEqual,
+		">": PredicateGreaterEqual,
+	}
+	if op, ok := ops[k[len(k)-1:]]; ok {
+		return strings.TrimSpace(k[:len(k)-1]), op, v
+	}
+	return k, PredicateEqual, v
+}
+
+// Entry returns the path elements of the list entry of the leaf path l, false
+// if l is not below an entry of the list of the predicate.
+func (p *Predicate) Entry(l []*gnmi.PathElem) ([]*gnmi.PathElem, bool) {
+	list := p.List.GetElem()
+	if len(l) <= len(list) {
+		return nil, false
+	}
+	for i, pe := range list {
+		if pe.GetName() != l[i].GetName() {
+			return nil, false
+		}
+		for k, v := range pe.GetKey() {
+			if v != "*" && l[i].GetKey()[k] != v {
+				return nil, false
+			}
+		}
+	}
+	return l[:len(list)], true
+}
+
+// IsLeaf returns true if the leaf path l is the leaf of the predicate of its
+// list entry.
+func (p *Predicate) IsLeaf(l []*gnmi.PathElem) bool {
+	entry, ok := p.Entry(l)
+	if !ok || len(l) != len(entry)+len(p.Leaf.GetElem()) {
+		return false
+	}
+	for i, pe := range p.Leaf.GetElem() {
+		if pe.GetName() != l[len(entry)+i].GetName() {
+			return false
+		}
+	}
+	return true
+}
+
+// LeafPath returns the path of the leaf of the predicate in the list entry.
+func (p *Predicate) LeafPath(entry []*gnmi.PathElem) *gnmi.Path {
+	return &gnmi.Path{Elem: append(append([]*gnmi.PathElem{}, entry...), p.Leaf.GetElem()...)}
+}
+
+// Match returns true if the leaf value v matches the predicate, a nil value is
+// a leaf which is not present.
+func (p *Predicate) Match(v *gnmi.TypedValue) bool {
+	if v == nil {
+		return false
+	}
+	if p.Op == PredicateExists {
+		return true
+	}
+	s, err := valueString(v)
+	if err != nil {
+		return false
+	}
+	switch p.Op {
+	case PredicateEqual:
+		return equalValue(s, p.Value)
+	case PredicateNotEqual:
+		return !equalValue(s, p.Value)
+	case PredicateRegex:
+		return p.re != nil && p.re.MatchString(s)
+	}
+	a, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false
+	}
+	b, err := strconv.ParseFloat(p.Value, 64)
+	if err != nil {
+		return false
+	}
+	switch p.Op {
+	case PredicateLess:
+		return a < b
+	case PredicateLessEqual:
+		return a <= b
+	case PredicateGreater:
+		return a > b
+	case PredicateGreaterEqual:
+		return a >= b
+	}
+	return false
+}
+
+func (p *Predicate) String() string {
+	return fmt.Sprintf("%s[%s%s%s]", GnmiPath2XPath(p.List, true), strings.TrimPrefix(GnmiPath2XPath(p.Leaf, false), "/"), p.Op, p.Value)
+}
+
+// equalValue compares the values as numbers if both are numbers, e.g. the
+// float64 of a JSON number with an integer.
+func equalValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	fa, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+	fb, err := strconv.ParseFloat(b, 64)
+	return err == nil && fa == fb
+}
+
+// valueString returns the scalar value of v as string.
+func valueString(v *gnmi.TypedValue) (string, error) {
+	d, err := GetValue(v)
+	if err != nil {
+		return "", err
+	}
+	switch x := d.(type) {
+	case string:
+		return x, nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32), nil
+	case *gnmi.Decimal64:
+		return strconv.FormatFloat(float64(x.GetDigits())/math.Pow10(int(x.GetPrecision())), 'f', -1, 64), nil
+	}
+	return fmt.Sprint(d), nil
+}
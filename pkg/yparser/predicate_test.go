@@ -0,0 +1,146 @@
+package yparser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+func predicateSchema() *yentry.Entry {
+	rs := testSchema()
+	itfce := rs.Children["interface"]
+	itfce.LeafTypes["oper-state"] = "string"
+	itfce.LeafTypes["mtu"] = "uint16"
+	addEntry(itfce, &yentry.Entry{Name: "subinterface", Key: []string{"index"},
+		LeafTypes: map[string]string{"index": "uint32", "oper-state": "string"},
+	})
+	addEntry(rs, &yentry.Entry{Name: "system"})
+	return rs
+}
+
+func TestParsePredicates(t *testing.T) {
+	rs := predicateSchema()
+	tests := []struct {
+		xpath string
+		rs    *yentry.Entry
+		path  string
+		preds []string
+	}{
+		{xpath: "/interface[name=e1]/subinterface[index=0]", rs: rs, path: "/interface[name=e1]/subinterface[index=0]"},
+		{xpath: "/interface[oper-state=down]", rs: rs, path: "/interface[name=*]", preds: []string{"/interface[name=*][oper-state=down]"}},
+		{xpath: "/interface[oper-state!=up]/subinterface", rs: rs, path: "/interface[name=*]/subinterface",
+			preds: []string{"/interface[name=*][oper-state!=up]"}},
+		{xpath: "/interface[name=e1]/subinterface[oper-state~=^d.*]", rs: rs, path: "/interface[name=e1]/subinterface[index=*]",
+			preds: []string{"/interface[name=e1]/subinterface[index=*][oper-state~=^d.*]"}},
+		{xpath: "/interface[mtu>9000,oper-state]", rs: rs, path: "/interface[name=*]",
+			preds: []string{"/interface[name=*][mtu>9000]", "/interface[name=*][oper-state]"}},
+		{xpath: "/interface[mtu<=1500]", rs: rs, path: "/interface[name=*]", preds: []string{"/interface[name=*][mtu<=1500]"}},
+		{xpath: "/interface[mtu>=1500]", rs: rs, path: "/interface[name=*]", preds: []string{"/interface[name=*][mtu>=1500]"}},
+		{xpath: "/interface[mtu<1500]", rs: rs, path: "/interface[name=*]", preds: []string{"/interface[name=*][mtu<1500]"}},
+		// the keys of elements which are not lists and without schema are kept
+		{xpath: "/system[hostname=x]", rs: rs, path: "/system[hostname=x]"},
+		{xpath: "/interface[oper-state=down]", path: "/interface[oper-state=down]"},
+	}
+	for _, tt := range tests {
+		p, ps, err := ParsePredicates(tt.rs, &gnmi.Path{}, Xpath2GnmiPath(tt.xpath, 0))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.xpath, err)
+		}
+		if got := GnmiPath2XPath(p, true); got != tt.path {
+			t.Errorf("%s: path got %s, want %s", tt.xpath, got, tt.path)
+		}
+		var preds []string
+		for _, pr := range ps {
+			preds = append(preds, pr.String())
+		}
+		if !reflect.DeepEqual(preds, tt.preds) {
+			t.Errorf("%s: predicates got %v, want %v", tt.xpath, preds, tt.preds)
+		}
+	}
+
+	if _, _, err := ParsePredicates(rs, &gnmi.Path{}, Xpath2GnmiPath("/interface[oper-state~=(]", 0)); err == nil {
+		t.Errorf("invalid regex: got no error")
+	}
+}
+
+func TestPredicateMatch(t *testing.T) {
+	str := func(s string) *gnmi.TypedValue {
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: s}}
+	}
+	num := func(v uint64) *gnmi.TypedValue { return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: v}} }
+	blob := func(s string) *gnmi.TypedValue {
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(s)}}
+	}
+	tests := []struct {
+		op    PredicateOp
+		value string
+		v     *gnmi.TypedValue
+		exp   bool
+	}{
+		{op: PredicateEqual, value: "down", v: str("down"), exp: true},
+		{op: PredicateEqual, value: "down", v: str("up")},
+		{op: PredicateEqual, value: "down"},
+		{op: PredicateEqual, value: "1500", v: blob("1500"), exp: true},
+		{op: PredicateEqual, value: "down", v: blob(`"down"`), exp: true},
+		{op: PredicateNotEqual, value: "established", v: str("idle"), exp: true},
+		{op: PredicateNotEqual, value: "established", v: str("established")},
+		// a leaf which is not present does not match
+		{op: PredicateNotEqual, value: "established"},
+		{op: PredicateRegex, value: "^ethernet-1/", v: str("ethernet-1/1"), exp: true},
+		{op: PredicateRegex, value: "^ethernet-1/", v: str("mgmt0")},
+		{op: PredicateLess, value: "1500", v: num(1400), exp: true},
+		{op: PredicateLess, value: "1500", v: num(1500)},
+		{op: PredicateLessEqual, value: "1500", v: num(1500), exp: true},
+		{op: PredicateGreater, value: "9000", v: str("9216"), exp: true},
+		{op: PredicateGreaterEqual, value: "9000", v: num(8000)},
+		{op: PredicateGreater, value: "9000", v: str("jumbo")},
+		{op: PredicateExists, v: str(""), exp: true},
+		{op: PredicateExists},
+	}
+	for _, tt := range tests {
+		p, err := NewPredicate(Xpath2GnmiPath("/interface", 0), "state/oper-state", tt.op, tt.value)
+		if err != nil {
+			t.Fatalf("NewPredicate: %v", err)
+		}
+		if got := p.Match(tt.v); got != tt.exp {
+			t.Errorf("%s%s with %v: got %t, want %t", tt.op, tt.value, tt.v, got, tt.exp)
+		}
+	}
+}
+
+func TestPredicateEntry(t *testing.T) {
+	p, err := NewPredicate(Xpath2GnmiPath("/interface[name=*]/subinterface", 0), "state/oper-state", PredicateEqual, "up")
+	if err != nil {
+		t.Fatalf("NewPredicate: %v", err)
+	}
+	tests := []struct {
+		leaf   string
+		entry  string
+		isLeaf bool
+	}{
+		{leaf: "/interface[name=e1]/subinterface[index=0]/state/oper-state", entry: "/interface[name=e1]/subinterface[index=0]", isLeaf: true},
+		{leaf: "/interface[name=e1]/subinterface[index=0]/index", entry: "/interface[name=e1]/subinterface[index=0]"},
+		{leaf: "/interface[name=e1]/subinterface[index=0]/state/admin-state", entry: "/interface[name=e1]/subinterface[index=0]"},
+		{leaf: "/interface[name=e1]/oper-state"},
+		{leaf: "/system/name"},
+	}
+	for _, tt := range tests {
+		l := Xpath2GnmiPath(tt.leaf, 0).GetElem()
+		var entry string
+		if e, ok := p.Entry(l); ok {
+			entry = GnmiPath2XPath(&gnmi.Path{Elem: e}, true)
+		}
+		if entry != tt.entry {
+			t.Errorf("%s: entry got %q, want %q", tt.leaf, entry, tt.entry)
+		}
+		if got := p.IsLeaf(l); got != tt.isLeaf {
+			t.Errorf("%s: IsLeaf got %t, want %t", tt.leaf, got, tt.isLeaf)
+		}
+	}
+	lp := p.LeafPath(Xpath2GnmiPath("/interface[name=e1]/subinterface[index=0]", 0).GetElem())
+	if got, exp := GnmiPath2XPath(lp, true), "/interface[name=e1]/subinterface[index=0]/state/oper-state"; got != exp {
+		t.Errorf("LeafPath: got %s, want %s", got, exp)
+	}
+}
@@ -0,0 +1,26 @@
+package yparser
+
+import (
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// testSchema returns the schema shared by the tests, a root with the interface
+// list keyed by name. The tests extend it with addEntry and by setting the
+// attributes of the entries they need.
+func testSchema() *yentry.Entry {
+	rs := &yentry.Entry{Name: "root"}
+	addEntry(rs, &yentry.Entry{Name: "interface", Key: []string{"name"},
+		LeafTypes: map[string]string{"name": "string"},
+	})
+	return rs
+}
+
+// addEntry adds the entry e as child of the entry parent and returns e.
+func addEntry(parent, e *yentry.Entry) *yentry.Entry {
+	e.Parent = parent
+	if parent.Children == nil {
+		parent.Children = map[string]*yentry.Entry{}
+	}
+	parent.Children[e.Name] = e
+	return e
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Yndd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package yparser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/yndd/ndd-yang/pkg/container"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+)
+
+// ResolveUnionType returns the first member type of the union matching the
+// value v.
+func ResolveUnionType(ts []*container.UnionType, v interface{}) (*container.UnionType, error) {
+	for _, t := range ts {
+		if matchUnionType(t, v) {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%v does not match any union member type", v)
+}
+
+// ResolveUnion returns the member type matching the value v of the union leaf
+// at path p, nil is returned if the leaf is not a union.
+func ResolveUnion(rs *yentry.Entry, p *gnmi.Path, v interface{}) (*container.UnionType, error) {
+	elems := p.GetElem()
+	if len(elems) == 0 {
+		return nil, nil
+	}
+	ts := rs.GetEntry(&gnmi.Path{Elem: elems[:len(elems)-1]}).GetUnionTypes(elems[len(elems)-1].GetName())
+	if len(ts) == 0 {
+		return nil, nil
+	}
+	return ResolveUnionType(ts, v)
+}
+
+func matchUnionType(t *container.UnionType, v interface{}) bool {
+	if _, ok := v.(bool); ok {
+		return t.Kind == "boolean"
+	}
+	s := keyString(v)
+	switch t.Kind {
+	case "int8", "int16", "int32", "int64":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(t.Kind, "int"))
+		i, err := strconv.ParseInt(s, 10, bits)
+		return err == nil && inRange(t.Range, int(i))
+	case "uint8", "uint16", "uint32", "uint64":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(t.Kind, "uint"))
+		i, err := strconv.ParseUint(s, 10, bits)
+		return err == nil && (i > uint64(^uint(0)>>1) || inRange(t.Range, int(i)))
+	case "decimal64":
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	case "boolean":
+		return s == "true" || s == "false"
+	case "enumeration":
+		return contains(t.Enum, StripModulePrefix(s))
+	case "identityref":
+		return contains(t.Identities, StripModulePrefix(s))
+	case "empty":
+		return v == nil
+	}
+	// string, leafref and the other types are matched as strings
+	if _, ok := v.(string); !ok {
+		return false
+	}
+	if len(t.Length) != 0 && !inRange(t.Length, len(s)) {
+		return false
+	}
+	for _, re := range unionPatterns(t) {
+		if !re.MatchString(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// patterns holds the compiled patterns per union member type, the member
+// types of the schema are matched for every value.
+var patterns sync.Map
+
+// unionPatterns returns the compiled patterns of the member type t. XSD
+// expressions without a go equivalent are not enforced.
+func unionPatterns(t *container.UnionType) []*regexp.Regexp {
+	if res, ok := patterns.Load(t); ok {
+		return res.([]*regexp.Regexp)
+	}
+	res := make([]*regexp.Regexp, 0, len(t.Pattern))
+	for _, pattern := range t.Pattern {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			continue
+		}
+		res = append(res, re)
+	}
+	patterns.Store(t, res)
+	return res
+}
+
+// inRange returns true if i is in one of the min/max pairs of r or r is empty.
+func inRange(r []int, i int) bool {
+	if len(r) == 0 {
+		return true
+	}
+	for j := 0; j+1 < len(r); j += 2 {
+		if i >= r[j] && i <= r[j+1] {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeUnionValue returns the value v as it is encoded in JSON IETF for the
+// member type t, 64 bit numbers and decimals are encoded as strings.
+func EncodeUnionValue(t *container.UnionType, v interface{}) interface{} {
+	s := keyString(v)
+	switch t.Kind {
+	case "int8", "int16", "int32", "uint8", "uint16", "uint32":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case "enumeration", "identityref":
+		return StripModulePrefix(s)
+	case "empty":
+		return v
+	}
+	return s
+}
+
+// GetUnionTypedValue returns the gnmi typed value of the value v for the
+// member type t.
+func GetUnionTypedValue(t *container.UnionType, v interface{}) *gnmi.TypedValue {
+	s := keyString(v)
+	switch t.Kind {
+	case "int8", "int16", "int32", "int64":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: i}}
+		}
+	case "uint8", "uint16", "uint32", "uint64":
+		if i, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: i}}
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_BoolVal{BoolVal: b}}
+		}
+	case "decimal64":
+		if d, ok := parseDecimal(s); ok {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_DecimalVal{DecimalVal: d}}
+		}
+	}
+	return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: keyString(EncodeUnionValue(t, v))}}
+}
+
+func parseDecimal(s string) (*gnmi.Decimal64, bool) {
+	frac := ""
+	if i := strings.Index(s, "."); i >= 0 {
+		s, frac = s[:i], s[i+1:]
+	}
+	digits, err := strconv.ParseInt(s+frac, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &gnmi.Decimal64{Digits: digits, Precision: uint32(len(frac))}, true
+}
+
+// encodeUnionLeaf returns the value v of the union leaf or leaf-list name of
+// e encoded for the resolved member type, the value is returned unmodified if
+// the leaf is not a union or no member type matches.
+func encodeUnionLeaf(e *yentry.Entry, name string, v interface{}) interface{} {
+	ts := e.GetUnionTypes(name)
+	if len(ts) == 0 {
+		return v
+	}
+	if l, ok := v.([]interface{}); ok {
+		nl := make([]interface{}, 0, len(l))
+		for _, lv := range l {
+			nl = append(nl, encodeUnionLeaf(e, name, lv))
+		}
+		return nl
+	}
+	t, err := ResolveUnionType(ts, v)
+	if err != nil {
+		return v
+	}
+	return EncodeUnionValue(t, v)
+}
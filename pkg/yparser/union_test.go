@@ -0,0 +1,187 @@
+package yparser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/yndd/ndd-yang/pkg/container"
+	"github.com/yndd/ndd-yang/pkg/yentry"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ipv4 = `(([0-9]|[1-9][0-9]|1[0-9][0-9]|2[0-4][0-9]|25[0-5])\.){3}([0-9]|[1-9][0-9]|1[0-9][0-9]|2[0-4][0-9]|25[0-5])`
+	// ip address or keyword
+	ipOrAny = []*container.UnionType{
+		{Kind: "string", Pattern: []string{ipv4}},
+		{Kind: "enumeration", Enum: []string{"any"}},
+	}
+	// number or keyword
+	idOrAuto = []*container.UnionType{
+		{Kind: "uint32", Range: []int{1, 4094}},
+		{Kind: "enumeration", Enum: []string{"auto"}},
+	}
+)
+
+func TestGetUnionTypes(t *testing.T) {
+	enum := yang.NewEnumType()
+	enum.Set("any", 0)
+	ut := &yang.YangType{Kind: yang.Yunion, Type: []*yang.YangType{
+		{Kind: yang.Yunion, Type: []*yang.YangType{
+			{Kind: yang.Ystring, Pattern: []string{ipv4}},
+		}},
+		{Kind: yang.Yenum, Enum: enum},
+		{Kind: yang.Yuint16, Range: yang.YangRange{{Min: yang.FromInt(1), Max: yang.FromInt(10)}}},
+	}}
+	exp := []*container.UnionType{
+		{Kind: "string", Pattern: []string{ipv4}},
+		{Kind: "enumeration", Enum: []string{"any"}},
+		{Kind: "uint16", Range: []int{1, 10}},
+	}
+	if got := GetUnionTypes(ut); !reflect.DeepEqual(got, exp) {
+		t.Errorf("GetUnionTypes: got %v, want %v", got, exp)
+	}
+}
+
+func TestResolveUnionType(t *testing.T) {
+	tests := []struct {
+		name  string
+		ts    []*container.UnionType
+		value interface{}
+		kind  string
+		enc   interface{}
+		tv    *gnmi.TypedValue
+		err   bool
+	}{
+		{name: "ip", ts: ipOrAny, value: "10.0.0.1", kind: "string", enc: "10.0.0.1",
+			tv: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "10.0.0.1"}}},
+		{name: "keyword", ts: ipOrAny, value: "mod:any", kind: "enumeration", enc: "any",
+			tv: &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "any"}}},
+		{name: "invalid ip", ts: ipOrAny, value: "10.0.0.256", err: true},
+		{name: "number string", ts: idOrAuto, value: "100", kind: "uint32", enc: float64(100),
+			tv: &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 100}}},
+		{name: "number", ts: idOrAuto, value: float64(100), kind: "uint32", enc: float64(100),
+			tv: &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 100}}},
+		{name: "out of range", ts: idOrAuto, value: float64(5000), err: true},
+		{name: "bool", ts: idOrAuto, value: true, err: true},
+		{name: "decimal", ts: []*container.UnionType{{Kind: "decimal64"}}, value: "1.50", kind: "decimal64", enc: "1.50",
+			tv: &gnmi.TypedValue{Value: &gnmi.TypedValue_DecimalVal{DecimalVal: &gnmi.Decimal64{Digits: 150, Precision: 2}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut, err := ResolveUnionType(tt.ts, tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("ResolveUnionType: got %v, want error %t", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if ut.Kind != tt.kind {
+				t.Errorf("ResolveUnionType: got %s, want %s", ut.Kind, tt.kind)
+			}
+			if got := EncodeUnionValue(ut, tt.value); !reflect.DeepEqual(got, tt.enc) {
+				t.Errorf("EncodeUnionValue: got %#v, want %#v", got, tt.enc)
+			}
+			if got := GetUnionTypedValue(ut, tt.value); !proto.Equal(got, tt.tv) {
+				t.Errorf("GetUnionTypedValue: got %v, want %v", got, tt.tv)
+			}
+		})
+	}
+}
+
+func unionSchema() *yentry.Entry {
+	rs := testSchema()
+	addEntry(rs, &yentry.Entry{Name: "vlan", Key: []string{"id"},
+		Unions: map[string][]*container.UnionType{"id": idOrAuto, "peer": ipOrAny},
+	})
+	return rs
+}
+
+func TestUnionValues(t *testing.T) {
+	rs := unionSchema()
+	p := &gnmi.Path{}
+	d := map[string]interface{}{
+		"vlan": []interface{}{
+			map[string]interface{}{"id": float64(1000000), "peer": "x:any"},
+		},
+	}
+	// the key is rendered without exponent, the id is out of range so the
+	// value is not encoded for a member type
+	upds, err := GetGranularUpdatesFromJSON(p, d, rs)
+	if err != nil {
+		t.Fatalf("GetGranularUpdatesFromJSON: %v", err)
+	}
+	got := make(map[string]string)
+	for _, u := range upds {
+		got[GnmiPath2XPath(u.GetPath(), true)] = string(u.GetVal().GetJsonIetfVal())
+	}
+	exp := map[string]string{
+		"/vlan[id=1000000]/id":   `"1000000"`,
+		"/vlan[id=1000000]/peer": `"x:any"`,
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("GetGranularUpdatesFromJSON: got %v, want %v", got, exp)
+	}
+	if err := ValidateValues(rs, p, d); err == nil {
+		t.Errorf("ValidateValues: want error for id out of range")
+	}
+
+	d = map[string]interface{}{
+		"vlan": []interface{}{
+			map[string]interface{}{"id": "10", "peer": "x:any"},
+		},
+	}
+	if err := ValidateValues(rs, p, d); err != nil {
+		t.Errorf("ValidateValues: %v", err)
+	}
+	upds, err = GetGranularUpdatesFromJSON(p, d, rs, WithNormalizedValues())
+	if err != nil {
+		t.Fatalf("GetGranularUpdatesFromJSON: %v", err)
+	}
+	got = make(map[string]string)
+	for _, u := range upds {
+		got[GnmiPath2XPath(u.GetPath(), true)] = string(u.GetVal().GetJsonIetfVal())
+	}
+	exp = map[string]string{
+		"/vlan[id=10]/id":   `10`,
+		"/vlan[id=10]/peer": `"any"`,
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("GetGranularUpdatesFromJSON normalized: got %v, want %v", got, exp)
+	}
+}
+
+func TestValidateLeafRefUnion(t *testing.T) {
+	rs := unionSchema()
+	p := Xpath2GnmiPath("/vlan[id=10]", 0)
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		err  bool
+	}{
+		{name: "ip", data: map[string]interface{}{"id": "10", "peer": "10.0.0.1"}},
+		{name: "keyword", data: map[string]interface{}{"id": "10", "peer": "x:any"}},
+		{name: "invalid ip", data: map[string]interface{}{"id": "10", "peer": "10.0.0.256"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ValidateLeafRef(p, tt.data, map[string]interface{}{}, nil, rs); (err != nil) != tt.err {
+				t.Errorf("ValidateLeafRef: got %v, want error %t", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnionPatterns(t *testing.T) {
+	ut := &container.UnionType{Kind: "string", Pattern: []string{ipv4, "("}}
+	res := unionPatterns(ut)
+	// the invalid pattern is not enforced
+	if len(res) != 1 {
+		t.Fatalf("unionPatterns: got %d patterns, want 1", len(res))
+	}
+	if got := unionPatterns(ut); len(got) != 1 || got[0] != res[0] {
+		t.Errorf("unionPatterns: the patterns are compiled again")
+	}
+}
@@ -18,6 +18,7 @@ package yparser
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -49,6 +50,65 @@ func GetTypeKind(e *yang.Entry) string {
 	return e.Type.Kind.String()
 }
 
+// GetDerivedIdentities returns the names of the identities derived from the
+// base identity
+func GetDerivedIdentities(base *yang.Identity) []string {
+	seen := make(map[string]bool)
+	var walk func(id *yang.Identity)
+	walk = func(id *yang.Identity) {
+		for _, v := range id.Values {
+			if !seen[v.Name] {
+				seen[v.Name] = true
+				walk(v)
+			}
+		}
+	}
+	walk(base)
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// GetUnionTypes returns the member types of a union in the order they are
+// defined, the members of nested unions are flattened.
+func GetUnionTypes(t *yang.YangType) []*container.UnionType {
+	ts := make([]*container.UnionType, 0, len(t.Type))
+	for _, m := range t.Type {
+		if m.Kind == yang.Yunion {
+			ts = append(ts, GetUnionTypes(m)...)
+			continue
+		}
+		ut := &container.UnionType{
+			Kind:    m.Kind.String(),
+			Pattern: m.Pattern,
+		}
+		for _, ra := range m.Range {
+			min, errMin := ra.Min.Int()
+			max, errMax := ra.Max.Int()
+			if errMin != nil || errMax != nil {
+				// the range is not representable, e.g. uint64 max or decimals
+				ut.Range = nil
+				break
+			}
+			ut.Range = append(ut.Range, int(min), int(max))
+		}
+		for _, le := range m.Length {
+			ut.Length = append(ut.Length, int(le.Min.Value), int(le.Max.Value))
+		}
+		if m.Enum != nil {
+			ut.Enum = m.Enum.Names()
+		}
+		if m.IdentityBase != nil {
+			ut.Identities = GetDerivedIdentities(m.IdentityBase)
+		}
+		ts = append(ts, ut)
+	}
+	return ts
+}
+
 // CreatePathElem returns a config path element from a yang Entry
 // used by ygen
 func CreatePathElem(e *yang.Entry) *gnmi.PathElem {
@@ -86,6 +146,13 @@ func CreateContainerEntry(e *yang.Entry, next, prev *container.Container, contai
 
 	entry.NameSpace = e.Namespace().Name
 
+	// register the entry as a member of the case it belongs to
+	if prev != nil {
+		for choice, cs := range container.GetChoiceCases(e) {
+			prev.AddChoiceMember(choice, cs, e.Name)
+		}
+	}
+
 	//if e.Name == "id" {
 	//	fmt.Printf("id: type: %s kind: %s, yangEntry: %#v\n", GetTypeName(e), GetTypeKind(e), e)
 	//}
@@ -139,6 +206,7 @@ func CreateContainerEntry(e *yang.Entry, next, prev *container.Container, contai
 		case "union":
 			entry.Type = "string"
 			entry.Union = true
+			entry.UnionTypes = GetUnionTypes(e.Type)
 			for _, t := range e.Type.Type {
 				//fmt.Printf("Union: %s, Type: %s\n", e.Name, t.Root.Kind.String())
 				entry.Type = t.Root.Kind.String()
@@ -177,6 +245,12 @@ func CreateContainerEntry(e *yang.Entry, next, prev *container.Container, contai
 	if e.Type != nil && e.Type.Enum != nil {
 		entry.Enum = e.Type.Enum.Names()
 	}
+	// identityref
+	entry.Kind = GetTypeKind(e)
+	if e.Type != nil && e.Type.IdentityBase != nil {
+		entry.IdentityBase = e.Type.IdentityBase.Name
+		entry.Identities = GetDerivedIdentities(e.Type.IdentityBase)
+	}
 	// update the Type to reflect the reference to the proper struct
 	if entry.Prev != nil {
 		// special case for the first entry in the full schema we use the same pointer for previous and next